@@ -9,8 +9,9 @@ import (
 type Change struct {
 	When        time.Time `toml:"date"`
 	Body        string    `toml:"description"`
-	Version     string    `toml:'version'`
+	Version     string    `toml:"version"`
 	Distrib     []string  `toml:"distrib"`
+	Urgency     string    `toml:"urgency"`
 	Changes     []Change  `toml:"changes"`
 	*Maintainer `toml:"maintainer"`
 }
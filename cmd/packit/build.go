@@ -2,18 +2,20 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/midbel/cli"
 	"github.com/midbel/packit"
-	"github.com/midbel/packit/deb"
 	"github.com/midbel/packit/deb/control"
-	"github.com/midbel/packit/rpm"
-	"github.com/midbel/toml"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -25,15 +27,40 @@ const (
 func runBuild(cmd *cli.Command, args []string) error {
 	format := cmd.Flag.String("k", "", "package format")
 	datadir := cmd.Flag.String("d", os.TempDir(), "datadir")
+	bump := cmd.Flag.Bool("bump-release", false, "bump release of previous package")
+	strict := cmd.Flag.Bool("strict", false, "strict validation of package metadata")
+	follow := cmd.Flag.Bool("follow-symlinks", false, "follow symlinks to directories in sources")
+	strip := cmd.Flag.Bool("strip-debug", false, "strip debug sections from ELF files")
+	threads := cmd.Flag.Int("compress-threads", 0, "number of threads used to compress zstd payload")
+	owner := cmd.Flag.String("owner", "", "default owner of files")
+	group := cmd.Flag.String("group", "", "default group of files")
+	output := cmd.Flag.String("o", "", "output file, - for stdout")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
+	if *output != "" && (strings.Contains(*format, ",") || cmd.Flag.NArg() > 1) {
+		return fmt.Errorf("output file can only be used to build one package")
+	}
 
 	if err := os.MkdirAll(*datadir, 0755); err != nil && !os.IsExist(err) {
 		return err
 	}
 
-	var group errgroup.Group
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	opts := buildOptions{
+		Format:  *format,
+		Datadir: *datadir,
+		Bump:    *bump,
+		Strict:  *strict,
+		Follow:  *follow,
+		Strip:   *strip,
+		Threads: *threads,
+		Owner:   *owner,
+		Group:   *group,
+	}
+	var grp errgroup.Group
 	for _, a := range cmd.Flag.Args() {
 		if s, err := os.Stat(a); err != nil {
 			continue
@@ -43,34 +70,138 @@ func runBuild(cmd *cli.Command, args []string) error {
 			}
 		}
 		a := a
-		group.Go(func() error {
-			b, err := prepare(a, *format)
+		grp.Go(func() error {
+			mf, err := prepare(a, opts)
 			if err != nil {
 				return err
 			}
-			w, err := os.Create(filepath.Join(*datadir, b.PackageName()))
-			if err != nil {
-				return err
+			release := mf.Release
+			for _, f := range strings.Split(opts.Format, ",") {
+				mf.Release = release
+				b, err := buildPackage(mf, strings.TrimSpace(f))
+				if err != nil {
+					return err
+				}
+				if opts.Bump {
+					if err := bumpRelease(mf.Control, opts.Datadir, filepath.Ext(b.PackageName())); err != nil {
+						return err
+					}
+				}
+				switch *output {
+				case "":
+					err = writePackage(ctx, b, *datadir)
+				case "-":
+					err = b.BuildContext(ctx, os.Stdout)
+				default:
+					err = writePackageFile(ctx, b, *output)
+				}
+				if err != nil {
+					return err
+				}
+				warn(b)
 			}
-			defer w.Close()
-			return b.Build(w)
+			return nil
 		})
 	}
-	return group.Wait()
+	return grp.Wait()
+}
+
+type buildOptions struct {
+	Format  string
+	Datadir string
+	Bump    bool
+	Strict  bool
+	Follow  bool
+	Strip   bool
+	Threads int
+	Owner   string
+	Group   string
+}
+
+func writePackage(ctx context.Context, b packit.Builder, datadir string) error {
+	return writePackageFile(ctx, b, filepath.Join(datadir, b.PackageName()))
+}
+
+func writePackageFile(ctx context.Context, b packit.Builder, file string) error {
+	w, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := b.BuildContext(ctx, w); err != nil {
+		w.Close()
+		os.Remove(file)
+		return err
+	}
+	return w.Close()
+}
+
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		defer signal.Stop(sig)
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+func warn(v interface{}) {
+	w, ok := v.(packit.Warner)
+	if !ok {
+		return
+	}
+	for _, m := range w.Warnings() {
+		fmt.Fprintln(os.Stderr, "warning:", m)
+	}
 }
 
 func runConvert(cmd *cli.Command, args []string) error {
 	who := cmd.Flag.String("m", "", "maintainer")
 	datadir := cmd.Flag.String("d", os.TempDir(), "data directory")
 	format := cmd.Flag.String("k", "", "package format")
+	dry := cmd.Flag.Bool("dry-run", false, "report how metadata are converted without building")
+	out := cmd.Flag.String("o", "", "output file, package or makefile (.toml)")
+	weak := cmd.Flag.Bool("w", false, "write weak dependencies")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	switch *format {
-	case "", "deb", "rpm":
-	default:
+	var (
+		output = *out
+		files  = cmd.Flag.Args()
+	)
+	// a second argument is only taken as the output when it is not a package
+	// of the same format as the first one: convert -k rpm a.deb b.deb
+	// converts both packages.
+	if output == "" && len(files) == 2 {
+		src, _ := packit.FormatByExt(filepath.Ext(files[0]))
+		if f, ok := packit.FormatByExt(filepath.Ext(files[1])); ok && f.Name != src.Name {
+			output, files = files[1], files[:1]
+		} else if filepath.Ext(files[1]) == ".toml" {
+			output, files = files[1], files[:1]
+		}
+	}
+	if output != "" {
+		if len(files) != 1 {
+			return fmt.Errorf("output %s: only one package can be converted", output)
+		}
+		if f, ok := packit.FormatByExt(filepath.Ext(output)); ok {
+			if *format != "" && *format != f.Name {
+				return fmt.Errorf("output %s: not a %s package", output, *format)
+			}
+			*format = f.Name
+		}
+	}
+	if *format != "" && !packit.HasBuilder(*format) {
 		return fmt.Errorf("unsupported packet type %s", *format)
 	}
+	ctx, cancel := interruptContext()
+	defer cancel()
+
 	var maintainer *packit.Maintainer
 	switch *who {
 	case "", "-":
@@ -83,61 +214,162 @@ func runConvert(cmd *cli.Command, args []string) error {
 		}
 		maintainer = m
 	}
-	return showPackages(cmd.Flag.Args(), func(p packit.Package) error {
+	return showPackages(files, func(p packit.Package) error {
 		if p.PackageType() == *format {
 			return nil
 		}
-		workdir := filepath.Join(os.TempDir(), p.PackageType(), p.PackageName())
-		if err := os.RemoveAll(workdir); err != nil {
-			return err
+		if *dry {
+			return reportMapping(p, *format, *weak)
 		}
-		if err := os.MkdirAll(workdir, 0755); err != nil && !os.IsExist(err) {
-			return err
-		}
-		if err := p.Extract(workdir, false); err != nil {
+		rs, err := p.Resources()
+		if err != nil {
 			return err
 		}
-		var mf packit.Makefile
-		filepath.Walk(workdir, func(p string, i os.FileInfo, err error) error {
-			if err != nil {
+		// the sources of a makefile are kept next to it, the ones of a
+		// package only live until it is built.
+		var workdir string
+		if filepath.Ext(output) == ".toml" {
+			workdir = strings.TrimSuffix(output, ".toml") + ".d"
+			if err := os.MkdirAll(workdir, 0755); err != nil {
 				return err
 			}
-			if i.IsDir() {
-				return nil
-			}
-			f := packit.File{
-				Src:  p,
-				Dst:  strings.TrimPrefix(p, workdir),
-				Name: filepath.Base(p),
-				Perm: int(i.Mode()),
-				Conf: packit.IsConfFile(p),
+		} else {
+			if workdir, err = ioutil.TempDir("", "packit-convert-"); err != nil {
+				return err
 			}
-			mf.Files = append(mf.Files, &f)
-			return nil
-		})
+			defer os.RemoveAll(workdir)
+		}
+		if err := p.Extract(workdir, packit.ExtractOptions{}); err != nil {
+			return err
+		}
 		c := p.About()
 		if maintainer != nil {
 			c.Maintainer = maintainer
 		}
-		mf.Control = &c
+		mf := packit.Makefile{
+			Control:  &c,
+			Files:    convertFiles(rs, workdir, c.ConfFiles),
+			WeakDeps: *weak,
+		}
+		if s, ok := p.(packit.Scripted); ok {
+			ss := s.Scripts()
+			mf.Preinst, mf.Postinst = ss[packit.ScriptPreinst], ss[packit.ScriptPostinst]
+			mf.Prerm, mf.Postrm = ss[packit.ScriptPrerm], ss[packit.ScriptPostrm]
+		}
 		for _, c := range p.History() {
+			c := c
 			mf.Changes = append(mf.Changes, &c)
 		}
+		warn(p)
 
+		if filepath.Ext(output) == ".toml" {
+			return writeMakefile(&mf, output)
+		}
 		b, err := buildPackage(&mf, *format)
 		if err != nil {
 			return err
 		}
-		w, err := os.Create(filepath.Join(*datadir, b.PackageName()))
-		if err != nil {
-			return err
+		if output != "" {
+			return writePackageFile(ctx, b, output)
 		}
-		defer w.Close()
-
-		return b.Build(w)
+		return writePackage(ctx, b, *datadir)
 	})
 }
 
+// convertFiles gives the files of a package from its resources, reading the
+// content of regular files from the payload extracted under workdir.
+// Directories are only kept when no other resource is found under them.
+func convertFiles(rs []packit.Resource, workdir string, conf []string) []*packit.File {
+	var (
+		fs      []*packit.File
+		parents = make(map[string]struct{})
+		confs   = make(map[string]struct{})
+	)
+	name := func(n string) string {
+		return "/" + strings.TrimPrefix(filepath.Clean("/"+n), "/")
+	}
+	for _, c := range conf {
+		confs[name(c)] = struct{}{}
+	}
+	for _, r := range rs {
+		for d := filepath.Dir(name(r.Name)); d != "/"; d = filepath.Dir(d) {
+			parents[d] = struct{}{}
+		}
+	}
+	for _, r := range rs {
+		n := name(r.Name)
+		if n == "/" {
+			continue
+		}
+		f := packit.File{
+			Dst:     n,
+			Name:    filepath.Base(n),
+			Perm:    int(r.Perm & 07777),
+			ModTime: r.ModTime,
+			Uid:     r.Uid,
+			Gid:     r.Gid,
+			User:    r.User,
+			Group:   r.Group,
+		}
+		switch r.Perm & 0170000 {
+		case 0100000:
+			f.Src = filepath.Join(workdir, n)
+			_, f.Conf = confs[n]
+		case 0120000:
+			f.Type, f.Link = packit.FileLink, r.Link
+		case 0040000:
+			if _, ok := parents[n]; ok {
+				continue
+			}
+			f.Type = packit.FileDir
+		case 0010000:
+			f.Type = packit.FileFifo
+		case 0020000:
+			f.Type, f.Major, f.Minor = packit.FileChar, r.Major, r.Minor
+		case 0060000:
+			f.Type, f.Major, f.Minor = packit.FileBlock, r.Major, r.Minor
+		default:
+			continue
+		}
+		fs = append(fs, &f)
+	}
+	return fs
+}
+
+func reportMapping(p packit.Package, format string, weak bool) error {
+	if format == "" {
+		format = "deb"
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 2, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "%s\t%s -> %s\n", p.PackageName(), p.PackageType(), format)
+	for _, m := range packit.MapFields(p.About(), format, weak) {
+		target := m.Target
+		if m.Dropped {
+			target = "(dropped)"
+		}
+		value := m.Value
+		if i := strings.IndexByte(value, '\n'); i >= 0 {
+			value = value[:i] + "..."
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", m.Field, target, value)
+	}
+	return nil
+}
+
+func writeMakefile(mf *packit.Makefile, file string) error {
+	w, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := mf.WriteTOML(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
 func runPack(cmd *cli.Command, args []string) error {
 	datadir := cmd.Flag.String("d", os.TempDir(), "data directory")
 	format := cmd.Flag.String("k", "", "packet type")
@@ -157,6 +389,9 @@ func mergePackages(pkgs []string, name, datadir, format string) error {
 }
 
 func repackPackages(pkgs []string, datadir, format string) error {
+	ctx, cancel := interruptContext()
+	defer cancel()
+
 	var group errgroup.Group
 	for _, a := range pkgs {
 		a := a
@@ -169,35 +404,87 @@ func repackPackages(pkgs []string, datadir, format string) error {
 			if err != nil {
 				return err
 			}
-			w, err := os.Create(filepath.Join(datadir, b.PackageName()))
-			if err != nil {
-				return err
-			}
-			defer w.Close()
-
-			return b.Build(w)
+			return writePackage(ctx, b, datadir)
 		})
 	}
 	return group.Wait()
 }
 
-func prepare(file, format string) (packit.Builder, error) {
-	var mf packit.Makefile
-	if err := toml.DecodeFile(file, &mf); err != nil {
+func prepare(file string, opts buildOptions) (*packit.Makefile, error) {
+	mf, err := packit.Load(file)
+	if err != nil {
 		return nil, err
 	}
-	return buildPackage(&mf, format)
+	mf.Strict = mf.Strict || opts.Strict
+	mf.FollowSymlinks = mf.FollowSymlinks || opts.Follow
+	mf.StripDebug = mf.StripDebug || opts.Strip
+	if opts.Threads > 0 {
+		mf.Threads = opts.Threads
+	}
+	mf.Owner, mf.Group = opts.Owner, opts.Group
+	if mf.SignKey != "" {
+		s, err := packit.ReadSigner(mf.SignKey, os.Getenv("PACKIT_PASSPHRASE"))
+		if err != nil {
+			return nil, err
+		}
+		mf.Signer = s
+	}
+	return mf, nil
+}
+
+func bumpRelease(c *packit.Control, datadir, ext string) error {
+	if c == nil {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(datadir, c.Package+"?"+c.Version+"*"+ext))
+	if err != nil {
+		return err
+	}
+	var (
+		release int
+		found   bool
+	)
+	for _, f := range files {
+		p, err := openPackage(f)
+		if err != nil {
+			return err
+		}
+		a := p.About()
+		if a.Package != c.Package || a.Version != c.Version {
+			continue
+		}
+		found = true
+		if a.Release == "" {
+			continue
+		}
+		r, err := releaseNumber(a.Release)
+		if err != nil {
+			return fmt.Errorf("%s: can not bump release %s", f, a.Release)
+		}
+		if r > release {
+			release = r
+		}
+	}
+	if found {
+		c.Release = strconv.Itoa(release + 1)
+	}
+	return nil
+}
+
+// releaseNumber gives the leading number of a release so that the dist
+// suffix of rpm releases (1.el9) does not prevent it from being bumped.
+func releaseNumber(r string) (int, error) {
+	if i := strings.IndexFunc(r, func(c rune) bool { return c < '0' || c > '9' }); i >= 0 {
+		r = r[:i]
+	}
+	return strconv.Atoi(r)
 }
 
 func buildPackage(mf *packit.Makefile, format string) (packit.Builder, error) {
-	switch format {
-	case "deb", "":
-		return deb.Build(mf)
-	case "rpm":
-		return rpm.Build(mf)
-	default:
-		return nil, fmt.Errorf("unsupported package type")
+	if format == "" {
+		format = "deb"
 	}
+	return packit.NewBuilder(format, mf)
 }
 
 func makefile(n string) (*packit.Makefile, error) {
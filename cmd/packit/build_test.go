@@ -0,0 +1,525 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/midbel/cli"
+	"github.com/midbel/packit"
+)
+
+func buildFixture(t *testing.T, file, format, dir string) string {
+	t.Helper()
+	mf, err := packit.Load(file)
+	if err != nil {
+		t.Fatalf("%s: %s", file, err)
+	}
+	b, err := buildPackage(mf, format)
+	if err != nil {
+		t.Fatalf("%s: %s", file, err)
+	}
+	name := filepath.Join(dir, b.PackageName())
+	w, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := b.Build(w); err != nil {
+		t.Fatalf("%s: build %s: %s", file, format, err)
+	}
+	return name
+}
+
+func TestBumpRelease(t *testing.T) {
+	for _, d := range []struct {
+		Format string
+		Want   string
+	}{
+		{Format: "rpm", Want: "4"},
+		{Format: "deb", Want: "4"},
+	} {
+		datadir := t.TempDir()
+		buildFixture(t, "testdata/bump/tool.toml", d.Format, datadir)
+		c := packit.Control{Package: "tool", Version: "2.4.1", Release: "1", Dist: "el9"}
+		if err := bumpRelease(&c, datadir, "."+d.Format); err != nil {
+			t.Errorf("%s: %s", d.Format, err)
+			continue
+		}
+		if c.Release != d.Want {
+			t.Errorf("%s: want release %s, got %s", d.Format, d.Want, c.Release)
+		}
+	}
+}
+
+func TestReleaseNumber(t *testing.T) {
+	data := []struct {
+		Release string
+		Want    int
+		Err     bool
+	}{
+		{Release: "1", Want: 1},
+		{Release: "12.el9", Want: 12},
+		{Release: "3.fc38.1", Want: 3},
+		{Release: "0ubuntu2", Want: 0},
+		{Release: "el9", Err: true},
+	}
+	for _, d := range data {
+		got, err := releaseNumber(d.Release)
+		if d.Err {
+			if err == nil {
+				t.Errorf("%s: expected error, got %d", d.Release, got)
+			}
+			continue
+		}
+		if err != nil || got != d.Want {
+			t.Errorf("%s: want %d, got %d (%v)", d.Release, d.Want, got, err)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	var (
+		tmp   = t.TempDir()
+		hello = buildFixture(t, "testdata/convert/hello.toml", "deb", tmp)
+		world = buildFixture(t, "testdata/convert/world.toml", "deb", tmp)
+	)
+	output := filepath.Join(tmp, "hello.rpm")
+	if err := runConvert(&cli.Command{}, []string{hello, output}); err != nil {
+		t.Fatalf("convert: %s", err)
+	}
+	p, err := packit.Open(output)
+	if err != nil {
+		t.Fatalf("open converted package: %s", err)
+	}
+	if c := p.About(); p.PackageType() != "rpm" || c.Package != "hello" || c.Version != "0.3.0" || c.Epoch != 1 {
+		t.Errorf("converted package: got %s %s %d:%s", p.PackageType(), c.Package, c.Epoch, c.Version)
+	}
+	rs, err := p.Resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	modes := make(map[string]int64)
+	for _, r := range rs {
+		n := filepath.Clean("/" + r.Name)
+		modes[n] = r.Perm & 07777
+		if n == "/usr/bin/hello" && r.Gid != 50 {
+			t.Errorf("%s: want gid 50, got %d", n, r.Gid)
+		}
+	}
+	if m := modes["/usr/bin/hello"]; m != 0750 {
+		t.Errorf("/usr/bin/hello: want mode 0750, got %o", m)
+	}
+	if _, ok := modes["/usr/share/man/man1/hello.1"]; !ok {
+		t.Errorf("man page missing from converted package: %v", modes)
+	}
+
+	datadir := t.TempDir()
+	if err := runConvert(&cli.Command{}, []string{"-k", "rpm", "-d", datadir, hello, world}); err != nil {
+		t.Fatalf("convert two packages: %s", err)
+	}
+	for _, n := range []string{"hello", "world"} {
+		ms, _ := filepath.Glob(filepath.Join(datadir, n+"-*.rpm"))
+		if len(ms) != 1 {
+			t.Errorf("%s: converted package not found in %s", n, datadir)
+		}
+	}
+	if err := runConvert(&cli.Command{}, []string{"-o", output, hello, world}); err == nil {
+		t.Errorf("convert: expected error when output is set for two packages")
+	}
+}
+
+func TestConvertWeakDeps(t *testing.T) {
+	var (
+		tmp    = t.TempDir()
+		viewer = buildFixture(t, "testdata/convert/viewer.toml", "deb", tmp)
+	)
+	for _, d := range []struct {
+		Args     []string
+		Suggests int
+	}{
+		{Args: nil, Suggests: 0},
+		{Args: []string{"-w"}, Suggests: 1},
+	} {
+		output := filepath.Join(t.TempDir(), "viewer.rpm")
+		args := append(d.Args, "-o", output, viewer)
+		if err := runConvert(&cli.Command{}, args); err != nil {
+			t.Fatalf("convert %q: %s", args, err)
+		}
+		p, err := packit.Open(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := p.About(); len(c.Suggests) != d.Suggests {
+			t.Errorf("convert %q: want %d suggests, got %q", args, d.Suggests, c.Suggests)
+		}
+	}
+}
+
+func TestConvertDryRun(t *testing.T) {
+	var (
+		tmp    = t.TempDir()
+		viewer = buildFixture(t, "testdata/convert/viewer.toml", "deb", tmp)
+		output = filepath.Join(tmp, "viewer.rpm")
+	)
+	out, err := stdout(t, func() error {
+		return runConvert(&cli.Command{}, []string{"--dry-run", viewer, output})
+	})
+	if err != nil {
+		t.Fatalf("convert --dry-run: %s", err)
+	}
+	if !strings.Contains(out, "deb -> rpm") {
+		t.Errorf("convert --dry-run: mapping not reported: %q", out)
+	}
+	if _, err := os.Stat(output); err == nil {
+		t.Errorf("convert --dry-run: %s built", output)
+	}
+}
+
+func TestBuildOwner(t *testing.T) {
+	for _, format := range []string{"deb", "rpm"} {
+		output := filepath.Join(t.TempDir(), "site."+format)
+		args := []string{"--owner", "www-data", "--group", "www-data", "-k", format, "-o", output, "testdata/owner/site.toml"}
+		if err := runBuild(&cli.Command{}, args); err != nil {
+			t.Fatalf("%s: build: %s", format, err)
+		}
+		p, err := packit.Open(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs, err := p.Resources()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{
+			"/var/www/site/index.html": "www-data",
+			"/var/www/site/style.css":  "www-data",
+			"/etc/site/site.conf":      "www-data",
+			"/usr/bin/site-deploy":     "root",
+		}
+		for _, r := range rs {
+			n := filepath.Clean("/" + r.Name)
+			w, ok := want[n]
+			if !ok {
+				continue
+			}
+			delete(want, n)
+			if r.User != w || r.Group != w {
+				t.Errorf("%s: %s: want owner %s:%s, got %s:%s", format, n, w, w, r.User, r.Group)
+			}
+		}
+		for n := range want {
+			t.Errorf("%s: %s not found in package", format, n)
+		}
+	}
+}
+
+func TestBuildBumpRelease(t *testing.T) {
+	for _, format := range []string{"deb", "rpm"} {
+		datadir := t.TempDir()
+		var releases []string
+		for i := 0; i < 2; i++ {
+			if err := runBuild(&cli.Command{}, []string{"--bump-release", "-k", format, "-d", datadir, "testdata/bump/foo.toml"}); err != nil {
+				t.Fatalf("%s: build %d: %s", format, i+1, err)
+			}
+			ms, _ := filepath.Glob(filepath.Join(datadir, "foo*."+format))
+			releases = releases[:0]
+			for _, m := range ms {
+				p, err := openPackage(m)
+				if err != nil {
+					t.Fatal(err)
+				}
+				releases = append(releases, p.About().Release)
+			}
+		}
+		if len(releases) != 2 || releases[0] != "1" || releases[1] != "2" {
+			t.Errorf("%s: want releases [1 2], got %q", format, releases)
+		}
+	}
+}
+
+func TestBuildFormats(t *testing.T) {
+	datadir := t.TempDir()
+	if err := runBuild(&cli.Command{}, []string{"-k", "deb,rpm", "-d", datadir, "testdata/formats/clock.toml"}); err != nil {
+		t.Fatalf("build: %s", err)
+	}
+	var cs []packit.Control
+	for _, format := range []string{"deb", "rpm"} {
+		ms, _ := filepath.Glob(filepath.Join(datadir, "*."+format))
+		if len(ms) != 1 {
+			t.Fatalf("%s: want one package, got %q", format, ms)
+		}
+		p, err := packit.Open(ms[0])
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if err := p.Valid(); err != nil {
+			t.Errorf("%s: valid: %s", format, err)
+		}
+		cs = append(cs, p.About())
+	}
+	deb, rpm := cs[0], cs[1]
+	for _, d := range []struct {
+		Field    string
+		Deb, Rpm string
+	}{
+		{Field: "package", Deb: deb.Package, Rpm: rpm.Package},
+		{Field: "version", Deb: deb.Version, Rpm: rpm.Version},
+		{Field: "release", Deb: deb.Release, Rpm: rpm.Release},
+		{Field: "summary", Deb: deb.Summary, Rpm: rpm.Summary},
+		{Field: "homepage", Deb: deb.Home, Rpm: rpm.Home},
+		{Field: "depends", Deb: strings.Join(deb.Depends, ","), Rpm: strings.Join(rpm.Depends, ",")},
+		{Field: "conffiles", Deb: strings.Join(deb.ConfFiles, ","), Rpm: strings.Join(rpm.ConfFiles, ",")},
+	} {
+		if d.Deb != d.Rpm {
+			t.Errorf("%s: deb has %q, rpm has %q", d.Field, d.Deb, d.Rpm)
+		}
+	}
+	if deb.Package != "wallclock" || deb.Version != "2.0.1" || deb.Release != "4" {
+		t.Errorf("unexpected metadata %s-%s-%s", deb.Package, deb.Version, deb.Release)
+	}
+}
+
+func TestBuildOutput(t *testing.T) {
+	var (
+		tmp     = t.TempDir()
+		datadir = filepath.Join(tmp, "datadir")
+		output  = filepath.Join(tmp, "artifacts-world.deb")
+	)
+	if err := runBuild(&cli.Command{}, []string{"-k", "deb", "-d", datadir, "-o", output, "testdata/convert/world.toml"}); err != nil {
+		t.Fatalf("build: %s", err)
+	}
+	p, err := packit.Open(output)
+	if err != nil {
+		t.Fatalf("open %s: %s", output, err)
+	}
+	if c := p.About(); c.Package != "world" || c.Version != "0.3.0" {
+		t.Errorf("unexpected metadata %s-%s", c.Package, c.Version)
+	}
+	if ms, _ := filepath.Glob(filepath.Join(datadir, "*")); len(ms) > 0 {
+		t.Errorf("package written to datadir with -o: %q", ms)
+	}
+
+	out, err := stdout(t, func() error {
+		return runBuild(&cli.Command{}, []string{"-k", "deb", "-o", "-", "testdata/convert/world.toml"})
+	})
+	if err != nil {
+		t.Fatalf("build to stdout: %s", err)
+	}
+	if !strings.HasPrefix(out, "!<arch>\n") {
+		t.Errorf("build to stdout: deb archive not written")
+	}
+
+	for _, args := range [][]string{
+		{"-k", "deb,rpm", "-o", output, "testdata/convert/world.toml"},
+		{"-k", "deb", "-o", output, "testdata/convert/world.toml", "testdata/convert/hello.toml"},
+	} {
+		if err := runBuild(&cli.Command{}, args); err == nil {
+			t.Errorf("build %q: expected error with one output for several packages", args)
+		}
+	}
+}
+
+// manifest is a fake format writing the destination of each file of a
+// makefile, one per line.
+type manifest struct {
+	mf *packit.Makefile
+}
+
+func (m manifest) PackageName() string     { return m.mf.Package + ".manifest" }
+func (m manifest) Build(w io.Writer) error { return m.BuildContext(context.Background(), w) }
+
+func (m manifest) BuildContext(ctx context.Context, w io.Writer) error {
+	for _, f := range m.mf.Files {
+		fmt.Fprintln(w, f.String())
+	}
+	return nil
+}
+
+func init() {
+	packit.RegisterBuilder("manifest", func(mf *packit.Makefile) (packit.Builder, error) {
+		return manifest{mf: mf}, nil
+	})
+}
+
+func TestBuildRegisteredFormat(t *testing.T) {
+	datadir := t.TempDir()
+	if err := runBuild(&cli.Command{}, []string{"-k", "manifest", "-d", datadir, "testdata/conf/agent.toml"}); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := ioutil.ReadFile(filepath.Join(datadir, "metrics-agent.manifest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(bs), "/etc/metrics-agent/agent.conf\n/usr/bin/metrics-agent\n"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if err := runBuild(&cli.Command{}, []string{"-k", "tgz", "-d", t.TempDir(), "testdata/conf/agent.toml"}); err == nil {
+		t.Errorf("tgz: expected unsupported format")
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	var (
+		tmp   = t.TempDir()
+		relay = buildFixture(t, "testdata/convert/relay.toml", "deb", tmp)
+		rpm   = filepath.Join(tmp, "relay.rpm")
+		deb   = filepath.Join(t.TempDir(), "relay.deb")
+	)
+	if err := runConvert(&cli.Command{}, []string{relay, rpm}); err != nil {
+		t.Fatalf("deb to rpm: %s", err)
+	}
+	if err := runConvert(&cli.Command{}, []string{rpm, deb}); err != nil {
+		t.Fatalf("rpm to deb: %s", err)
+	}
+	ps := make([]packit.Package, 3)
+	for i, f := range []string{relay, rpm, deb} {
+		p, err := packit.Open(f)
+		if err != nil {
+			t.Fatalf("%s: %s", f, err)
+		}
+		ps[i] = p
+	}
+	for _, d := range packit.Compare(ps[0], ps[1]) {
+		if !d.Lossy {
+			t.Errorf("deb to rpm: %s: %q became %q", d.Field, d.A, d.B)
+		}
+	}
+	for _, d := range packit.Compare(ps[0], ps[2]) {
+		if d.Field != "priority" {
+			t.Errorf("round-trip: %s: %q became %q", d.Field, d.A, d.B)
+		}
+	}
+	hello, err := packit.Open(buildFixture(t, "testdata/convert/hello.toml", "deb", tmp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := make(map[string]bool)
+	for _, d := range packit.Compare(ps[0], hello) {
+		if d.Lossy {
+			t.Errorf("same format: %s reported as lossy", d.Field)
+		}
+		fields[d.Field] = true
+	}
+	for _, f := range []string{"package", "version", "depends", "file"} {
+		if !fields[f] {
+			t.Errorf("relay/hello: %s difference not reported", f)
+		}
+	}
+}
+
+func TestConvertSpecialFiles(t *testing.T) {
+	var (
+		tmp     = t.TempDir()
+		gateway = buildFixture(t, "testdata/convert/gateway.toml", "deb", tmp)
+		rpm     = filepath.Join(tmp, "gateway.rpm")
+		deb     = filepath.Join(tmp, "gateway.deb")
+		workdir = t.TempDir()
+	)
+	t.Setenv("TMPDIR", workdir)
+	if err := runConvert(&cli.Command{}, []string{gateway, rpm}); err != nil {
+		t.Fatalf("deb to rpm: %s", err)
+	}
+	if err := runConvert(&cli.Command{}, []string{rpm, deb}); err != nil {
+		t.Fatalf("rpm to deb: %s", err)
+	}
+	if es, _ := ioutil.ReadDir(workdir); len(es) > 0 {
+		t.Errorf("workdir of convert not removed: %s", es[0].Name())
+	}
+	want := map[string]packit.Resource{
+		"/usr/lib/gateway/gateway":    {Perm: 0100750},
+		"/usr/bin/gateway":            {Perm: 0120777, Link: "../lib/gateway/gateway"},
+		"/usr/share/gateway/defaults": {Perm: 0100644},
+		"/var/cache/gateway":          {Perm: 0040700},
+		"/run/gateway/control":        {Perm: 0010620},
+		"/dev/gateway0":               {Perm: 0020660, Major: 188, Minor: 3},
+	}
+	for _, f := range []string{rpm, deb} {
+		p, err := packit.Open(f)
+		if err != nil {
+			t.Fatalf("%s: %s", f, err)
+		}
+		rs, err := p.Resources()
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := make(map[string]struct{})
+		for _, r := range rs {
+			n := filepath.Clean("/" + r.Name)
+			w, ok := want[n]
+			if !ok {
+				continue
+			}
+			found[n] = struct{}{}
+			if r.Perm != w.Perm || r.Link != w.Link || r.Major != w.Major || r.Minor != w.Minor {
+				t.Errorf("%s: %s: want mode %o link %q device %d,%d, got mode %o link %q device %d,%d", p.PackageType(), n, w.Perm, w.Link, w.Major, w.Minor, r.Perm, r.Link, r.Major, r.Minor)
+			}
+		}
+		for n := range want {
+			if _, ok := found[n]; !ok {
+				t.Errorf("%s: %s missing from converted package", p.PackageType(), n)
+			}
+		}
+		if cs := p.About().ConfFiles; len(cs) != 1 || cs[0] != "/usr/share/gateway/defaults" {
+			t.Errorf("%s: want defaults as only conffile, got %q", p.PackageType(), cs)
+		}
+		ss := p.(packit.Scripted).Scripts()
+		if s := ss[packit.ScriptPreinst]; s == nil || !strings.Contains(string(s.Text), "useradd -r -M gateway") {
+			t.Errorf("%s: pre-install script not converted: %v", p.PackageType(), s)
+		}
+		if s := ss[packit.ScriptPostrm]; s == nil || !strings.Contains(string(s.Text), "rm -rf /var/cache/gateway") {
+			t.Errorf("%s: post-remove script not converted: %v", p.PackageType(), s)
+		}
+	}
+}
+
+func TestBuildCanceled(t *testing.T) {
+	var (
+		tmp   = t.TempDir()
+		image = filepath.Join(tmp, "disk.img")
+	)
+	f, err := os.Create(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Truncate(1 << 30)
+	f.Close()
+	if err != nil {
+		t.Skipf("sparse file: %s", err)
+	}
+	for _, format := range []string{"deb", "rpm"} {
+		mf, err := packit.Load("testdata/cancel/image.toml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mf.Files[0].Src = image
+		b, err := buildPackage(mf, format)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		var (
+			now    = time.Now()
+			output = filepath.Join(tmp, b.PackageName())
+		)
+		err = writePackage(ctx, b, tmp)
+		if err != context.Canceled {
+			t.Errorf("%s: want %v, got %v", format, context.Canceled, err)
+		}
+		if elapsed := time.Since(now); elapsed > 2*time.Second {
+			t.Errorf("%s: build returned %s after cancel", format, elapsed.Round(time.Millisecond))
+		}
+		if _, err := os.Stat(output); !os.IsNotExist(err) {
+			t.Errorf("%s: partial package %s left behind", format, output)
+		}
+		cancel()
+	}
+}
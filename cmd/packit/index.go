@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/midbel/cli"
+	"github.com/midbel/packit/deb"
+	"github.com/midbel/packit/rpm"
+)
+
+func runIndex(cmd *cli.Command, args []string) error {
+	format := cmd.Flag.String("k", "deb", "package format")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	for _, d := range cmd.Flag.Args() {
+		var err error
+		switch *format {
+		case "deb":
+			err = indexDebian(d)
+		case "rpm":
+			err = indexRPM(d)
+		default:
+			err = fmt.Errorf("index not supported for %s", *format)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexRPM(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.rpm"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	return rpm.Index(dir, files)
+}
+
+func indexDebian(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.deb"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	var body bytes.Buffer
+	if err := deb.Index(&body, dir, files); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "Packages"), body.Bytes(), 0644); err != nil {
+		return err
+	}
+	var z bytes.Buffer
+	w, _ := gzip.NewWriterLevel(&z, gzip.BestCompression)
+	if _, err := w.Write(body.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "Packages.gz"), z.Bytes(), 0644)
+}
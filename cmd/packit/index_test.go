@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/midbel/cli"
+)
+
+func stanzas(bs []byte) []map[string]string {
+	var list []map[string]string
+	for _, block := range strings.Split(strings.TrimSpace(string(bs)), "\n\n") {
+		fields := make(map[string]string)
+		for _, line := range strings.Split(block, "\n") {
+			if strings.HasPrefix(line, " ") {
+				continue
+			}
+			if x := strings.Index(line, ":"); x > 0 {
+				fields[line[:x]] = strings.TrimSpace(line[x+1:])
+			}
+		}
+		list = append(list, fields)
+	}
+	return list
+}
+
+func TestIndexDebian(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		files = []string{
+			buildFixture(t, "testdata/index/ticker.toml", "deb", dir),
+			buildFixture(t, "testdata/index/ticker-doc.toml", "deb", dir),
+		}
+	)
+	if err := runIndex(&cli.Command{}, []string{"-k", "deb", dir}); err != nil {
+		t.Fatalf("index: %s", err)
+	}
+	body, err := ioutil.ReadFile(filepath.Join(dir, "Packages"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := os.Open(filepath.Join(dir, "Packages.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	r, err := gzip.NewReader(z)
+	if err != nil {
+		t.Fatalf("Packages.gz: %s", err)
+	}
+	if bs, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(bs, body) {
+		t.Errorf("Packages.gz does not match Packages (%v)", err)
+	}
+
+	list := stanzas(body)
+	if len(list) != len(files) {
+		t.Fatalf("want %d stanzas, got %d", len(files), len(list))
+	}
+	want := map[string]string{
+		"ticker":     "amd64",
+		"ticker-doc": "all",
+	}
+	for _, s := range list {
+		arch, ok := want[s["Package"]]
+		if !ok {
+			t.Errorf("unexpected package %q", s["Package"])
+			continue
+		}
+		delete(want, s["Package"])
+		if s["Version"] != "0.3.2-1" {
+			t.Errorf("%s: want version 0.3.2-1, got %s", s["Package"], s["Version"])
+		}
+		if s["Architecture"] != arch {
+			t.Errorf("%s: want architecture %s, got %s", s["Package"], arch, s["Architecture"])
+		}
+		file := filepath.Join(dir, s["Filename"])
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Errorf("%s: filename %s: %s", s["Package"], s["Filename"], err)
+			continue
+		}
+		if got := fmt.Sprint(len(bs)); s["Size"] != got {
+			t.Errorf("%s: want size %s, got %s", s["Package"], got, s["Size"])
+		}
+		if got := fmt.Sprintf("%x", sha256.Sum256(bs)); s["SHA256"] != got {
+			t.Errorf("%s: want sha256 %s, got %s", s["Package"], got, s["SHA256"])
+		}
+	}
+	for p := range want {
+		t.Errorf("%s: missing from index", p)
+	}
+}
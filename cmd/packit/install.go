@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/midbel/cli"
+	"github.com/midbel/packit"
+)
+
+const packitDB = "var/lib/packit"
+
+func runInstall(cmd *cli.Command, args []string) error {
+	root := cmd.Flag.String("root", "/", "root directory")
+	force := cmd.Flag.Bool("force", false, "overwrite files owned by other packages")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	db := filepath.Join(*root, packitDB)
+	if err := os.MkdirAll(db, 0755); err != nil {
+		return err
+	}
+	return showPackages(cmd.Flag.Args(), func(p packit.Package) error {
+		name := p.About().Package
+		if name == "" {
+			name = p.PackageName()
+		}
+		if strings.ContainsRune(name, filepath.Separator) {
+			return fmt.Errorf("%s: invalid package name", name)
+		}
+		rs, err := p.Resources()
+		if err != nil {
+			return err
+		}
+		var (
+			files []string
+			dirs  = make(map[string]struct{})
+		)
+		for _, r := range rs {
+			f := "/" + strings.TrimPrefix(filepath.Clean("/"+r.Name), "/")
+			if r.Perm&0170000 == 0040000 {
+				dirs[f] = struct{}{}
+				continue
+			}
+			files = append(files, f)
+			for d := filepath.Dir(f); d != "/"; d = filepath.Dir(d) {
+				dirs[d] = struct{}{}
+			}
+		}
+		if !*force {
+			owners, err := readOwners(db)
+			if err != nil {
+				return err
+			}
+			for _, f := range files {
+				if o, ok := owners[f]; ok && o != name {
+					return fmt.Errorf("%s: already owned by %s", f, o)
+				}
+			}
+		}
+		if err := runScript(p, packit.ScriptPreinst, *root); err != nil {
+			return err
+		}
+		if err := p.Extract(*root, packit.ExtractOptions{Preserve: true}); err != nil {
+			return err
+		}
+		// directories are shared between packages: they are listed with a
+		// trailing slash and never reported as conflicts.
+		list := files
+		for d := range dirs {
+			list = append(list, d+"/")
+		}
+		sort.Strings(list)
+		if err := ioutil.WriteFile(filepath.Join(db, name+".list"), []byte(strings.Join(list, "\n")+"\n"), 0644); err != nil {
+			return err
+		}
+		return runScript(p, packit.ScriptPostinst, *root)
+	})
+}
+
+func readOwners(db string) (map[string]string, error) {
+	ls, err := filepath.Glob(filepath.Join(db, "*.list"))
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[string]string)
+	for _, l := range ls {
+		f, err := os.Open(l)
+		if err != nil {
+			return nil, err
+		}
+		n := strings.TrimSuffix(filepath.Base(l), ".list")
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			if t := s.Text(); t != "" && !strings.HasSuffix(t, "/") {
+				owners[t] = n
+			}
+		}
+		f.Close()
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return owners, nil
+}
+
+func runScript(p packit.Package, which, root string) error {
+	sp, ok := p.(packit.Scripted)
+	if !ok {
+		return nil
+	}
+	s, ok := sp.Scripts()[which]
+	if !ok || s.String() == "" {
+		return nil
+	}
+	tmp := filepath.Join(root, "tmp")
+	if err := os.MkdirAll(tmp, 01777); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(tmp, "packit-"+which)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(s.String()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	arg := "install"
+	if p.PackageType() == "rpm" {
+		arg = "1"
+	}
+	// scripts are run chrooted in root so that they act on the files
+	// extracted there and not on the ones of the host.
+	c := exec.Command(s.Program(), "/tmp/"+filepath.Base(f.Name()), arg)
+	c.Stdout, c.Stderr = os.Stdout, os.Stderr
+	c.Dir = "/"
+	if filepath.Clean(root) != "/" {
+		c.SysProcAttr = &syscall.SysProcAttr{Chroot: root}
+	}
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("%s: %s", which, err)
+	}
+	return nil
+}
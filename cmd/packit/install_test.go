@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/midbel/cli"
+	_ "github.com/midbel/packit/deb"
+	_ "github.com/midbel/packit/rpm"
+)
+
+func install(args ...string) error {
+	return runInstall(&cli.Command{}, args)
+}
+
+func TestInstall(t *testing.T) {
+	for _, format := range []string{"deb", "rpm"} {
+		t.Run(format, func(t *testing.T) {
+			var (
+				tmp  = t.TempDir()
+				root = filepath.Join(tmp, "root")
+				foo  = buildFixture(t, "testdata/install/foo.toml", format, tmp)
+				bar  = buildFixture(t, "testdata/install/bar.toml", format, tmp)
+			)
+			if err := install("-root", root, foo); err != nil {
+				t.Fatalf("install foo: %s", err)
+			}
+			i, err := os.Stat(filepath.Join(root, "usr/bin/foo"))
+			if err != nil {
+				t.Fatalf("usr/bin/foo not installed: %s", err)
+			}
+			if i.Mode().Perm() != 0755 {
+				t.Errorf("usr/bin/foo: want mode 0755, got %s", i.Mode())
+			}
+			bs, err := ioutil.ReadFile(filepath.Join(root, packitDB, "foo.list"))
+			if err != nil {
+				t.Fatalf("foo.list: %s", err)
+			}
+			list := strings.Split(strings.TrimSpace(string(bs)), "\n")
+			want := []string{"/etc/", "/etc/foo/", "/etc/foo/foo.conf", "/usr/", "/usr/bin/", "/usr/bin/foo"}
+			if strings.Join(list, " ") != strings.Join(want, " ") {
+				t.Errorf("foo.list: want %q, got %q", want, list)
+			}
+
+			if err := install("-root", root, bar); err == nil || !strings.Contains(err.Error(), "already owned by foo") {
+				t.Errorf("install bar: want conflict with foo, got %v", err)
+			}
+			if err := install("-force", "-root", root, bar); err != nil {
+				t.Errorf("install bar with -force: %s", err)
+			}
+			owners, err := readOwners(filepath.Join(root, packitDB))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if o := owners["/usr/bin/foo"]; o != "foo" && o != "bar" {
+				t.Errorf("/usr/bin/foo: unexpected owner %q", o)
+			}
+			if _, ok := owners["/usr/bin/"]; ok {
+				t.Errorf("directories should not be reported as owned")
+			}
+		})
+	}
+}
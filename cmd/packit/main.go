@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
@@ -16,44 +17,64 @@ import (
 
 var commands = []*cli.Command{
 	{
-		Usage: "build [-d datadir] [-k pkg-type] <config.toml,...>",
+		Usage: "build [--bump-release] [--strict] [--follow-symlinks] [--strip-debug] [--compress-threads n] [--owner name] [--group name] [-d datadir] [-o output] [-k pkg-type,...] <config.toml,...>",
 		Alias: []string{"make"},
 		Short: "build package(s) from configuration file",
 		Run:   runBuild,
 	},
 	{
-		Usage: "convert [-m maintainer] [-d datadir] [-k type] <package>",
+		Usage: "convert [--dry-run] [-w] [-m maintainer] [-d datadir] [-k type] [-o output] <package...>",
 		Short: "convert a package into another package format",
 		Run:   runConvert,
 	},
 	{
-		Usage: "show [-l] <package>",
+		Usage: "show [-l] [--conffiles] [--sig [-k keyring]] <package>",
 		Alias: []string{"info"},
 		Short: "show package metadata",
 		Run:   runShow,
 	},
 	{
-		Usage: "verify <package...>",
+		Usage: "verify [-l] [--detached sig -k keyring] <package...>",
 		Alias: []string{"check"},
 		Short: "check the integrity of the given package(s)",
 		Run:   runVerify,
 	},
 	{
-		Usage: "history [-w who] [-f from] [-t to] <package,...>",
+		Usage: "history [-c count] [-w who] [-f from] [-t to] [--since duration] <package,...>",
 		Alias: []string{"log", "changelog"},
 		Short: "dump changelog of given package",
 		Run:   runLog,
 	},
 	{
-		Usage: "extract [-r remove] [-d datadir] [-p] <package...>",
+		Usage: "extract [-r remove] [-d datadir] [-j jobs] [-p] [--skip-existing] <package...>",
 		Short: "extract files from package payload in given directory",
 		Run:   runExtract,
 	},
+	{
+		Usage: "install [-root root] [-force] <package...>",
+		Short: "install package(s) in the given root directory",
+		Run:   runInstall,
+	},
+	{
+		Usage: "index [-k type] <directory...>",
+		Short: "generate repository index of packages in given directories",
+		Run:   runIndex,
+	},
 	{
 		Usage: "repack [-m] [-d datadir] [-k type] <package>",
 		Short: "create a package from files installed on local system",
 		Run:   runPack,
 	},
+	{
+		Usage: "formats",
+		Short: "list supported package formats",
+		Run:   runFormats,
+	},
+	{
+		Usage: "tags",
+		Short: "list known rpm header tags",
+		Run:   runTags,
+	},
 }
 
 const helpText = `{{.Name}} is an easy to use package manager which can be used
@@ -90,6 +111,8 @@ Changes     :
 	start := cmd.Flag.String("f", "", "")
 	end := cmd.Flag.String("t", "", "")
 	who := cmd.Flag.String("w", "", "")
+	count := cmd.Flag.Int("c", 0, "")
+	since := cmd.Flag.String("since", "", "")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
@@ -103,6 +126,15 @@ Changes     :
 	if td, err = time.Parse("2006-01-02", *end); err != nil && *end != "" {
 		return err
 	}
+	if *since != "" {
+		d, err := parseSince(*since)
+		if err != nil {
+			return err
+		}
+		if w := time.Now().Add(-d); w.After(fd) {
+			fd = w
+		}
+	}
 	fs := template.FuncMap{
 		"datetime": func(t time.Time) string {
 			if t.IsZero() {
@@ -123,6 +155,10 @@ Changes     :
 	}
 	return showPackages(cmd.Flag.Args(), func(p packit.Package) error {
 		cs := p.History().Filter(*who, fd, td)
+		warn(p)
+		if *count > 0 && len(cs) > *count {
+			cs = cs[:*count]
+		}
 		for i, c := range cs {
 			v := struct {
 				Package string
@@ -147,10 +183,29 @@ Changes     :
 	return nil
 }
 
+func parseSince(s string) (time.Duration, error) {
+	var unit time.Duration
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	default:
+		return time.ParseDuration(s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid duration %s", s)
+	}
+	return time.Duration(n) * unit, nil
+}
+
 func runExtract(cmd *cli.Command, args []string) error {
 	datadir := cmd.Flag.String("d", os.TempDir(), "datadir")
 	preserve := cmd.Flag.Bool("p", false, "preserve")
 	cleandir := cmd.Flag.Bool("r", false, "clean")
+	skip := cmd.Flag.Bool("skip-existing", false, "skip existing files with matching digest")
+	jobs := cmd.Flag.Int("j", 1, "number of files written concurrently")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
@@ -161,7 +216,12 @@ func runExtract(cmd *cli.Command, args []string) error {
 				return err
 			}
 		}
-		if err := p.Extract(dir, *preserve); err != nil {
+		opts := packit.ExtractOptions{
+			Preserve: *preserve,
+			Skip:     *skip,
+			Jobs:     *jobs,
+		}
+		if err := p.Extract(dir, opts); err != nil {
 			if *cleandir {
 				os.RemoveAll(dir)
 			}
@@ -202,20 +262,9 @@ func showPackages(ns []string, fn func(packit.Package) error) error {
 		return nil
 	}
 	for _, n := range ns {
-		var (
-			pkg packit.Package
-			err error
-		)
-		switch e := filepath.Ext(n); e {
-		case ".deb":
-			pkg, err = deb.Open(n)
-		case ".rpm":
-			pkg, err = rpm.Open(n)
-		default:
-			return fmt.Errorf("unsupported packet type %s", e)
-		}
+		pkg, err := openPackage(n)
 		if err != nil {
-			return fmt.Errorf("fail to read %s: %s", n, err)
+			return err
 		}
 		if err := fn(pkg); err != nil {
 			return fmt.Errorf("%s: %s", pkg.PackageName(), err)
@@ -223,3 +272,11 @@ func showPackages(ns []string, fn func(packit.Package) error) error {
 	}
 	return nil
 }
+
+func openPackage(n string) (packit.Package, error) {
+	pkg, err := packit.Open(n)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read %s: %s", n, err)
+	}
+	return pkg, nil
+}
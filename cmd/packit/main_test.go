@@ -0,0 +1,208 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/midbel/cli"
+	"github.com/midbel/packit"
+)
+
+func extract(args ...string) error {
+	return runExtract(&cli.Command{}, args)
+}
+
+func TestExtractSkipExisting(t *testing.T) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, format := range []string{"deb", "rpm"} {
+		var (
+			tmp   = t.TempDir()
+			notes = buildFixture(t, "testdata/extract/notes.toml", format, tmp)
+			dir   = filepath.Join(tmp, "out")
+		)
+		if err := extract("-d", dir, notes); err != nil {
+			t.Fatalf("%s: extract: %s", format, err)
+		}
+		ms, err := filepath.Glob(filepath.Join(dir, "*"))
+		if err != nil || len(ms) != 1 {
+			t.Fatalf("%s: package not extracted (%v)", format, ms)
+		}
+		var (
+			readme = filepath.Join(ms[0], "usr", "share", "doc", "notes", "README")
+			conf   = filepath.Join(ms[0], "etc", "notes.conf")
+		)
+		for _, f := range []string{readme, conf} {
+			if err := os.Chtimes(f, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ioutil.WriteFile(conf, []byte("file = /tmp/notes\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(conf, old, old); err != nil {
+			t.Fatal(err)
+		}
+		if err := extract("--skip-existing", "-d", dir, notes); err != nil {
+			t.Fatalf("%s: extract --skip-existing: %s", format, err)
+		}
+		if i, err := os.Stat(readme); err != nil || !i.ModTime().Equal(old) {
+			t.Errorf("%s: unchanged README rewritten", format)
+		}
+		if i, err := os.Stat(conf); err != nil || i.ModTime().Equal(old) {
+			t.Errorf("%s: changed notes.conf not rewritten", format)
+		}
+		want, _ := ioutil.ReadFile("testdata/extract/notes.conf")
+		if got, _ := ioutil.ReadFile(conf); string(got) != string(want) {
+			t.Errorf("%s: notes.conf not restored: %q", format, got)
+		}
+	}
+}
+
+func TestExtractParallel(t *testing.T) {
+	r, err := os.Open("testdata/extract/locales.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	want := make(map[string]string)
+	for tr := tar.NewReader(r); ; {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[h.Name] = string(bs)
+	}
+	if len(want) != 100 {
+		t.Fatalf("fixture: want 100 files, got %d", len(want))
+	}
+	for _, format := range []string{"deb", "rpm"} {
+		var (
+			tmp  = t.TempDir()
+			l10n = buildFixture(t, "testdata/extract/locales.toml", format, tmp)
+			dir  = filepath.Join(tmp, "out")
+		)
+		if err := extract("-j", "4", "-d", dir, l10n); err != nil {
+			t.Fatalf("%s: extract -j 4: %s", format, err)
+		}
+		ms, err := filepath.Glob(filepath.Join(dir, "*"))
+		if err != nil || len(ms) != 1 {
+			t.Fatalf("%s: package not extracted (%v)", format, ms)
+		}
+		for n, body := range want {
+			bs, err := ioutil.ReadFile(filepath.Join(ms[0], n))
+			if err != nil {
+				t.Errorf("%s: %s", format, err)
+				continue
+			}
+			if string(bs) != body {
+				t.Errorf("%s: %s: unexpected content %q", format, n, bs)
+			}
+		}
+		bad := filepath.Join(ms[0], "usr/share/locale/fr/LC_MESSAGES/notes.mo")
+		if err := os.Remove(bad); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(bad, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := extract("-j", "4", "-d", dir, l10n); err == nil {
+			t.Errorf("%s: extract -j 4: expected error writing %s", format, bad)
+		}
+	}
+}
+
+func history(t *testing.T, args ...string) []string {
+	t.Helper()
+	out, err := stdout(t, func() error {
+		return runLog(&cli.Command{}, args)
+	})
+	if err != nil {
+		t.Fatalf("history %s: %s", strings.Join(args, " "), err)
+	}
+	var vs []string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "Version     : ") {
+			vs = append(vs, strings.TrimPrefix(line, "Version     : "))
+		}
+	}
+	return vs
+}
+
+func TestHistoryCount(t *testing.T) {
+	for _, format := range []string{"deb", "rpm"} {
+		probe := buildFixture(t, "testdata/history/probe.toml", format, t.TempDir())
+		for _, d := range []struct {
+			Args []string
+			Want []string
+		}{
+			{Args: []string{"-c", "2"}, Want: []string{"1.3.0-1", "1.2.0-1"}},
+			{Args: []string{"-c", "2", "-w", "Jane"}, Want: []string{"1.3.0-1", "1.1.0-1"}},
+			{Args: []string{"-c", "2", "-t", "2023-01-01"}, Want: []string{"1.1.0-1", "1.0.0-1"}},
+			{Args: []string{"-c", "2", "-f", "2023-01-01"}, Want: []string{"1.3.0-1", "1.2.0-1"}},
+			{Args: []string{"-c", "9"}, Want: []string{"1.3.0-1", "1.2.0-1", "1.1.0-1", "1.0.0-1"}},
+		} {
+			got := history(t, append(d.Args, probe)...)
+			if strings.Join(got, " ") != strings.Join(d.Want, " ") {
+				t.Errorf("%s: history %s: want %q, got %q", format, strings.Join(d.Args, " "), d.Want, got)
+			}
+		}
+	}
+}
+
+func TestHistorySince(t *testing.T) {
+	mf, err := packit.Load("testdata/history/probe.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, d := range []time.Duration{-2, -6, -10, -40} {
+		mf.Changes[i].When = now.Add(d * 24 * time.Hour)
+	}
+	b, err := buildPackage(mf, "deb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := filepath.Join(t.TempDir(), b.PackageName())
+	w, err := os.Create(probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Build(w)
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []struct {
+		Args []string
+		Want []string
+	}{
+		{Args: []string{"--since", "7d"}, Want: []string{"1.3.0-1", "1.2.0-1"}},
+		{Args: []string{"--since", "2w"}, Want: []string{"1.3.0-1", "1.2.0-1", "1.1.0-1"}},
+		{Args: []string{"--since", "96h"}, Want: []string{"1.3.0-1"}},
+		{Args: []string{"--since", "7d", "-w", "John"}, Want: []string{"1.2.0-1"}},
+		{Args: []string{"--since", "30d", "-c", "1"}, Want: []string{"1.3.0-1"}},
+	} {
+		got := history(t, append(d.Args, probe)...)
+		if strings.Join(got, " ") != strings.Join(d.Want, " ") {
+			t.Errorf("history %s: want %q, got %q", strings.Join(d.Args, " "), d.Want, got)
+		}
+	}
+	for _, s := range []string{"7", "-1d", "xw"} {
+		if err := runLog(&cli.Command{}, []string{"--since", s, probe}); err == nil {
+			t.Errorf("since %q: expected error", s)
+		}
+	}
+}
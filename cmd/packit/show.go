@@ -3,32 +3,89 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"text/tabwriter"
 	"text/template"
 	"time"
 
 	"github.com/midbel/cli"
 	"github.com/midbel/packit"
+	"github.com/midbel/packit/rpm"
+	"golang.org/x/crypto/openpgp"
 )
 
 func runShow(cmd *cli.Command, args []string) error {
 	long := cmd.Flag.Bool("l", false, "show full package description")
+	conf := cmd.Flag.Bool("conffiles", false, "show configuration files")
+	sig := cmd.Flag.Bool("sig", false, "show signature status")
+	keyring := cmd.Flag.String("k", "", "keyring used to verify signatures")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	if args := cmd.Flag.Args(); *long {
+	switch args := cmd.Flag.Args(); {
+	case *long:
 		return showDescription(args)
-	} else {
+	case *conf:
+		return showConfFiles(args)
+	case *sig:
+		return showSignatures(args, *keyring)
+	default:
 		return showAvailable(args)
 	}
 }
 
+func showConfFiles(ns []string) error {
+	w := tabwriter.NewWriter(os.Stdout, 12, 2, 2, ' ', 0)
+	defer w.Flush()
+	return showPackages(ns, func(p packit.Package) error {
+		c := p.About()
+		for _, f := range c.ConfFiles {
+			fmt.Fprintf(w, "%s\t%s\n", p.PackageName(), f)
+		}
+		return nil
+	})
+}
+
+func showSignatures(ns []string, keyring string) error {
+	kr, err := loadKeyRing(keyring)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 2, 2, ' ', 0)
+	defer w.Flush()
+	return showPackages(ns, func(p packit.Package) error {
+		x, ok := p.(packit.Signed)
+		if !ok {
+			return fmt.Errorf("%s: signature not supported", p.PackageType())
+		}
+		s, err := x.Signature(kr)
+		if err != nil {
+			return err
+		}
+		switch {
+		case s == nil:
+			fmt.Fprintf(w, "%s\t%s\tunsigned\n", p.PackageType(), p.PackageName())
+		case s.Verified:
+			fmt.Fprintf(w, "%s\t%s\t%s\tverified\t%s\n", p.PackageType(), p.PackageName(), s.Kind, s.Signer)
+		default:
+			fmt.Fprintf(w, "%s\t%s\t%s\tnot verified\t%s\n", p.PackageType(), p.PackageName(), s.Kind, s.Reason)
+		}
+		return nil
+	})
+}
+
 func showAvailable(ns []string) error {
 	w := tabwriter.NewWriter(os.Stdout, 12, 2, 2, ' ', 0)
 	defer w.Flush()
 	return showPackages(ns, func(p packit.Package) error {
+		n, size, err := packit.Payload(p)
+		if err != nil {
+			return err
+		}
 		c := p.About()
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.PackageType(), p.PackageName(), c.Version, c.Summary)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.PackageType(), p.PackageName(), c.EVR(p.PackageType()), c.Summary)
+		fmt.Fprintf(w, "\tFiles: %d, Size: %d\n", n, size)
 		return nil
 	})
 }
@@ -38,7 +95,7 @@ func showDescription(ns []string) error {
 {{with .Control}}
 - type        : {{$.Type}}
 - name        : {{.Package}}
-- version     : {{.Version}}
+- version     : {{.EVR $.Type}}
 - size        : {{.Size}}
 - maintainer  : {{.Maintainer}}
 - architecture: {{.Arch | arch}}
@@ -48,6 +105,8 @@ func showDescription(ns []string) error {
 - home        : {{if.Home}}{{.Home}}{{else}}-{{end}}
 - license     : {{if .License}}{{.License}}{{else}}-{{end}}
 - summary     : {{.Summary}}
+- files       : {{$.Files}}
+- payload     : {{$.Payload}}
 
 {{.Desc}}{{end}}
 {{if gt .Total 1 }}{{if lt .Index .Total}}---{{end}}
@@ -64,15 +123,23 @@ func showDescription(ns []string) error {
 	var i int
 	return showPackages(ns, func(p packit.Package) error {
 		i++
+		files, size, err := packit.Payload(p)
+		if err != nil {
+			return err
+		}
 		c := struct {
 			Type    string
 			Index   int
 			Total   int
+			Files   int
+			Payload int64
 			Control packit.Control
 		}{
 			Type:    p.PackageType(),
 			Index:   i,
 			Total:   n,
+			Files:   files,
+			Payload: size,
 			Control: p.About(),
 		}
 		return t.Execute(os.Stdout, c)
@@ -80,20 +147,121 @@ func showDescription(ns []string) error {
 }
 
 func runVerify(cmd *cli.Command, args []string) error {
+	sig := cmd.Flag.String("detached", "", "detached signature")
+	keyring := cmd.Flag.String("k", "", "keyring used to verify signatures")
+	list := cmd.Flag.Bool("l", false, "list checksum of each file")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
+	if *sig != "" {
+		return verifyDetached(cmd.Flag.Arg(0), *sig, *keyring)
+	}
 	w := tabwriter.NewWriter(os.Stdout, 12, 2, 2, ' ', 0)
-	defer w.Flush()
-	return showPackages(cmd.Flag.Args(), func(p packit.Package) error {
-		var status string
+	var failed int
+	for _, f := range cmd.Flag.Args() {
+		p, err := packit.Open(f)
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "%s\t%s\tFAILED (%s)\n", strings.TrimPrefix(filepath.Ext(f), "."), f, err)
+			continue
+		}
+		status := "OK"
+		err = p.Valid()
+		if err != nil {
+			failed++
+			status = fmt.Sprintf("FAILED (%s)", err)
+		}
 		c := p.About()
-		if err := p.Valid(); err != nil {
-			status = err.Error()
-		} else {
-			status = "OK"
+		fmt.Fprintf(w, "%s\t%s (%s)\t%s\n", p.PackageType(), p.PackageName(), c.EVR(p.PackageType()), status)
+		if !*list {
+			continue
 		}
-		fmt.Fprintf(w, "%s\t%s (%s)\t%s\n", p.PackageType(), p.PackageName(), c.Version, status)
-		return nil
-	})
+		cs, verr := p.Verify()
+		if verr != nil {
+			if err == nil {
+				failed++
+			}
+			fmt.Fprintf(w, "\t%s\tFAILED (%s)\n", p.PackageName(), verr)
+			continue
+		}
+		for _, c := range cs {
+			status := "OK"
+			switch {
+			case c.Expected == "":
+				status = "UNVERIFIED (no checksum recorded)"
+			case !c.Match:
+				status = fmt.Sprintf("FAILED (expected %s, got %s)", c.Expected, c.Actual)
+			}
+			fmt.Fprintf(w, "\t%s\t%s\n", c.Name, status)
+		}
+	}
+	w.Flush()
+	if failed > 0 {
+		return fmt.Errorf("%d package(s) failed verification", failed)
+	}
+	return nil
+}
+
+func verifyDetached(file, sig, keyring string) error {
+	if file == "" {
+		return fmt.Errorf("no package given")
+	}
+	kr, err := loadKeyRing(keyring)
+	if err != nil {
+		return err
+	}
+	s, err := packit.VerifyDetached(kr, file, sig)
+	if err != nil {
+		return err
+	}
+	if !s.Verified {
+		return fmt.Errorf("%s: signature not verified (%s)", file, s.Reason)
+	}
+	fmt.Fprintf(os.Stdout, "%s\t%s\tverified\t%s\n", file, s.Kind, s.Signer)
+	return nil
+}
+
+func loadKeyRing(file string) (openpgp.KeyRing, error) {
+	if file == "" {
+		return nil, nil
+	}
+	es, err := packit.ReadKeyRing(file)
+	if err != nil {
+		return nil, err
+	}
+	return es, nil
+}
+
+func runFormats(cmd *cli.Command, args []string) error {
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 2, 2, ' ', 0)
+	defer w.Flush()
+
+	yesno := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	fmt.Fprintln(w, "format\tread\twrite\tsigning\tcompression")
+	for _, f := range packit.Formats() {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", f.Name, yesno(f.Read), yesno(f.Write), yesno(f.Signing), strings.Join(f.Compression, ", "))
+	}
+	return nil
+}
+
+func runTags(cmd *cli.Command, args []string) error {
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 2, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "tag\tname")
+	for _, t := range rpm.Tags() {
+		fmt.Fprintf(w, "%d\t%s\n", t, rpm.TagName(t))
+	}
+	return nil
 }
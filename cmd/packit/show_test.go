@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/midbel/cli"
+	"github.com/midbel/packit"
+	"golang.org/x/crypto/openpgp"
+)
+
+// unreadable is a package whose payload disappears between the checks of
+// Valid and the listing of verify -l.
+type unreadable struct {
+	packit.Package
+}
+
+func (u *unreadable) PackageName() string { return "unreadable" }
+func (u *unreadable) PackageType() string { return "unreadable" }
+func (u *unreadable) About() packit.Control {
+	return packit.Control{Package: "unreadable", Version: "1.0"}
+}
+func (u *unreadable) Valid() error { return nil }
+
+func (u *unreadable) Verify() ([]packit.FileCheck, error) {
+	return nil, errors.New("payload: unexpected EOF")
+}
+
+func init() {
+	packit.RegisterReader("unreadable", func(string) (packit.Package, error) {
+		return &unreadable{}, nil
+	})
+}
+
+func stdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+	w, err := ioutil.TempFile(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	os.Stdout, w = w, os.Stdout
+	err = fn()
+	os.Stdout, w = w, os.Stdout
+	bs, _ := ioutil.ReadFile(w.Name())
+	return string(bs), err
+}
+
+func TestVerifyList(t *testing.T) {
+	var (
+		tmp  = t.TempDir()
+		foo  = buildFixture(t, "testdata/install/foo.toml", "deb", tmp)
+		file = filepath.Join(tmp, "pkg.unreadable")
+	)
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := stdout(t, func() error {
+		return runVerify(&cli.Command{}, []string{"-l", foo, file})
+	})
+	if err == nil || !strings.Contains(err.Error(), "1 package(s) failed") {
+		t.Errorf("verify -l: want 1 package failed, got %v", err)
+	}
+	if !strings.Contains(out, "FAILED (payload: unexpected EOF)") {
+		t.Errorf("verify -l: error of Verify not reported:\n%s", out)
+	}
+	if !strings.Contains(out, "usr/bin/foo") {
+		t.Errorf("verify -l: files of valid package not listed:\n%s", out)
+	}
+}
+
+func TestShowPayload(t *testing.T) {
+	var size int64
+	for _, f := range []string{"testdata/install/foo.sh", "testdata/install/foo.conf"} {
+		i, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += i.Size()
+	}
+	want := fmt.Sprintf("Files: 2, Size: %d", size)
+	for _, format := range []string{"deb", "rpm"} {
+		foo := buildFixture(t, "testdata/install/foo.toml", format, t.TempDir())
+		out, err := stdout(t, func() error {
+			return runShow(&cli.Command{}, []string{foo})
+		})
+		if err != nil {
+			t.Fatalf("%s: show: %s", format, err)
+		}
+		if !strings.Contains(out, want) {
+			t.Errorf("%s: show: want %q in\n%s", format, want, out)
+		}
+		out, err = stdout(t, func() error {
+			return runShow(&cli.Command{}, []string{"-l", foo})
+		})
+		if err != nil {
+			t.Fatalf("%s: show -l: %s", format, err)
+		}
+		if !strings.Contains(out, "- files       : 2\n") || !strings.Contains(out, fmt.Sprintf("- payload     : %d\n", size)) {
+			t.Errorf("%s: show -l: files and payload not reported in\n%s", format, out)
+		}
+	}
+}
+
+func TestShowConfFiles(t *testing.T) {
+	for _, format := range []string{"deb", "rpm"} {
+		agent := buildFixture(t, "testdata/conf/agent.toml", format, t.TempDir())
+		out, err := stdout(t, func() error {
+			return runShow(&cli.Command{}, []string{"--conffiles", agent})
+		})
+		if err != nil {
+			t.Fatalf("%s: show --conffiles: %s", format, err)
+		}
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if len(lines) != 1 || !strings.HasSuffix(lines[0], "/etc/metrics-agent/agent.conf") {
+			t.Errorf("%s: show --conffiles: want /etc/metrics-agent/agent.conf, got\n%s", format, out)
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	out, err := stdout(t, func() error {
+		return runFormats(&cli.Command{}, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n")[1:] {
+		fs := strings.Fields(line)
+		rows[fs[0]] = fs[1:]
+	}
+	for _, d := range []struct {
+		Name    string
+		Signing string
+	}{
+		{Name: "deb", Signing: "no"},
+		{Name: "rpm", Signing: "yes"},
+	} {
+		r, ok := rows[d.Name]
+		if !ok {
+			t.Errorf("%s not listed:\n%s", d.Name, out)
+			continue
+		}
+		if r[0] != "yes" || r[1] != "yes" || r[2] != d.Signing {
+			t.Errorf("%s: want read, write and signing %s, got %q", d.Name, d.Signing, r)
+		}
+		if !strings.Contains(strings.Join(r[3:], " "), "xz") {
+			t.Errorf("%s: xz compression not listed: %q", d.Name, r)
+		}
+	}
+}
+
+func TestVerifyDetached(t *testing.T) {
+	var (
+		tmp = t.TempDir()
+		rpm = buildFixture(t, "testdata/bump/foo.toml", "rpm", tmp)
+		sig = rpm + ".sig"
+	)
+	e, err := openpgp.NewEntity("Packaging Team", "", "packaging@example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := os.Open(rpm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w, err := os.Create(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = openpgp.DetachSign(w, e, r, nil)
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := openpgp.EntityList{e}
+	s, err := packit.VerifyDetached(kr, rpm, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Verified || s.Signer != "Packaging Team <packaging@example.org>" {
+		t.Errorf("detached signature: want verified by Packaging Team, got %t %q (%s)", s.Verified, s.Signer, s.Reason)
+	}
+	other := buildFixture(t, "testdata/conf/agent.toml", "rpm", tmp)
+	if s, err := packit.VerifyDetached(kr, other, sig); err != nil || s.Verified {
+		t.Errorf("signature of foo verified for metrics-agent (%v)", err)
+	}
+	if err := runVerify(&cli.Command{}, []string{"--detached", sig, rpm}); err == nil {
+		t.Errorf("verify --detached: expected error without keyring")
+	}
+	if err := runVerify(&cli.Command{}, []string{"--detached", filepath.Join(tmp, "missing.sig"), rpm}); err == nil {
+		t.Errorf("verify --detached: expected error for missing signature file")
+	}
+}
+
+func TestVerifyCorrupted(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/verify/ledger.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{"deb", "rpm"} {
+		file := buildFixture(t, "testdata/verify/ledger.toml", format, t.TempDir())
+		if _, err := stdout(t, func() error { return runVerify(&cli.Command{}, []string{file}) }); err != nil {
+			t.Fatalf("%s: verify intact package: %s", format, err)
+		}
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the payload is not compressed: flip one byte of the script itself
+		x := bytes.Index(bs, body)
+		if x < 0 {
+			t.Fatalf("%s: script not found in payload", format)
+		}
+		bs[x+len(body)/2] ^= 0x20
+		if err := ioutil.WriteFile(file, bs, 0644); err != nil {
+			t.Fatal(err)
+		}
+		out, err := stdout(t, func() error { return runVerify(&cli.Command{}, []string{file}) })
+		if err == nil {
+			t.Errorf("%s: verify corrupted package: expected error", format)
+		}
+		if !strings.Contains(out, "FAILED") {
+			t.Errorf("%s: verify corrupted package: failure not reported:\n%s", format, out)
+		}
+	}
+}
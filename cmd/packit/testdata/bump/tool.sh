@@ -0,0 +1,2 @@
+#!/bin/sh
+exec echo tool 2.4.1
@@ -0,0 +1,3 @@
+#!/bin/sh
+# notes appends its arguments to the notes file of the user.
+echo "$(date -u +%FT%TZ) $*" >> "${NOTES:-$HOME/.notes}"
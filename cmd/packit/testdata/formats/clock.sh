@@ -0,0 +1,4 @@
+#!/bin/sh
+while read -r zone; do
+	printf '%-20s %s\n' "$zone" "$(TZ=$zone date +%H:%M)"
+done < /etc/wallclock/zones.conf
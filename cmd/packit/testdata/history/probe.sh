@@ -0,0 +1,5 @@
+#!/bin/sh
+# probe checks that every url given on the command line answers with 200.
+for url in "$@"; do
+	curl -fsS -o /dev/null "$url" || echo "down: $url"
+done
@@ -0,0 +1,5 @@
+#!/bin/sh
+while true; do
+	date +%T
+	sleep 1
+done
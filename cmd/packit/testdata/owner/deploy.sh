@@ -0,0 +1,2 @@
+#!/bin/sh
+rsync -a /var/www/site/ "$1"
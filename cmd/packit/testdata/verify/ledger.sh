@@ -0,0 +1,5 @@
+#!/bin/sh
+# ledger-add AMOUNT DESCRIPTION...
+amount=$1
+shift
+printf '%s\t%s\t%s\n' "$(date +%F)" "$amount" "$*" >> "${LEDGER:-$HOME/ledger.txt}"
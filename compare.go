@@ -0,0 +1,185 @@
+package packit
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Difference struct {
+	Field string
+	A     string
+	B     string
+	Lossy bool
+}
+
+func Compare(a, b Package) []Difference {
+	var (
+		ca = a.About()
+		cb = b.About()
+		ds []Difference
+	)
+	lossy := make(map[string]struct{})
+	if a.PackageType() != b.PackageType() {
+		for _, t := range []string{a.PackageType(), b.PackageType()} {
+			for _, f := range formats[t].Lossy {
+				lossy[f] = struct{}{}
+			}
+		}
+	}
+	add := func(field, x, y string) {
+		if x == y {
+			return
+		}
+		_, ok := lossy[field]
+		ds = append(ds, Difference{Field: field, A: x, B: y, Lossy: ok})
+	}
+	xs, ys := controlFields(ca), controlFields(cb)
+	for i := range xs {
+		if xs[i].Relation && sameRelations(xs[i].Value, ys[i].Value) {
+			continue
+		}
+		add(xs[i].Name, xs[i].Value, ys[i].Value)
+	}
+
+	fa, _ := a.Filenames()
+	fb, _ := b.Filenames()
+	sa, sb := fileSet(fa), fileSet(fb)
+	for _, n := range sortedKeys(sa) {
+		if _, ok := sb[n]; !ok {
+			add("file", n, "")
+		}
+	}
+	for _, n := range sortedKeys(sb) {
+		if _, ok := sa[n]; !ok {
+			add("file", "", n)
+		}
+	}
+	return ds
+}
+
+type FieldMapping struct {
+	Field   string
+	Value   string
+	Target  string
+	Dropped bool
+}
+
+// MapFields reports under which name each field set in c is written by the
+// builder of the given format and which fields are dropped. The weak fields of
+// the format are only written when weak is set.
+func MapFields(c Control, format string, weak bool) []FieldMapping {
+	f := formats[format]
+	lossy := make(map[string]struct{})
+	for _, n := range f.Lossy {
+		lossy[n] = struct{}{}
+	}
+	if !weak {
+		for _, n := range f.Weak {
+			lossy[n] = struct{}{}
+		}
+	}
+	var ms []FieldMapping
+	for _, x := range controlFields(c) {
+		if x.Value == "" || (x.Name == "epoch" && c.Epoch == 0) {
+			continue
+		}
+		m := FieldMapping{
+			Field:  x.Name,
+			Value:  x.Value,
+			Target: f.Fields[x.Name],
+		}
+		if _, ok := lossy[x.Name]; ok || m.Target == "" {
+			m.Target, m.Dropped = "", true
+		}
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+type field struct {
+	Name     string
+	Value    string
+	Relation bool
+}
+
+func controlFields(c Control) []field {
+	return []field{
+		{Name: "package", Value: c.Package},
+		{Name: "epoch", Value: strconv.Itoa(c.Epoch)},
+		{Name: "version", Value: c.Version},
+		{Name: "release", Value: c.Release},
+		{Name: "summary", Value: c.Summary},
+		{Name: "description", Value: strings.TrimSpace(c.Desc)},
+		{Name: "license", Value: c.License},
+		{Name: "section", Value: c.Section},
+		{Name: "priority", Value: c.Priority},
+		{Name: "arch", Value: strconv.Itoa(int(c.Arch))},
+		{Name: "vendor", Value: c.Vendor},
+		{Name: "homepage", Value: c.Home},
+		{Name: "origin", Value: c.Origin},
+		{Name: "bugs", Value: c.Bugs},
+		{Name: "maintainer", Value: c.Maintainer.String()},
+		{Name: "compiler", Value: c.Compiler},
+		{Name: "depends", Value: joinSorted(c.Depends), Relation: true},
+		{Name: "suggests", Value: joinSorted(c.Suggests), Relation: true},
+		{Name: "enhances", Value: joinSorted(c.Enhances), Relation: true},
+		{Name: "supplements", Value: joinSorted(c.Supplements), Relation: true},
+		{Name: "provides", Value: joinSorted(c.Provides), Relation: true},
+		{Name: "breaks", Value: joinSorted(c.Breaks), Relation: true},
+		{Name: "conflicts", Value: joinSorted(c.Conflicts), Relation: true},
+		{Name: "replaces", Value: joinSorted(c.Replaces), Relation: true},
+		{Name: "obsoletes", Value: joinSorted(c.Obsoletes), Relation: true},
+	}
+}
+
+// sameRelations reports whether x and y list the same relations, whatever the
+// notation used: "libc6 (>= 2.31)" in deb and "libc6 >= 2.31" in rpm.
+func sameRelations(x, y string) bool {
+	return relations(x) == relations(y)
+}
+
+func relations(str string) string {
+	if str == "" {
+		return str
+	}
+	vs := strings.Split(str, ", ")
+	for i, v := range vs {
+		fs := strings.Fields(strings.NewReplacer("(", " ", ")", " ").Replace(v))
+		if len(fs) == 3 {
+			switch fs[1] {
+			case "<<":
+				fs[1] = "<"
+			case ">>":
+				fs[1] = ">"
+			}
+		}
+		vs[i] = strings.Join(fs, " ")
+	}
+	sort.Strings(vs)
+	return strings.Join(vs, ", ")
+}
+
+func joinSorted(vs []string) string {
+	xs := make([]string, len(vs))
+	copy(xs, vs)
+	sort.Strings(xs)
+	return strings.Join(xs, ", ")
+}
+
+func fileSet(vs []string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, v := range vs {
+		set[strings.TrimPrefix(strings.TrimPrefix(v, "./"), "/")] = struct{}{}
+	}
+	return set
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	vs := make([]string, 0, len(set))
+	for v := range set {
+		vs = append(vs, v)
+	}
+	sort.Strings(vs)
+	return vs
+}
@@ -0,0 +1,50 @@
+package packit_test
+
+import (
+	"testing"
+
+	"github.com/midbel/packit"
+	_ "github.com/midbel/packit/deb"
+	_ "github.com/midbel/packit/rpm"
+)
+
+func TestMapFields(t *testing.T) {
+	c := packit.Control{
+		Package:     "viewer",
+		Version:     "1.2.0",
+		Priority:    "optional",
+		Depends:     []string{"libc6 (>= 2.17)"},
+		Suggests:    []string{"viewer-doc"},
+		Supplements: []string{"desktop-base"},
+	}
+	data := []struct {
+		Format  string
+		Weak    bool
+		Dropped []string
+	}{
+		{Format: "rpm", Weak: false, Dropped: []string{"priority", "suggests", "supplements"}},
+		{Format: "rpm", Weak: true, Dropped: []string{"priority"}},
+		{Format: "deb", Weak: false, Dropped: []string{"supplements"}},
+		{Format: "deb", Weak: true, Dropped: []string{"supplements"}},
+	}
+	for _, d := range data {
+		var dropped []string
+		for _, m := range packit.MapFields(c, d.Format, d.Weak) {
+			if m.Dropped {
+				dropped = append(dropped, m.Field)
+			} else if m.Target == "" {
+				t.Errorf("%s/%t: %s mapped without target", d.Format, d.Weak, m.Field)
+			}
+		}
+		if len(dropped) != len(d.Dropped) {
+			t.Errorf("%s/%t: want dropped %q, got %q", d.Format, d.Weak, d.Dropped, dropped)
+			continue
+		}
+		for i := range dropped {
+			if dropped[i] != d.Dropped[i] {
+				t.Errorf("%s/%t: want dropped %q, got %q", d.Format, d.Weak, d.Dropped, dropped)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package packit
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/midbel/packit/rw"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	CompressGZ   = "gzip"
+	CompressXZ   = "xz"
+	CompressZstd = "zstd"
+	CompressNone = "none"
+	CompressAuto = "auto"
+)
+
+const autoBudget = 30 * time.Second
+
+var autoMethods = []string{CompressGZ, CompressXZ, CompressZstd}
+
+var precompressed = map[string]struct{}{
+	".gz":    {},
+	".tgz":   {},
+	".xz":    {},
+	".txz":   {},
+	".bz2":   {},
+	".zst":   {},
+	".lz4":   {},
+	".zip":   {},
+	".jar":   {},
+	".7z":    {},
+	".png":   {},
+	".jpg":   {},
+	".jpeg":  {},
+	".gif":   {},
+	".webp":  {},
+	".mp3":   {},
+	".mp4":   {},
+	".ogg":   {},
+	".woff":  {},
+	".woff2": {},
+}
+
+type Warner interface {
+	Warnings() []string
+}
+
+func Incompressible(file string) bool {
+	_, ok := precompressed[strings.ToLower(filepath.Ext(file))]
+	return ok
+}
+
+type Compressor struct {
+	Method  string
+	Check   string
+	Threads int
+	ModTime time.Time
+}
+
+// threads gives the number of workers used by the zstd encoder. xz payloads
+// are always written by a single worker as one stream: neither rpm nor dpkg
+// reads concatenated xz streams.
+func (c Compressor) threads() int {
+	if c.Threads <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return c.Threads
+}
+
+func (c Compressor) Name() string {
+	if c.Method == "" {
+		return CompressGZ
+	}
+	return c.Method
+}
+
+func (c Compressor) Level() string {
+	switch c.Name() {
+	case CompressXZ:
+		return "6"
+	case CompressZstd:
+		return "19"
+	case CompressNone:
+		return ""
+	default:
+		return "9"
+	}
+}
+
+func (c Compressor) Ext() string {
+	switch c.Name() {
+	case CompressXZ:
+		return ExtXZ
+	case CompressZstd:
+		return ExtZstd
+	case CompressNone:
+		return ""
+	default:
+		return ExtGZ
+	}
+}
+
+func (c Compressor) Valid() error {
+	switch c.Name() {
+	case CompressGZ, CompressZstd, CompressNone, CompressAuto:
+		return nil
+	case CompressXZ:
+		_, err := xzCheck(c.Check)
+		return err
+	default:
+		return fmt.Errorf("unsupported compression %s", c.Method)
+	}
+}
+
+func (c Compressor) Writer(w io.Writer) (io.WriteCloser, error) {
+	switch c.Name() {
+	case CompressGZ:
+		z, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+		z.ModTime = c.ModTime
+		return z, nil
+	case CompressXZ:
+		check, err := xzCheck(c.Check)
+		if err != nil {
+			return nil, err
+		}
+		cfg := xz.WriterConfig{CheckSum: check, NoCheckSum: check == xz.None}
+		return cfg.NewWriter(w)
+	case CompressZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression), zstd.WithEncoderConcurrency(c.threads()))
+	case CompressNone:
+		return nopCloser{Writer: w}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", c.Method)
+	}
+}
+
+// Decompress gives a reader of the data compressed with method read from r.
+// Closing it releases the decoder, r is left open.
+func Decompress(method string, r io.Reader) (io.ReadCloser, error) {
+	switch method {
+	case CompressGZ, "gz", "":
+		return gzip.NewReader(r)
+	case CompressXZ:
+		z, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(z), nil
+	case CompressZstd:
+		z, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return z.IOReadCloser(), nil
+	case CompressNone:
+		return ioutil.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", method)
+	}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// Auto compresses r with each of the methods tried by auto and writes the
+// smallest output to w. The first method always runs to completion, the next
+// ones are dropped as soon as they run past the time budget.
+func (c Compressor) Auto(w io.Writer, r io.ReadSeeker) (Compressor, error) {
+	return c.auto(w, r, autoBudget)
+}
+
+func (c Compressor) auto(w io.Writer, r io.ReadSeeker, budget time.Duration) (Compressor, error) {
+	var (
+		best Compressor
+		file *os.File
+		size int64
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), budget)
+	defer cancel()
+	for i, m := range autoMethods {
+		x := c
+		x.Method = m
+		var rs io.Reader = r
+		if i > 0 {
+			if ctx.Err() != nil {
+				break
+			}
+			rs = rw.Context(ctx, r)
+		}
+		f, n, err := x.compressTemp(r, rs)
+		if err != nil && i > 0 && ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			return best, err
+		}
+		if file == nil || n < size {
+			if file != nil {
+				file.Close()
+				os.Remove(file.Name())
+			}
+			best, file, size = x, f, n
+		} else {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return best, err
+	}
+	_, err := io.Copy(w, file)
+	return best, err
+}
+
+// compressTemp rewinds s and compresses what is read from r, reading s, into
+// a temporary file.
+func (c Compressor) compressTemp(s io.Seeker, r io.Reader) (*os.File, int64, error) {
+	if _, err := s.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	f, err := ioutil.TempFile("", "packit-"+c.Name())
+	if err != nil {
+		return nil, 0, err
+	}
+	z, err := c.Writer(f)
+	if err == nil {
+		if _, err = io.Copy(z, r); err == nil {
+			err = z.Close()
+		}
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, err
+	}
+	n, err := f.Seek(0, io.SeekCurrent)
+	return f, n, err
+}
+
+func xzCheck(c string) (byte, error) {
+	switch strings.ToLower(c) {
+	case "", "crc64":
+		return xz.CRC64, nil
+	case "crc32":
+		return xz.CRC32, nil
+	case "sha256":
+		return xz.SHA256, nil
+	case "none":
+		return xz.None, nil
+	default:
+		return 0, fmt.Errorf("unsupported xz check %s", c)
+	}
+}
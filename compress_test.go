@@ -0,0 +1,189 @@
+package packit
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func payload(n int) []byte {
+	var (
+		bs = make([]byte, n)
+		r  = rand.New(rand.NewSource(1))
+	)
+	for i := range bs {
+		bs[i] = "abcdefgh\n"[r.Intn(9)]
+	}
+	return bs
+}
+
+func TestCompressorThreads(t *testing.T) {
+	data := payload(3 << 20)
+	for _, m := range []string{CompressGZ, CompressXZ, CompressZstd, CompressNone} {
+		for _, n := range []int{1, 4} {
+			var (
+				c = Compressor{Method: m, Threads: n}
+				w bytes.Buffer
+			)
+			z, err := c.Writer(&w)
+			if err != nil {
+				t.Fatalf("%s/%d: writer: %s", m, n, err)
+			}
+			if _, err := z.Write(data); err != nil {
+				t.Fatalf("%s/%d: write: %s", m, n, err)
+			}
+			if err := z.Close(); err != nil {
+				t.Fatalf("%s/%d: close: %s", m, n, err)
+			}
+			r, err := Decompress(m, &w)
+			if err != nil {
+				t.Fatalf("%s/%d: reader: %s", m, n, err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s/%d: read: %s", m, n, err)
+			}
+			if err := r.Close(); err != nil {
+				t.Fatalf("%s/%d: close reader: %s", m, n, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("%s/%d: payload mismatch (%d bytes, want %d)", m, n, len(got), len(data))
+			}
+		}
+	}
+}
+
+func BenchmarkCompressZstd(b *testing.B) {
+	data := payload(16 << 20)
+	for _, n := range []int{1, runtime.GOMAXPROCS(0)} {
+		c := Compressor{Method: CompressZstd, Threads: n}
+		b.Run(fmt.Sprintf("threads-%d", n), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				z, err := c.Writer(ioutil.Discard)
+				if err != nil {
+					b.Fatal(err)
+				}
+				z.Write(data)
+				z.Close()
+			}
+		})
+	}
+}
+
+func TestCompressorAuto(t *testing.T) {
+	data := payload(1 << 20)
+	sizes := make(map[string]int)
+	for _, m := range autoMethods {
+		var w bytes.Buffer
+		z, _ := Compressor{Method: m}.Writer(&w)
+		z.Write(data)
+		z.Close()
+		sizes[m] = w.Len()
+	}
+	var w bytes.Buffer
+	c, err := Compressor{Method: CompressAuto}.Auto(&w, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for m, n := range sizes {
+		if n < sizes[c.Method] {
+			t.Errorf("auto picked %s (%d bytes) but %s gives %d bytes", c.Method, sizes[c.Method], m, n)
+		}
+	}
+	if w.Len() != sizes[c.Method] {
+		t.Errorf("auto wrote %d bytes, want %d", w.Len(), sizes[c.Method])
+	}
+}
+
+// stallReader stalls in the middle of its second pass over its data.
+type stallReader struct {
+	*bytes.Reader
+	pass  int
+	stall time.Duration
+}
+
+func (s *stallReader) Seek(off int64, whence int) (int64, error) {
+	s.pass++
+	return s.Reader.Seek(off, whence)
+}
+
+func (s *stallReader) Read(bs []byte) (int, error) {
+	if s.pass == 2 && s.Reader.Len() < int(s.Reader.Size())/2 {
+		time.Sleep(s.stall)
+		s.stall = 0
+	}
+	return s.Reader.Read(bs)
+}
+
+func TestCompressorAutoBudget(t *testing.T) {
+	data := payload(128 << 10)
+	r := stallReader{Reader: bytes.NewReader(data), stall: time.Second}
+
+	var w bytes.Buffer
+	c, err := Compressor{Method: CompressAuto}.auto(&w, &r, 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Method != autoMethods[0] {
+		t.Errorf("auto: want %s once the budget is exhausted, got %s", autoMethods[0], c.Method)
+	}
+	if r.pass != 2 {
+		t.Errorf("auto: want 2 passes over payload, got %d", r.pass)
+	}
+	z, err := Decompress(c.Method, &w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	got, err := ioutil.ReadAll(z)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("auto: payload mismatch (%v)", err)
+	}
+}
+
+func TestCompressorXZCheck(t *testing.T) {
+	data := payload(64 << 10)
+	for _, d := range []struct {
+		Check string
+		Flag  byte
+	}{
+		{Check: "", Flag: 0x04},
+		{Check: "crc32", Flag: 0x01},
+		{Check: "crc64", Flag: 0x04},
+		{Check: "sha256", Flag: 0x0a},
+		{Check: "none", Flag: 0x00},
+	} {
+		var (
+			c = Compressor{Method: CompressXZ, Check: d.Check}
+			w bytes.Buffer
+		)
+		z, err := c.Writer(&w)
+		if err != nil {
+			t.Fatalf("%s: %s", d.Check, err)
+		}
+		z.Write(data)
+		if err := z.Close(); err != nil {
+			t.Fatalf("%s: %s", d.Check, err)
+		}
+		// the check is given by the second flag byte of the stream header.
+		if bs := w.Bytes(); len(bs) < 8 || bs[7] != d.Flag {
+			t.Errorf("%s: want check flag %#x, got %x", d.Check, d.Flag, bs[:8])
+		}
+		r, err := Decompress(CompressXZ, &w)
+		if err != nil {
+			t.Fatalf("%s: %s", d.Check, err)
+		}
+		if got, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: payload mismatch (%v)", d.Check, err)
+		}
+		r.Close()
+	}
+	if err := (Compressor{Method: CompressXZ, Check: "md5"}).Valid(); err == nil {
+		t.Errorf("md5: expected unsupported check")
+	}
+}
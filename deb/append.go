@@ -0,0 +1,269 @@
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/midbel/packit"
+	"github.com/midbel/packit/deb/control"
+	"github.com/midbel/tape/ar"
+)
+
+type entry struct {
+	*tar.Header
+	Body []byte
+}
+
+func Append(file string, files []*packit.File) error {
+	cs, ds, ms, err := readEntries(file)
+	if err != nil {
+		return err
+	}
+	i, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	done := make(map[string]struct{})
+	for _, e := range ds {
+		n := cleanName(e.Name)
+		if e.Typeflag == tar.TypeDir {
+			n = strings.TrimSuffix(n, "/")
+		}
+		done[n] = struct{}{}
+	}
+	files = append([]*packit.File(nil), files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].String() < files[j].String() })
+	for _, f := range files {
+		n := cleanName(f.String())
+		if _, ok := done[n]; ok {
+			return fmt.Errorf("%s: already in package", n)
+		}
+		done[n] = struct{}{}
+	}
+
+	when := time.Now()
+
+	var data bytes.Buffer
+	wt := tar.NewWriter(&data)
+	for _, e := range ds {
+		if err := wt.WriteHeader(e.Header); err != nil {
+			return err
+		}
+		if _, err := wt.Write(e.Body); err != nil {
+			return err
+		}
+	}
+	var size int64
+	for _, f := range files {
+		if err := writeFile(context.Background(), wt, f, when, done); err != nil {
+			return err
+		}
+		size += f.Size
+	}
+	if err := wt.Close(); err != nil {
+		return err
+	}
+
+	var meta bytes.Buffer
+	if err := appendControl(&meta, cs, files, size, when); err != nil {
+		return err
+	}
+
+	w, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(w.Name())
+
+	aw, err := ar.NewWriter(w)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	if err := writeDebian(aw, when); err != nil {
+		w.Close()
+		return err
+	}
+	// members are compressed as they were in the original package.
+	mbs := []struct {
+		File   string
+		Method string
+		Body   io.Reader
+	}{
+		{File: debControlTar, Method: ms[0], Body: &meta},
+		{File: debDataTar, Method: ms[1], Body: &data},
+	}
+	for _, m := range mbs {
+		c := packit.Compressor{Method: m.Method, ModTime: when}
+		if err := writeMember(aw, m.File, c, m.Body); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	if err := aw.Close(); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Chmod(i.Mode().Perm()); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.Rename(w.Name(), file)
+}
+
+func appendControl(w io.Writer, es []entry, files []*packit.File, size int64, when time.Time) error {
+	var (
+		sums  bytes.Buffer
+		confs bytes.Buffer
+		ctrl  *packit.Control
+	)
+	for _, e := range es {
+		switch cleanName(e.Name) {
+		case debControlFile:
+			c, err := control.Parse(bytes.NewReader(e.Body))
+			if err != nil {
+				return err
+			}
+			ctrl = c
+		case debSumFile:
+			sums.Write(e.Body)
+		case debConfFile:
+			confs.Write(e.Body)
+		}
+	}
+	if ctrl == nil {
+		return packit.ErrMalformedPackage
+	}
+	for _, f := range files {
+		fmt.Fprintf(&sums, "%s  %s\n", f.Sum, cleanName(f.String()))
+		if f.Conf {
+			fmt.Fprintln(&confs, "/"+cleanName(f.String()))
+		}
+	}
+	ctrl.Size += size
+
+	var body bytes.Buffer
+	if err := control.Dump(ctrl, &body); err != nil {
+		return err
+	}
+	ms := map[string][]byte{
+		debControlFile: body.Bytes(),
+		debSumFile:     sums.Bytes(),
+		debConfFile:    confs.Bytes(),
+	}
+
+	wt := tar.NewWriter(w)
+	write := func(h *tar.Header, body []byte) error {
+		h.Size = int64(len(body))
+		if err := wt.WriteHeader(h); err != nil {
+			return err
+		}
+		_, err := wt.Write(body)
+		return err
+	}
+	for _, e := range es {
+		n := cleanName(e.Name)
+		body, ok := ms[n]
+		if !ok {
+			body = e.Body
+		}
+		delete(ms, n)
+		if err := write(e.Header, body); err != nil {
+			return err
+		}
+	}
+	for _, n := range []string{debControlFile, debSumFile, debConfFile} {
+		body, ok := ms[n]
+		if !ok || len(body) == 0 {
+			continue
+		}
+		h := tar.Header{
+			Name:     n,
+			ModTime:  when,
+			Mode:     0644,
+			Typeflag: tar.TypeReg,
+		}
+		if err := write(&h, body); err != nil {
+			return err
+		}
+	}
+	return wt.Close()
+}
+
+func readEntries(file string) ([]entry, []entry, []string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer f.Close()
+
+	r, err := ar.NewReader(f)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := readDebian(r); err != nil {
+		return nil, nil, nil, err
+	}
+	var (
+		es [][]entry
+		ms []string
+	)
+	for _, prefix := range []string{"control", "data"} {
+		h, err := r.Next()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if !strings.HasPrefix(filepath.Base(h.Filename), prefix) {
+			return nil, nil, nil, packit.ErrMalformedPackage
+		}
+		rs, err := uncompress(r, h.Filename)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		xs, err := readTar(rs)
+		rs.Close()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		es, ms = append(es, xs), append(ms, memberMethod(h.Filename))
+	}
+	return es[0], es[1], ms, nil
+}
+
+func readTar(r io.Reader) ([]entry, error) {
+	var (
+		es []entry
+		t  = tar.NewReader(r)
+	)
+	for {
+		h, err := t.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		bs, err := ioutil.ReadAll(t)
+		if err != nil {
+			return nil, err
+		}
+		es = append(es, entry{Header: h, Body: bs})
+	}
+	return es, nil
+}
+
+func cleanName(n string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(n, "./"), "/")
+}
@@ -0,0 +1,77 @@
+package deb
+
+import (
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/midbel/packit"
+)
+
+func TestAppend(t *testing.T) {
+	file := buildFixture(t, "testdata/append/base.toml")
+	if err := os.Chmod(file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := []*packit.File{
+		{Src: "testdata/append/serif.txt", Dst: "/usr/share/fonts/extra/"},
+		{Src: "testdata/append/mono.txt", Dst: "/usr/share/fonts/extra/"},
+		{Src: "testdata/append/60-extra.conf", Dst: "/etc/fonts/conf.d/", Conf: true},
+	}
+	order := append([]*packit.File(nil), files...)
+	if err := Append(file, files); err != nil {
+		t.Fatal(err)
+	}
+	for i := range files {
+		if files[i] != order[i] {
+			t.Errorf("files given to append have been reordered")
+			break
+		}
+	}
+
+	i, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Mode().Perm() != 0644 {
+		t.Errorf("mode of package changed to %s", i.Mode())
+	}
+	if ms := members(t, file); len(ms) != 3 || ms[1] != "control.tar.xz" || ms[2] != "data.tar.xz" {
+		t.Errorf("members not compressed as in the original package: %q", ms)
+	}
+
+	p, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Valid(); err != nil {
+		t.Errorf("md5sums not updated: %s", err)
+	}
+	got, err := p.Filenames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	want := []string{
+		"etc/fonts/conf.d/60-extra.conf",
+		"usr/share/doc/fonts-extra/README",
+		"usr/share/fonts/extra/mono.txt",
+		"usr/share/fonts/extra/serif.txt",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("listing: want %q, got %q", want, got)
+	}
+	for i := range want {
+		if cleanName(got[i]) != want[i] {
+			t.Errorf("listing[%d]: want %s, got %s", i, want[i], got[i])
+		}
+	}
+	if cs := p.(*pkg).confFiles(); len(cs) != 1 || cs[0] != "/etc/fonts/conf.d/60-extra.conf" {
+		t.Errorf("conffiles: got %q", cs)
+	}
+
+	err = Append(file, []*packit.File{{Src: "testdata/append/mono.txt", Dst: "/usr/share/fonts/extra/"}})
+	if err == nil {
+		t.Errorf("appending a file already in the package should fail")
+	}
+}
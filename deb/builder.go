@@ -4,11 +4,11 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
 	"io"
-	"os"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -17,6 +17,7 @@ import (
 	"github.com/midbel/packit"
 	"github.com/midbel/packit/deb/changelog"
 	"github.com/midbel/packit/deb/control"
+	"github.com/midbel/packit/rw"
 	"github.com/midbel/tape"
 	"github.com/midbel/tape/ar"
 )
@@ -27,25 +28,32 @@ type builder struct {
 	control *packit.Control
 	files   []*packit.File
 	changes []*packit.Change
+	scripts []*packit.Script
+
+	compress packit.Compressor
 }
 
 func (b *builder) PackageName() string {
 	if b.control == nil {
 		return "packit.deb"
 	}
-	return b.control.PackageName() + "_" + Arch(b.control.Arch) + ".deb"
+	return b.control.FileName("deb", Arch(b.control.Arch))
 }
 
 func (b *builder) Build(w io.Writer) error {
+	return b.BuildContext(context.Background(), w)
+}
+
+func (b *builder) BuildContext(ctx context.Context, w io.Writer) error {
 	aw, err := ar.NewWriter(w)
 	if err != nil {
 		return err
 	}
-	if err := b.writeDebian(aw); err != nil {
+	if err := writeDebian(aw, b.when); err != nil {
 		return err
 	}
 	var data, control bytes.Buffer
-	if err := b.writeData(&data); err != nil {
+	if err := b.writeData(ctx, &data); err != nil {
 		return err
 	}
 	if err := b.writeControl(&control); err != nil {
@@ -59,94 +67,111 @@ func (b *builder) Build(w io.Writer) error {
 		{File: debDataTar, Buffer: data},
 	}
 	for _, t := range ts {
-		var body bytes.Buffer
-		z := gzip.NewWriter(&body)
-		if _, err := io.Copy(z, &t.Buffer); err != nil {
-			return err
-		}
-		if err := z.Close(); err != nil {
-			return err
-		}
-		h := tape.Header{
-			Filename: t.File,
-			Uid:      0,
-			Gid:      0,
-			ModTime:  b.when,
-			Mode:     0644,
-			Length:   int64(body.Len()),
-		}
-		if err := aw.WriteHeader(&h); err != nil {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-		if _, err := io.Copy(aw, &body); err != nil {
+		if err := writeMember(aw, t.File, b.compress, &t.Buffer); err != nil {
 			return err
 		}
 	}
 	return aw.Close()
 }
 
-func (b *builder) writeData(w io.Writer) error {
+func (b *builder) writeData(ctx context.Context, w io.Writer) error {
 	wt := tar.NewWriter(w)
 	done := make(map[string]struct{})
 
 	if err := b.writeChangelog(wt, done); err != nil {
 		return err
 	}
-	digest := md5.New()
 	sort.Slice(b.files, func(i, j int) bool { return b.files[i].String() < b.files[j].String() })
 	for _, i := range b.files {
 		if i.Src == "" && i.Dst == "" {
 			continue
 		}
-		f, err := os.Open(i.Src)
-		if err != nil {
+		if err := writeFile(ctx, wt, i, b.when, done); err != nil {
 			return err
 		}
-		var (
-			r    io.Reader
-			size int64
-		)
-		if i.Compress {
-			var rs bytes.Buffer
-			z, _ := gzip.NewWriterLevel(&rs, gzip.BestCompression)
-			if _, err := io.Copy(z, f); err != nil {
-				return err
-			}
-			if err := z.Close(); err != nil {
-				return err
-			}
-			size, r = int64(rs.Len()), &rs
-		} else {
-			s, err := f.Stat()
-			if err != nil {
-				return err
-			}
-			size, r = s.Size(), f
-		}
-		if err := makeIntermediateDirectories(wt, i.String(), done); err != nil {
-			return err
-		}
-		h := tar.Header{
-			Name:     strings.TrimPrefix(i.String(), "/"),
-			Mode:     i.Mode(),
-			Size:     size,
-			ModTime:  b.when,
-			Gid:      0,
-			Uid:      0,
-			Typeflag: tar.TypeReg,
+	}
+	return wt.Close()
+}
+
+func writeSpecial(w *tar.Writer, i *packit.File, when time.Time, done map[string]struct{}) error {
+	if err := makeIntermediateDirectories(w, i.String(), when, done); err != nil {
+		return err
+	}
+	h := tar.Header{
+		Name:     strings.TrimPrefix(i.String(), "/"),
+		Mode:     i.Mode(),
+		ModTime:  when,
+		Uid:      i.Uid,
+		Gid:      i.Gid,
+		Devmajor: int64(i.Major),
+		Devminor: int64(i.Minor),
+	}
+	h.Uname, h.Gname = i.Owner()
+	switch i.Type {
+	case packit.FileFifo:
+		h.Typeflag = tar.TypeFifo
+	case packit.FileChar:
+		h.Typeflag = tar.TypeChar
+	case packit.FileBlock:
+		h.Typeflag = tar.TypeBlock
+	case packit.FileLink:
+		h.Typeflag, h.Linkname = tar.TypeSymlink, i.Link
+	case packit.FileDir:
+		n := cleanName(filepath.Clean(i.String()))
+		if _, ok := done[n]; ok {
+			return nil
 		}
-		if err := wt.WriteHeader(&h); err != nil {
+		done[n] = struct{}{}
+		h.Typeflag, h.Name = tar.TypeDir, n+"/"
+	}
+	return w.WriteHeader(&h)
+}
+
+func writeFile(ctx context.Context, w *tar.Writer, i *packit.File, when time.Time, done map[string]struct{}) error {
+	if i.Special() {
+		return writeSpecial(w, i, when, done)
+	}
+	f, size, err := i.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader
+	if i.Compress {
+		var rs bytes.Buffer
+		z, _ := gzip.NewWriterLevel(&rs, gzip.BestCompression)
+		z.ModTime = when
+		if _, err := io.Copy(z, rw.Context(ctx, f)); err != nil {
 			return err
 		}
-		if i.Size, err = io.Copy(wt, io.TeeReader(r, digest)); err != nil {
+		if err := z.Close(); err != nil {
 			return err
 		}
-		i.Sum = hex.EncodeToString(digest.Sum(nil))
-
-		f.Close()
-		digest.Reset()
+		size, r = int64(rs.Len()), &rs
+	} else {
+		r = rw.Context(ctx, f)
 	}
-	return wt.Close()
+	if err := makeIntermediateDirectories(w, i.String(), when, done); err != nil {
+		return err
+	}
+	h := tar.Header{
+		Name:     strings.TrimPrefix(i.String(), "/"),
+		Mode:     i.Mode(),
+		Size:     size,
+		ModTime:  i.Time(when),
+		Gid:      i.Gid,
+		Uid:      i.Uid,
+		Typeflag: tar.TypeReg,
+	}
+	h.Uname, h.Gname = i.Owner()
+	if err := w.WriteHeader(&h); err != nil {
+		return err
+	}
+	return i.Copy(w, r, md5.New())
 }
 
 func (b *builder) writeChangelog(w *tar.Writer, done map[string]struct{}) error {
@@ -157,13 +182,16 @@ func (b *builder) writeChangelog(w *tar.Writer, done map[string]struct{}) error
 		if g.Maintainer == nil {
 			g.Maintainer = b.control.Maintainer
 		}
+		if g.Version == "" {
+			g.Version = b.control.EVR("deb")
+		}
 	}
 	var body bytes.Buffer
 	if err := changelog.DumpCompressed(b.control.Package, b.changes, &body); err != nil {
 		return err
 	}
 	name := filepath.Join("usr/share/doc", b.control.Package, debChangeFile)
-	if err := makeIntermediateDirectories(w, name, done); err != nil {
+	if err := makeIntermediateDirectories(w, name, b.when, done); err != nil {
 		return err
 	}
 	h := tar.Header{
@@ -171,6 +199,8 @@ func (b *builder) writeChangelog(w *tar.Writer, done map[string]struct{}) error
 		Mode:     0644,
 		Uid:      0,
 		Gid:      0,
+		Uname:    packit.DefaultUser,
+		Gname:    packit.DefaultGroup,
 		Size:     int64(body.Len()),
 		ModTime:  b.when,
 		Typeflag: tar.TypeReg,
@@ -193,7 +223,10 @@ func (b *builder) writeChangelog(w *tar.Writer, done map[string]struct{}) error
 }
 
 func (b *builder) writeControl(w io.Writer) error {
-	var ds, cs []string
+	var (
+		ds, cs []string
+		size   int64
+	)
 	for _, f := range b.files {
 		if f.Conf {
 			n := f.String()
@@ -202,13 +235,20 @@ func (b *builder) writeControl(w io.Writer) error {
 			}
 			cs = append(cs, n)
 		}
-		ds = append(ds, fmt.Sprintf("%s %s", f.Sum, strings.TrimPrefix(f.String(), "/")))
-		b.control.Size += f.Size
+		if !f.Special() {
+			ds = append(ds, fmt.Sprintf("%s  %s", f.Sum, strings.TrimPrefix(f.String(), "/")))
+		}
+		size += f.Size
 	}
+	b.control.Size = size
+
 	wt := tar.NewWriter(w)
 	if err := b.writeControlFile(wt); err != nil {
 		return err
 	}
+	if err := b.writeScripts(wt); err != nil {
+		return err
+	}
 	fs := []struct {
 		File string
 		Data []string
@@ -264,14 +304,45 @@ func (b *builder) writeControlFile(w *tar.Writer) error {
 	return err
 }
 
-func (b *builder) writeDebian(w tape.Writer) error {
+// writeScripts writes the maintainer scripts of the package. dpkg runs them
+// as programs so the ones without an interpreter line get one.
+func (b *builder) writeScripts(w *tar.Writer) error {
+	files := []string{debPreinst, debPostinst, debPrerem, debPostrem}
+	for i, s := range b.scripts {
+		if s == nil || s.String() == "" || i >= len(files) {
+			continue
+		}
+		body := s.String()
+		if !s.Valid() {
+			body = "#!" + s.Program() + "\n" + body
+		}
+		h := tar.Header{
+			Name:     files[i],
+			ModTime:  b.when,
+			Uid:      0,
+			Gid:      0,
+			Mode:     0755,
+			Size:     int64(len(body)),
+			Typeflag: tar.TypeReg,
+		}
+		if err := w.WriteHeader(&h); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeDebian(w tape.Writer, when time.Time) error {
 	h := tape.Header{
 		Filename: debBinaryFile,
 		Uid:      0,
 		Gid:      0,
 		Mode:     0644,
 		Length:   int64(len(debVersion)),
-		ModTime:  b.when,
+		ModTime:  when,
 	}
 	if err := w.WriteHeader(&h); err != nil {
 		return err
@@ -280,7 +351,34 @@ func (b *builder) writeDebian(w tape.Writer) error {
 	return err
 }
 
-func makeIntermediateDirectories(w *tar.Writer, n string, done map[string]struct{}) error {
+func writeMember(w tape.Writer, file string, c packit.Compressor, r io.Reader) error {
+	var body bytes.Buffer
+	z, err := c.Writer(&body)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(z, r); err != nil {
+		return err
+	}
+	if err := z.Close(); err != nil {
+		return err
+	}
+	h := tape.Header{
+		Filename: file + c.Ext(),
+		Uid:      0,
+		Gid:      0,
+		ModTime:  c.ModTime,
+		Mode:     0644,
+		Length:   int64(body.Len()),
+	}
+	if err := w.WriteHeader(&h); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, &body)
+	return err
+}
+
+func makeIntermediateDirectories(w *tar.Writer, n string, when time.Time, done map[string]struct{}) error {
 	ds := strings.Split(filepath.Dir(n), "/")
 	for i := 0; i < len(ds); i++ {
 		n := ds[i]
@@ -290,16 +388,19 @@ func makeIntermediateDirectories(w *tar.Writer, n string, done map[string]struct
 		if i > 0 {
 			n = filepath.Join(strings.Join(ds[:i], "/"), n)
 		}
+		n = cleanName(n)
 		if _, ok := done[n]; ok {
 			continue
 		}
 		done[n] = struct{}{}
 		h := tar.Header{
-			Name:     strings.TrimPrefix(n+"/", "/"),
-			ModTime:  time.Now(),
+			Name:     n + "/",
+			ModTime:  when,
 			Mode:     0755,
 			Gid:      0,
 			Uid:      0,
+			Gname:    packit.DefaultGroup,
+			Uname:    packit.DefaultUser,
 			Typeflag: tar.TypeDir,
 		}
 		if err := w.WriteHeader(&h); err != nil {
@@ -0,0 +1,455 @@
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/midbel/packit"
+	"github.com/midbel/tape/ar"
+)
+
+func buildTwice(t *testing.T, file string, fn func(*packit.Makefile), when *time.Time) (string, string) {
+	t.Helper()
+	mf, err := packit.Load(file)
+	if err != nil {
+		t.Fatalf("%s: %s", file, err)
+	}
+	if fn != nil {
+		fn(mf)
+	}
+	b, err := Build(mf)
+	if err != nil {
+		t.Fatalf("%s: %s", file, err)
+	}
+	if when != nil {
+		*when = b.(*builder).when
+	}
+	var ns [2]string
+	for i := range ns {
+		ns[i] = filepath.Join(t.TempDir(), b.PackageName())
+		w, err := os.Create(ns[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = b.Build(w)
+		w.Close()
+		if err != nil {
+			t.Fatalf("%s: build: %s", file, err)
+		}
+	}
+	return ns[0], ns[1]
+}
+
+func buildFixture(t *testing.T, file string) string {
+	t.Helper()
+	mf, err := packit.Load(file)
+	if err != nil {
+		t.Fatalf("%s: %s", file, err)
+	}
+	b, err := Build(mf)
+	if err != nil {
+		t.Fatalf("%s: %s", file, err)
+	}
+	name := filepath.Join(t.TempDir(), b.PackageName())
+	w, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := b.Build(w); err != nil {
+		t.Fatalf("%s: build: %s", file, err)
+	}
+	return name
+}
+
+func members(t *testing.T, file string) []string {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := ar.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ms []string
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ms = append(ms, h.Filename)
+	}
+	return ms
+}
+
+func TestInstalledSize(t *testing.T) {
+	var size int64
+	for _, f := range []string{"sift.sh", "sift.conf", "manual.txt"} {
+		i, err := os.Stat(filepath.Join("testdata/builder", f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += i.Size()
+	}
+	first, second := buildTwice(t, "testdata/builder/sift.toml", nil, nil)
+	for _, f := range []string{first, second} {
+		p, err := Open(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (size + 1023) >> 10; p.About().Size != want<<10 {
+			t.Errorf("installed-size: want %d KiB, got %d KiB", want, p.About().Size>>10)
+		}
+	}
+}
+
+func TestGzipHeaders(t *testing.T) {
+	var when time.Time
+	first, second := buildTwice(t, "testdata/builder/sift.toml", func(mf *packit.Makefile) {
+		mf.Compression = packit.CompressGZ
+	}, &when)
+	a, err := ioutil.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Fatalf("two builds of the same package differ")
+	}
+	var n int
+	for i := bytes.Index(a, []byte{0x1f, 0x8b, 0x08}); i >= 0; i = bytes.Index(a, []byte{0x1f, 0x8b, 0x08}) {
+		if mtime := int64(binary.LittleEndian.Uint32(a[i+4:])); mtime != when.Unix() {
+			t.Errorf("gzip stream %d: want mtime %d, got %d", n, when.Unix(), mtime)
+		}
+		if v := a[i+9]; v != 0xff {
+			t.Errorf("gzip stream %d: want unknown os, got %d", n, v)
+		}
+		a, n = a[i+10:], n+1
+	}
+	if n < 2 {
+		t.Errorf("want gzip control and data members, found %d gzip streams", n)
+	}
+}
+
+func TestBuildFromTar(t *testing.T) {
+	p, err := Open(buildFixture(t, "testdata/stage/stage.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Valid(); err != nil {
+		t.Errorf("valid: %s", err)
+	}
+	rs, err := p.Resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		when = time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC)
+		want = map[string]packit.Resource{
+			"usr/bin/relayd":              {Perm: 0755, User: "root", Group: "root", Size: 53},
+			"etc/relayd/relayd.conf":      {Perm: 0640, User: "root", Group: "relayd", Gid: 120, Size: 39},
+			"usr/share/doc/relayd/README": {Perm: 0644, User: "root", Group: "root", Size: 48},
+		}
+	)
+	for _, r := range rs {
+		n := cleanName(r.Name)
+		w, ok := want[n]
+		if !ok {
+			continue
+		}
+		delete(want, n)
+		if r.Perm&07777 != w.Perm || r.Size != w.Size || !r.ModTime.Equal(when) {
+			t.Errorf("%s: want mode %o size %d mtime %s, got %o %d %s", n, w.Perm, w.Size, when, r.Perm&07777, r.Size, r.ModTime)
+		}
+		if r.User != w.User || r.Group != w.Group || r.Gid != w.Gid {
+			t.Errorf("%s: want owner %s:%s (gid %d), got %s:%s (gid %d)", n, w.User, w.Group, w.Gid, r.User, r.Group, r.Gid)
+		}
+	}
+	for n := range want {
+		t.Errorf("%s: missing from package", n)
+	}
+}
+
+func TestCompressMan(t *testing.T) {
+	p, err := Open(buildFixture(t, "testdata/man/sift.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Valid(); err != nil {
+		t.Errorf("valid: %s", err)
+	}
+	z, err := p.(*pkg).payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	want := map[string]string{
+		"usr/share/man/man1/sift.1.gz":      "testdata/man/sift.1",
+		"usr/share/man/man5/sift.conf.5.gz": "testdata/man/sift.conf.5.gz",
+		"usr/bin/sift":                      "testdata/man/sift.sh",
+	}
+	r := tar.NewReader(z)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := cleanName(h.Name)
+		if n == "usr/share/man/man1/sift.1" {
+			t.Errorf("%s: man page not compressed", n)
+		}
+		src, ok := want[n]
+		if !ok {
+			continue
+		}
+		delete(want, n)
+		bs, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(src, ".1") {
+			gz, err := gzip.NewReader(bytes.NewReader(bs))
+			if err != nil {
+				t.Fatalf("%s: not gzipped: %s", n, err)
+			}
+			if bs, err = ioutil.ReadAll(gz); err != nil {
+				t.Fatal(err)
+			}
+		}
+		orig, err := ioutil.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(bs, orig) {
+			t.Errorf("%s: content does not match %s", n, src)
+		}
+	}
+	for n := range want {
+		t.Errorf("%s: missing from data.tar", n)
+	}
+}
+
+func TestPackageName(t *testing.T) {
+	for _, d := range []struct {
+		Arch    uint8
+		Release string
+		Want    string
+	}{
+		{Arch: packit.Arch64, Release: "2", Want: "sift_0.4.0-2_amd64.deb"},
+		{Arch: packit.Arch32, Release: "2", Want: "sift_0.4.0-2_i386.deb"},
+		{Arch: packit.ArchAll, Release: "2", Want: "sift_0.4.0-2_all.deb"},
+		{Arch: packit.Arch64, Want: "sift_0.4.0_amd64.deb"},
+	} {
+		mf, err := packit.Load("testdata/builder/sift.toml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mf.Arch, mf.Release = d.Arch, d.Release
+		b, err := Build(mf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := b.PackageName(); got != d.Want {
+			t.Errorf("want %s, got %s", d.Want, got)
+		}
+	}
+}
+
+func TestDefaultOwner(t *testing.T) {
+	p, err := Open(buildFixture(t, "testdata/owner/exporter.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := p.Resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]packit.Resource{
+		"usr/bin/host-exporter":           {User: "root", Group: "root"},
+		"etc/host-exporter/exporter.conf": {User: "exporter", Group: "exporter", Uid: 990, Gid: 990},
+	}
+	for _, r := range rs {
+		n := cleanName(r.Name)
+		w, ok := want[n]
+		if !ok {
+			w = packit.Resource{User: "root", Group: "root"}
+		}
+		delete(want, n)
+		if r.User != w.User || r.Group != w.Group || r.Uid != w.Uid || r.Gid != w.Gid {
+			t.Errorf("%s: want owner %s:%s (%d:%d), got %s:%s (%d:%d)", n, w.User, w.Group, w.Uid, w.Gid, r.User, r.Group, r.Uid, r.Gid)
+		}
+	}
+	for n := range want {
+		t.Errorf("%s: missing from package", n)
+	}
+}
+
+func TestMembers(t *testing.T) {
+	for _, d := range []struct {
+		Method string
+		Want   []string
+	}{
+		{Method: "", Want: []string{"debian-binary", "control.tar.xz", "data.tar.xz"}},
+		{Method: packit.CompressGZ, Want: []string{"debian-binary", "control.tar.gz", "data.tar.gz"}},
+		{Method: packit.CompressXZ, Want: []string{"debian-binary", "control.tar.xz", "data.tar.xz"}},
+		{Method: packit.CompressNone, Want: []string{"debian-binary", "control.tar", "data.tar"}},
+	} {
+		file, _ := buildTwice(t, "testdata/builder/sift.toml", func(mf *packit.Makefile) {
+			mf.Compression = d.Method
+		}, nil)
+		if got := members(t, file); strings.Join(got, " ") != strings.Join(d.Want, " ") {
+			t.Errorf("%s: want members %q, got %q", d.Method, d.Want, got)
+		}
+	}
+}
+
+// controlFile gives the content of the member name of the control archive of
+// the package found in file.
+func controlFile(t *testing.T, file, name string) []byte {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := ar.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		h, err := r.Next()
+		if err != nil {
+			t.Fatalf("control archive not found: %s", err)
+		}
+		if !strings.HasPrefix(h.Filename, "control.tar") {
+			continue
+		}
+		z, err := uncompress(r, h.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer z.Close()
+		tr := tar.NewReader(z)
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				t.Fatalf("%s not found in control archive: %s", name, err)
+			}
+			if cleanName(h.Name) == name {
+				bs, err := ioutil.ReadAll(tr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return bs
+			}
+		}
+	}
+}
+
+func TestMD5Sums(t *testing.T) {
+	var (
+		file = buildFixture(t, "testdata/builder/sift.toml")
+		want []string
+	)
+	for _, f := range [][2]string{
+		{"testdata/builder/sift.sh", "usr/bin/sift"},
+		{"testdata/builder/sift.conf", "etc/sift/sift.conf"},
+		{"testdata/builder/manual.txt", "usr/share/doc/sift/manual.txt"},
+	} {
+		bs, err := ioutil.ReadFile(f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, fmt.Sprintf("%x  %s", md5.Sum(bs), f[1]))
+	}
+	got := strings.Split(strings.TrimSpace(string(controlFile(t, file, "md5sums"))), "\n")
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("md5sums: want\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestZstdData(t *testing.T) {
+	file, _ := buildTwice(t, "testdata/builder/sift.toml", func(mf *packit.Makefile) {
+		mf.Compression = packit.CompressZstd
+	}, nil)
+	want := []string{"debian-binary", "control.tar.zst", "data.tar.zst"}
+	if got := members(t, file); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("want members %q, got %q", want, got)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := ar.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		h, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Filename == "data.tar.zst" {
+			break
+		}
+	}
+	z, err := zstd.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	files := map[string]string{
+		"usr/bin/sift":                  "testdata/builder/sift.sh",
+		"etc/sift/sift.conf":            "testdata/builder/sift.conf",
+		"usr/share/doc/sift/manual.txt": "testdata/builder/manual.txt",
+	}
+	tr := tar.NewReader(z)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("data.tar.zst: %s", err)
+		}
+		src, ok := files[cleanName(h.Name)]
+		if !ok {
+			continue
+		}
+		delete(files, cleanName(h.Name))
+		body, _ := ioutil.ReadAll(tr)
+		if orig, _ := ioutil.ReadFile(src); !bytes.Equal(body, orig) {
+			t.Errorf("%s: content differs from %s", h.Name, src)
+		}
+	}
+	for n := range files {
+		t.Errorf("%s: missing from data.tar.zst", n)
+	}
+}
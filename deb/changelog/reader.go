@@ -22,18 +22,81 @@ const (
 	nl        = '\n'
 )
 
+// Parse reads the entries of a debian changelog. Malformed entries are
+// skipped: the entries that could be read are returned with an error
+// reporting the ones that were dropped.
 func Parse(r io.Reader) ([]packit.Change, error) {
-	var rs io.RuneScanner
-	if x, ok := r.(io.RuneScanner); ok {
-		rs = x
-	} else {
-		rs = bufio.NewReader(r)
+	var (
+		cs   []packit.Change
+		errs []string
+	)
+	for _, e := range splitEntries(r) {
+		c, err := parseEntry(e.Text)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("line %d: %s", e.Line, err))
+			continue
+		}
+		cs = append(cs, c)
+	}
+	if len(errs) > 0 {
+		return cs, fmt.Errorf("malformed changelog entries skipped (%s)", strings.Join(errs, "; "))
 	}
-	_ = rs
-	var cs []packit.Change
 	return cs, nil
 }
 
+type entry struct {
+	Line int
+	Text string
+}
+
+// splitEntries cuts the changelog before each line starting a new entry ie a
+// line that does not start with a space. Empty lines and comments are dropped.
+func splitEntries(r io.Reader) []entry {
+	var (
+		es  []entry
+		str strings.Builder
+		s   = bufio.NewScanner(r)
+		cur int
+	)
+	for i := 1; s.Scan(); i++ {
+		t := s.Text()
+		if t == "" || t[0] == '#' {
+			continue
+		}
+		if t[0] != ' ' && t[0] != '\t' {
+			if str.Len() > 0 {
+				es = append(es, entry{Line: cur, Text: str.String()})
+				str.Reset()
+			}
+			cur = i
+		}
+		str.WriteString(t)
+		str.WriteByte(nl)
+	}
+	if str.Len() > 0 {
+		es = append(es, entry{Line: cur, Text: str.String()})
+	}
+	return es
+}
+
+func parseEntry(str string) (packit.Change, error) {
+	var (
+		c  = packit.Change{Maintainer: &packit.Maintainer{}}
+		rs = bufio.NewReader(strings.NewReader(str))
+	)
+	err := parseHeader(rs, &c)
+	if err == nil {
+		err = parseBody(rs, &c)
+	}
+	if err == nil {
+		err = parseTrailer(rs, &c)
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return c, err
+}
+
 func parseTrailer(rs io.RuneScanner, c *packit.Change) error {
 	if _, err := readUntil(rs, ' ', nil, nil); err != nil {
 		return err
@@ -90,7 +153,7 @@ func parseHeader(rs io.RuneScanner, c *packit.Change) error {
 		rs.UnreadRune()
 	}
 	var err error
-	if c.Name, err = readUntil(rs, ' ', checkPackageRune, nil); err != nil {
+	if _, err = readUntil(rs, ' ', checkPackageRune, nil); err != nil {
 		return err
 	}
 	if c.Version, err = readUntil(rs, ' ', checkVersionRune, nil); err != nil {
@@ -99,9 +162,11 @@ func parseHeader(rs io.RuneScanner, c *packit.Change) error {
 	if c.Distrib, err = readList(rs, ' ', ';'); err != nil {
 		return err
 	}
-	if _, err = readOptions(rs); err != nil {
+	vs, err := readOptions(rs)
+	if err != nil {
 		return err
 	}
+	c.Urgency = vs["urgency"]
 	return nil
 }
 
@@ -249,7 +314,11 @@ func parseBody(rs io.RuneScanner, c *packit.Change) error {
 			}
 		}
 	}
-	c.Body = body.String()
+	var lines []string
+	for _, t := range strings.Split(strings.TrimRight(body.String(), "\n"), "\n") {
+		lines = append(lines, strings.TrimPrefix(strings.TrimSpace(t), "* "))
+	}
+	c.Body = strings.TrimSpace(strings.Join(lines, "\n"))
 	return nil
 }
 
@@ -257,7 +326,7 @@ func checkVersionRune(k rune) (bool, bool) {
 	if k == '(' || k == ')' {
 		return false, true
 	}
-	if k == '~' {
+	if k == '~' || k == ':' {
 		return true, false
 	}
 	return checkPackageRune(k)
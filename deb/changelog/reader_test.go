@@ -0,0 +1,73 @@
+package changelog
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	r, err := os.Open("testdata/changelog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	cs, err := Parse(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 2 {
+		t.Fatalf("want 2 entries, got %d", len(cs))
+	}
+	data := []struct {
+		Version string
+		Urgency string
+		When    time.Time
+		Lines   int
+	}{
+		{Version: "2.10-3", Urgency: "medium", When: time.Date(2023, 1, 8, 16, 2, 15, 0, time.UTC), Lines: 2},
+		{Version: "2.10-2", Urgency: "low", When: time.Date(2019, 3, 5, 9, 31, 0, 0, time.UTC), Lines: 1},
+	}
+	for i, d := range data {
+		c := cs[i]
+		if c.Version != d.Version {
+			t.Errorf("%d: want version %s, got %s", i, d.Version, c.Version)
+		}
+		if c.Urgency != d.Urgency {
+			t.Errorf("%d: want urgency %s, got %s", i, d.Urgency, c.Urgency)
+		}
+		if !c.When.Equal(d.When) {
+			t.Errorf("%d: want date %s, got %s", i, d.When, c.When)
+		}
+		if len(c.Distrib) != 1 || c.Distrib[0] != "unstable" {
+			t.Errorf("%d: want distribution unstable, got %q", i, c.Distrib)
+		}
+		if c.Maintainer == nil || c.Maintainer.Name != "Santiago Vila" || c.Maintainer.Email != "sanvila@debian.org" {
+			t.Errorf("%d: unexpected maintainer %v", i, c.Maintainer)
+		}
+		if n := len(strings.Split(c.Body, "\n")); n != d.Lines {
+			t.Errorf("%d: want %d changes, got %d in %q", i, d.Lines, n, c.Body)
+		}
+	}
+}
+
+func TestParseSkipMalformed(t *testing.T) {
+	r, err := os.Open("testdata/changelog.malformed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	cs, err := Parse(r)
+	if err == nil {
+		t.Fatal("expected an error reporting the skipped entry")
+	}
+	if !strings.Contains(err.Error(), "line 7") {
+		t.Errorf("error should locate the skipped entry: %s", err)
+	}
+	if len(cs) != 2 || cs[0].Version != "2.10-3" || cs[1].Version != "2.9-1" {
+		t.Errorf("entries around the malformed one should be kept, got %d entries", len(cs))
+	}
+}
@@ -20,7 +20,7 @@ import (
 // {{end}}
 //   -- {{.Maintainer.Name}} <{{.Maintainer.Email}}> {{.When | datetime}}
 // {{end}}`
-const debChangelog = `{{range .Changes}}{{$.Package}} ({{.Version}}) {{.Distrib | join }}; urgency=low
+const debChangelog = `{{range .Changes}}{{$.Package}} ({{.Version}}) {{.Distrib | join }}; urgency={{if .Urgency}}{{.Urgency}}{{else}}low{{end}}
 
 {{if .Body}}{{.Body | indent}}{{end}}
 {{range .Changes}}{{if .Body}}  [{{.Maintainer.Name | title}}]
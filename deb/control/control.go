@@ -3,7 +3,9 @@ package control
 import (
 	"bufio"
 	"bytes"
+	"crypto/md5"
 	"errors"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
@@ -16,47 +18,118 @@ import (
 )
 
 var (
-	ErrSyntax  = errors.New("invalid syntax")
-	ErrUnknown = errors.New("unknown field")
+	ErrSyntax   = errors.New("invalid syntax")
+	ErrUnknown  = errors.New("unknown field")
+	ErrRelation = errors.New("invalid relation")
 )
 
 const debDateFormat = "Mon, 02 Jan 2006 15:04:05 -0700"
 
 const debControl = `
 Package: {{.Package}}
-Version: {{.Version}}
+Version: {{.EVR "deb"}}
 {{if .License}}License: {{.License}}{{end}}
 Section: {{.Section}}
 Priority: {{if .Priority}}{{.Priority}}{{else}}optional{{end}}
+{{if .Essential}}Essential: yes{{end}}
+{{if .Important}}Important: yes{{end}}
 Date: {{.Date | datetime}}
 Architecture: {{arch .Arch}}
 {{if .Vendor}}Vendor: {{.Vendor}}{{end}}
 {{if.Maintainer}}Maintainer: {{.Name}} <{{.Email}}>{{end}}
 {{if .Home}}Homepage: {{.Home}}{{end}}
+{{if .Origin}}Origin: {{.Origin}}{{end}}
+{{if .Bugs}}Bugs: {{.Bugs}}{{end}}
 {{if .Depends }}Depends: {{join .Depends ", "}}{{end}}
 {{if .Suggests }}Suggests: {{join .Suggests ", "}}{{end}}
+{{if .Enhances }}Enhances: {{join .Enhances ", "}}{{end}}
 {{if .Provides}}Provides: {{join .Provides ", "}}{{end}}
 {{if .Conflicts}}Conflicts: {{join .Conflicts ", "}}{{end}}
 {{if .Replaces}}Replaces: {{join .Replaces ", "}}{{end}}
 Installed-Size: {{.Size | bytesize}}
-{{if .Compiler}}Build-Using: {{.Compiler}}{{end}}
+{{if .Compiler}}Built-Using: {{.Compiler}}{{end}}
+{{if .SourceDigest}}X-Source-Digest: {{.SourceDigest}}{{end}}
+{{if .DescMD5}}Description-md5: {{descmd5 .}}{{end}}
 Description: {{if .Summary }}{{.Summary}}{{else}}summary missing{{end}}
 {{if .Desc }}{{indent .Desc}}{{end}}
 `
 
 func Dump(c *packit.Control, w io.Writer) error {
+	ps, err := provides(c.Provides)
+	if err != nil {
+		return err
+	}
+	x := *c
+	x.Provides = ps
+
 	fmap := template.FuncMap{
 		"join":     strings.Join,
 		"arch":     arch,
 		"indent":   indent,
 		"datetime": datetime,
 		"bytesize": bytesize,
+		"descmd5":  DescriptionMD5,
 	}
 	t, err := template.New("control").Funcs(fmap).Parse(strings.TrimSpace(debControl) + "\n")
 	if err != nil {
 		return err
 	}
-	return t.Execute(rw.Clean(w), c)
+	return t.Execute(rw.Clean(w), &x)
+}
+
+func provides(vs []string) ([]string, error) {
+	ps := make([]string, 0, len(vs))
+	for _, v := range vs {
+		n, op, ver, err := parseRelation(v)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "":
+			ps = append(ps, n)
+		case "=":
+			ps = append(ps, fmt.Sprintf("%s (= %s)", n, ver))
+		default:
+			return nil, fmt.Errorf("%w: %s (only = allowed in provides)", ErrRelation, v)
+		}
+	}
+	return ps, nil
+}
+
+func parseRelation(str string) (string, string, string, error) {
+	str = strings.TrimSpace(str)
+	var name, op, version string
+	if ix := strings.Index(str, "("); ix >= 0 {
+		if !strings.HasSuffix(str, ")") {
+			return "", "", "", fmt.Errorf("%w: %s", ErrRelation, str)
+		}
+		name = strings.TrimSpace(str[:ix])
+		rel := strings.TrimSpace(str[ix+1 : len(str)-1])
+		x := strings.IndexFunc(rel, func(r rune) bool { return r != '<' && r != '=' && r != '>' })
+		if x < 0 {
+			x = len(rel)
+		}
+		op, version = rel[:x], strings.TrimSpace(rel[x:])
+		if op == "" || version == "" || strings.ContainsAny(version, " \t()") {
+			return "", "", "", fmt.Errorf("%w: %s", ErrRelation, str)
+		}
+	} else {
+		name = str
+	}
+	if name == "" {
+		return "", "", "", fmt.Errorf("%w: %s", ErrRelation, str)
+	}
+	for _, r := range name {
+		if !(unicode.IsLower(r) || unicode.IsDigit(r) || r == '+' || r == '-' || r == '.' || r == ':') {
+			return "", "", "", fmt.Errorf("%w: %s", ErrRelation, str)
+		}
+	}
+	switch op {
+	case "", "<<", "<=", "=", ">=", ">>":
+	default:
+		return "", "", "", fmt.Errorf("%w: %s", ErrRelation, str)
+	}
+	return name, op, version, nil
 }
 
 func ParseMulti(r io.Reader) ([]*packit.Control, error) {
@@ -75,6 +148,9 @@ func ParseMulti(r io.Reader) ([]*packit.Control, error) {
 		}
 	})
 	for s.Scan() {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
 		c, err := Parse(bytes.NewReader(s.Bytes()))
 		if err == io.EOF {
 			break
@@ -106,13 +182,17 @@ func Parse(r io.Reader) (*packit.Control, error) {
 		case "package":
 			c.Package = v
 		case "version":
-			c.Version = v
+			c.Epoch, c.Version, c.Release = packit.SplitEVR(v)
 		case "license":
 			c.License = v
 		case "section":
 			c.Section = v
 		case "priority":
 			c.Priority = v
+		case "essential":
+			c.Essential = v == "yes"
+		case "important":
+			c.Important = v == "yes"
 		case "architecture":
 			switch v {
 			case "amd64":
@@ -146,18 +226,28 @@ func Parse(r io.Reader) (*packit.Control, error) {
 			c.Breaks = strings.Split(v, ", ")
 		case "suggests":
 			c.Suggests = strings.Split(v, ", ")
+		case "enhances":
+			c.Enhances = strings.Split(v, ", ")
 		case "depends":
 			c.Depends = strings.Split(v, ", ")
 		case "provides":
 			c.Provides = strings.Split(v, ", ")
 		case "installed-size":
-			s, err := strconv.ParseInt(v, 0, 64)
+			s, err := strconv.ParseInt(v, 10, 64)
 			if err != nil {
-				return err
+				return fmt.Errorf("invalid installed-size %q: %v", v, err)
 			}
 			c.Size = s << 10
-		case "build-using":
+		case "built-using", "build-using":
 			c.Compiler = v
+		case "origin":
+			c.Origin = v
+		case "bugs":
+			c.Bugs = v
+		case "description-md5":
+			c.DescMD5 = true
+		case "x-source-digest":
+			c.SourceDigest = v
 		case "description":
 			ps := strings.SplitN(v, "\n", 2)
 			switch len(ps) {
@@ -242,7 +332,7 @@ func parseValue(rs io.RuneScanner) (string, error) {
 	for {
 		r, _, err := rs.ReadRune()
 		if err == io.EOF || r == 0 {
-			return "", nil
+			break
 		}
 		if err != nil {
 			return "", err
@@ -303,6 +393,19 @@ func indent(dsc string) string {
 	return body.String()
 }
 
+func DescriptionMD5(c *packit.Control) string {
+	var body bytes.Buffer
+	if c.Summary == "" {
+		io.WriteString(&body, "summary missing\n")
+	} else {
+		io.WriteString(&body, c.Summary+"\n")
+	}
+	io.WriteString(&body, indent(c.Desc))
+	return fmt.Sprintf("%x", md5.Sum(body.Bytes()))
+}
+
+// bytesize gives the installed size in KiB, rounded up as dpkg-gencontrol
+// does.
 func bytesize(i int64) int64 {
-	return i >> 10
+	return (i + 1023) >> 10
 }
@@ -0,0 +1,263 @@
+package control
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/midbel/packit"
+)
+
+func parseFile(t *testing.T, file string) *packit.Control {
+	t.Helper()
+	r, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	c, err := Parse(r)
+	if err != nil {
+		t.Fatalf("%s: %s", file, err)
+	}
+	return c
+}
+
+func dump(t *testing.T, c *packit.Control) string {
+	t.Helper()
+	var w bytes.Buffer
+	if err := Dump(c, &w); err != nil {
+		t.Fatalf("dump: %s", err)
+	}
+	return w.String()
+}
+
+func TestInstalledSize(t *testing.T) {
+	c := parseFile(t, "testdata/gzip.control")
+	if c.Size != 245<<10 {
+		t.Errorf("installed-size: want %d bytes, got %d", 245<<10, c.Size)
+	}
+	if s := dump(t, c); !strings.Contains(s, "\nInstalled-Size: 245\n") {
+		t.Errorf("installed-size not written back:\n%s", s)
+	}
+	c, err := Parse(strings.NewReader("Package: tool\nVersion: 1.0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Size != 0 {
+		t.Errorf("missing installed-size: want 0, got %d", c.Size)
+	}
+	for size, want := range map[int64]string{0: "0", 1: "1", 1024: "1", 1025: "2", 245<<10 + 1: "246"} {
+		c.Size = size
+		if s := dump(t, c); !strings.Contains(s, "\nInstalled-Size: "+want+"\n") {
+			t.Errorf("installed-size of %d bytes: want %s KiB in\n%s", size, want, s)
+		}
+	}
+	for _, v := range []string{"0x10", "12K", "-"} {
+		if _, err := Parse(strings.NewReader("Package: tool\nInstalled-Size: " + v + "\n")); err == nil {
+			t.Errorf("installed-size %q: expected error", v)
+		}
+	}
+}
+
+func TestParseMulti(t *testing.T) {
+	bs, err := ioutil.ReadFile("testdata/debian.control")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{string(bs), string(bs) + "\n\n"} {
+		cs, err := ParseMulti(strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cs) != 3 {
+			t.Fatalf("want 3 stanzas, got %d", len(cs))
+		}
+		if cs[0].Source != "zstd" || cs[0].Package != "" {
+			t.Errorf("source stanza: got source %q, package %q", cs[0].Source, cs[0].Package)
+		}
+		for i, want := range []struct{ Package, Section string }{{"zstd", ""}, {"libzstd1", "libs"}} {
+			c := cs[i+1]
+			if c.Package != want.Package || c.Section != want.Section {
+				t.Errorf("binary stanza %d: want %s (%q), got %s (%q)", i+1, want.Package, want.Section, c.Package, c.Section)
+			}
+			if !strings.HasPrefix(c.Summary, "fast lossless compression algorithm") {
+				t.Errorf("%s: unexpected summary %q", c.Package, c.Summary)
+			}
+		}
+		if d := cs[2].Desc; !strings.HasSuffix(strings.TrimSpace(d), "compression ratio.") {
+			t.Errorf("libzstd1: last description line lost: %q", d)
+		}
+	}
+}
+
+func TestProvides(t *testing.T) {
+	c := packit.Control{
+		Package:  "exim4-daemon-light",
+		Version:  "4.96",
+		Release:  "15",
+		Summary:  "lightweight Exim MTA daemon",
+		Section:  "mail",
+		Provides: []string{"mail-transport-agent", "exim4-localscanapi-6.0", "exim4-daemon (= 4.96-15)", "libexim(= 4.96)"},
+	}
+	s := dump(t, &c)
+	want := "Provides: mail-transport-agent, exim4-localscanapi-6.0, exim4-daemon (= 4.96-15), libexim (= 4.96)\n"
+	if !strings.Contains(s, want) {
+		t.Errorf("want %q in\n%s", want, s)
+	}
+	x, err := Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(x.Provides) != 4 || x.Provides[2] != "exim4-daemon (= 4.96-15)" {
+		t.Errorf("provides not parsed back: %q", x.Provides)
+	}
+	for _, p := range []string{"exim4-daemon (>= 4.96)", "Exim4", "exim4 (= 4.96", "exim4 (=)"} {
+		c.Provides = []string{p}
+		if err := Dump(&c, ioutil.Discard); !errors.Is(err, ErrRelation) {
+			t.Errorf("%s: want invalid relation, got %v", p, err)
+		}
+	}
+}
+
+func TestParseRelation(t *testing.T) {
+	for _, d := range []struct {
+		Input   string
+		Name    string
+		Op      string
+		Version string
+	}{
+		{Input: "exim4", Name: "exim4"},
+		{Input: " exim4 ", Name: "exim4"},
+		{Input: "exim4 (= 4.96)", Name: "exim4", Op: "=", Version: "4.96"},
+		{Input: "exim4 (=4.96)", Name: "exim4", Op: "=", Version: "4.96"},
+		{Input: "exim4(>=4.96-15)", Name: "exim4", Op: ">=", Version: "4.96-15"},
+		{Input: "exim4 ( << 1:4.96 )", Name: "exim4", Op: "<<", Version: "1:4.96"},
+		{Input: "exim4 (= 4.96) ", Name: "exim4", Op: "=", Version: "4.96"},
+		{Input: "exim4 (4.96)"},
+		{Input: "exim4 (= 4.96 15)"},
+		{Input: "exim4 (=> 4.96)"},
+		{Input: "exim4 (= 4.96) extra"},
+		{Input: "exim4 daemon"},
+		{Input: ""},
+	} {
+		n, op, v, err := parseRelation(d.Input)
+		if d.Name == "" {
+			if !errors.Is(err, ErrRelation) {
+				t.Errorf("%q: want invalid relation, got %q %q %q (%v)", d.Input, n, op, v, err)
+			}
+			continue
+		}
+		if err != nil || n != d.Name || op != d.Op || v != d.Version {
+			t.Errorf("%q: want %q %q %q, got %q %q %q (%v)", d.Input, d.Name, d.Op, d.Version, n, op, v, err)
+		}
+	}
+}
+
+func TestEnhances(t *testing.T) {
+	c := parseFile(t, "testdata/vim-airline.control")
+	want := []string{"vim", "vim-gtk3", "neovim"}
+	if strings.Join(c.Enhances, "|") != strings.Join(want, "|") {
+		t.Errorf("enhances: want %q, got %q", want, c.Enhances)
+	}
+	s := dump(t, c)
+	if !strings.Contains(s, "\nEnhances: vim, vim-gtk3, neovim\n") {
+		t.Errorf("enhances not written back:\n%s", s)
+	}
+	c.Enhances = nil
+	if s := dump(t, c); strings.Contains(s, "Enhances:") {
+		t.Errorf("empty enhances written:\n%s", s)
+	}
+}
+
+func TestOriginBugs(t *testing.T) {
+	c := parseFile(t, "testdata/ripgrep.control")
+	for _, d := range []struct {
+		Field string
+		Got   string
+		Want  string
+	}{
+		{Field: "Origin", Got: c.Origin, Want: "Debian"},
+		{Field: "Bugs", Got: c.Bugs, Want: "debbugs://bugs.debian.org"},
+		{Field: "Built-Using", Got: c.Compiler, Want: "rustc (= 1.63.0+dfsg1-2)"},
+	} {
+		if d.Got != d.Want {
+			t.Errorf("%s: want %q, got %q", d.Field, d.Want, d.Got)
+		}
+	}
+	s := dump(t, c)
+	for _, f := range []string{"Origin: Debian", "Bugs: debbugs://bugs.debian.org", "Built-Using: rustc (= 1.63.0+dfsg1-2)"} {
+		if !strings.Contains(s, "\n"+f+"\n") {
+			t.Errorf("%s not written back:\n%s", f, s)
+		}
+	}
+	c.Origin, c.Bugs, c.Compiler = "", "", ""
+	s = dump(t, c)
+	for _, f := range []string{"Origin:", "Bugs:", "Built-Using:"} {
+		if strings.Contains(s, f) {
+			t.Errorf("empty %s written:\n%s", f, s)
+		}
+	}
+	if c, err := Parse(strings.NewReader("Package: tool\nBuild-Using: gcc-12\n")); err != nil || c.Compiler != "gcc-12" {
+		t.Errorf("Build-Using: want gcc-12, got %v", err)
+	}
+}
+
+func TestEssential(t *testing.T) {
+	for _, d := range []struct {
+		File      string
+		Essential bool
+	}{
+		{File: "testdata/gzip.control", Essential: true},
+		{File: "testdata/ripgrep.control"},
+	} {
+		c := parseFile(t, d.File)
+		if c.Essential != d.Essential || c.Important {
+			t.Errorf("%s: want essential=%t important=false, got %t %t", d.File, d.Essential, c.Essential, c.Important)
+		}
+		s := dump(t, c)
+		if strings.Contains(s, "\nEssential: yes\n") != d.Essential {
+			t.Errorf("%s: unexpected Essential field:\n%s", d.File, s)
+		}
+		if strings.Contains(s, "Important:") || strings.Contains(s, ": no\n") {
+			t.Errorf("%s: unset flag written:\n%s", d.File, s)
+		}
+		c.Essential, c.Important = false, true
+		s = dump(t, c)
+		if strings.Contains(s, "Essential:") || !strings.Contains(s, "\nImportant: yes\n") {
+			t.Errorf("%s: want only Important: yes:\n%s", d.File, s)
+		}
+	}
+}
+
+func TestDescriptionMD5(t *testing.T) {
+	// digests of the Description field as found in the control files (summary
+	// line and continuation lines with their leading space, trailing newline
+	// included), the way apt computes them for the Packages and
+	// Translation files.
+	for file, want := range map[string]string{
+		"testdata/gzip.control":  "100720c9e2c6508f1a1f3731537b38e5",
+		"testdata/hello.control": "c4a4aec43084cfb4a44c959b27e3a6d6",
+	} {
+		c := parseFile(t, file)
+		if got := DescriptionMD5(c); got != want {
+			t.Errorf("%s: want md5 %s, got %s", file, want, got)
+		}
+		if s := dump(t, c); strings.Contains(s, "Description-md5:") {
+			t.Errorf("%s: Description-md5 written without being requested:\n%s", file, s)
+		}
+		c.DescMD5 = true
+		s := dump(t, c)
+		if !strings.Contains(s, "\nDescription-md5: "+want+"\n") {
+			t.Errorf("%s: Description-md5 not written:\n%s", file, s)
+		}
+		if c := parseFile(t, file); c.DescMD5 {
+			t.Errorf("%s: Description-md5 reported without the field", file)
+		}
+		if c, err := Parse(strings.NewReader(s)); err != nil || !c.DescMD5 {
+			t.Errorf("%s: Description-md5 not read back (%v)", file, err)
+		}
+	}
+}
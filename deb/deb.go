@@ -13,20 +13,59 @@ import (
 )
 
 const (
-	debVersion     = "2.0\n"
-	debDataTar     = "data.tar.gz"
-	debControlTar  = "control.tar.gz"
-	debBinaryFile  = "debian-binary"
-	debControlFile = "control"
-	debSumFile     = "md5sums"
-	debConfFile    = "conffiles"
-	debChangeFile  = "changelog.gz"
-	debPreinst     = "preinst"
-	debPostinst    = "postinst"
-	debPrerem      = "prerm"
-	debPostrem     = "postrm"
+	debVersion          = "2.0\n"
+	debDataTar          = "data.tar"
+	debControlTar       = "control.tar"
+	debBinaryFile       = "debian-binary"
+	debSigFile          = "_gpgorigin"
+	debControlFile      = "control"
+	debSumFile          = "md5sums"
+	debConfFile         = "conffiles"
+	debChangeFile       = "changelog.gz"
+	debChangeDebianFile = "changelog.Debian.gz"
+	debPreinst          = "preinst"
+	debPostinst         = "postinst"
+	debPrerem           = "prerm"
+	debPostrem          = "postrm"
 )
 
+func init() {
+	f := packit.Format{
+		Name:        "deb",
+		Ext:         ".deb",
+		Magic:       []byte("!<arch>\n"),
+		Compression: []string{packit.CompressXZ, packit.CompressGZ, packit.CompressZstd, packit.CompressNone},
+		Lossy:       []string{"supplements", "breaks", "obsoletes"},
+		Fields: map[string]string{
+			"package":     "Package",
+			"epoch":       "Version",
+			"version":     "Version",
+			"release":     "Version",
+			"summary":     "Description",
+			"description": "Description",
+			"license":     "License",
+			"section":     "Section",
+			"priority":    "Priority",
+			"arch":        "Architecture",
+			"vendor":      "Vendor",
+			"homepage":    "Homepage",
+			"origin":      "Origin",
+			"bugs":        "Bugs",
+			"maintainer":  "Maintainer",
+			"compiler":    "Built-Using",
+			"depends":     "Depends",
+			"suggests":    "Suggests",
+			"enhances":    "Enhances",
+			"provides":    "Provides",
+			"conflicts":   "Conflicts",
+			"replaces":    "Replaces",
+		},
+	}
+	packit.RegisterFormat(f)
+	packit.RegisterBuilder(f.Name, Build)
+	packit.RegisterReader(f.Name, Open)
+}
+
 func Build(mf *packit.Makefile) (packit.Builder, error) {
 	if mf == nil {
 		return nil, fmt.Errorf("empty makefile")
@@ -36,6 +75,35 @@ func Build(mf *packit.Makefile) (packit.Builder, error) {
 		control: mf.Control,
 		files:   mf.Files,
 		changes: mf.Changes,
+		scripts: []*packit.Script{mf.Preinst, mf.Postinst, mf.Prerm, mf.Postrm},
+	}
+	b.compress = packit.Compressor{
+		Method:  mf.Compression,
+		Check:   mf.XZCheck,
+		Threads: mf.Threads,
+		ModTime: b.when,
+	}
+	if b.compress.Method == "" || b.compress.Method == packit.CompressAuto {
+		b.compress.Method = packit.CompressXZ
+	}
+	if err := b.compress.Valid(); err != nil {
+		return nil, err
+	}
+	if err := mf.Expand(); err != nil {
+		return nil, err
+	}
+	b.files = mf.Files
+	if b.control != nil {
+		if err := b.control.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if b.control != nil && mf.DigestSources {
+		d, err := mf.Digest()
+		if err != nil {
+			return nil, err
+		}
+		b.control.SourceDigest = d
 	}
 	return &b, nil
 }
@@ -51,9 +119,11 @@ func Open(file string) (packit.Package, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err := readData(r, p); err != nil {
+	z, err := readData(r)
+	if err != nil {
 		return nil, err
 	}
+	z.Close()
 	return p, nil
 }
 
@@ -65,7 +135,10 @@ func openFile(f *os.File) (tape.Reader, *pkg, error) {
 	if err := readDebian(r); err != nil {
 		return nil, nil, err
 	}
-	p := pkg{name: filepath.Base(f.Name())}
+	p := pkg{
+		name: filepath.Base(f.Name()),
+		file: f.Name(),
+	}
 	if err := readControl(r, &p); err != nil {
 		return nil, nil, err
 	}
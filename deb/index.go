@@ -0,0 +1,70 @@
+package deb
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/midbel/packit"
+)
+
+func Index(w io.Writer, base string, files []string) error {
+	for _, f := range files {
+		if err := indexPackage(w, base, f); err != nil {
+			return fmt.Errorf("%s: %s", f, err)
+		}
+	}
+	return nil
+}
+
+func indexPackage(w io.Writer, base, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, p, err := openFile(f)
+	if err != nil {
+		return err
+	}
+	if p.control == nil {
+		return packit.ErrMalformedPackage
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var (
+		m5  = md5.New()
+		s1  = sha1.New()
+		s2  = sha256.New()
+		mw  = io.MultiWriter(m5, s1, s2)
+		bs  []byte
+		rel string
+	)
+	size, err := io.Copy(mw, f)
+	if err != nil {
+		return err
+	}
+	if bs, err = ioutil.ReadAll(p.control); err != nil {
+		return err
+	}
+	if rel, err = filepath.Rel(base, file); err != nil {
+		rel = filepath.Base(file)
+	}
+	w.Write(bytes.TrimRight(bs, "\n"))
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Filename: %s\n", filepath.ToSlash(rel))
+	fmt.Fprintf(w, "Size: %d\n", size)
+	fmt.Fprintf(w, "MD5sum: %x\n", m5.Sum(nil))
+	fmt.Fprintf(w, "SHA1: %x\n", s1.Sum(nil))
+	fmt.Fprintf(w, "SHA256: %x\n", s2.Sum(nil))
+	_, err = fmt.Fprintln(w)
+	return err
+}
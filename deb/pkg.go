@@ -18,17 +18,39 @@ import (
 	"github.com/midbel/packit/deb/changelog"
 	"github.com/midbel/packit/deb/control"
 	"github.com/midbel/tape"
-	"github.com/ulikunitz/xz"
+	"github.com/midbel/tape/ar"
+	"golang.org/x/crypto/openpgp"
 )
 
 type pkg struct {
 	name string
+	file string
 
 	control   *bytes.Reader
 	md5sums   *bytes.Reader
 	conffiles *bytes.Reader
+	scripts   map[string]*packit.Script
 
-	data *bytes.Reader
+	history  packit.History
+	loaded   bool
+	warnings []string
+}
+
+const extractBuffer = 4 << 20
+
+// typeModes gives the type bits of the mode of the tar entries reported by
+// Resources.
+var typeModes = map[byte]int64{
+	tar.TypeReg:     0100000,
+	tar.TypeSymlink: 0120000,
+	tar.TypeDir:     0040000,
+	tar.TypeFifo:    0010000,
+	tar.TypeChar:    0020000,
+	tar.TypeBlock:   0060000,
+}
+
+func (p *pkg) Scripts() map[string]*packit.Script {
+	return p.scripts
 }
 
 func (p *pkg) PackageType() string {
@@ -39,67 +61,159 @@ func (p *pkg) PackageName() string {
 	return strings.TrimSuffix(p.name, ".deb")
 }
 
+func (p *pkg) Warnings() []string {
+	return p.warnings
+}
+
+// History gives the entries of the changelog of the package. Malformed
+// entries are skipped and reported as warnings.
 func (p *pkg) History() packit.History {
-	if _, err := p.data.Seek(0, io.SeekStart); err != nil {
+	if p.loaded {
+		return p.history
+	}
+	p.loaded = true
+	z, err := p.payload()
+	if err != nil {
 		return nil
 	}
-	var cs []packit.Change
-	r := tar.NewReader(p.data)
+	defer z.Close()
+	r := tar.NewReader(z)
 	for {
 		h, err := r.Next()
 		if err != nil {
 			break
 		}
-		if filepath.Base(h.Name) == debChangeFile {
-			cs, err = changelog.Parse(io.LimitReader(r, h.Size))
+		if n := filepath.Base(h.Name); n == debChangeFile || n == debChangeDebianFile {
+			z, err := gzip.NewReader(io.LimitReader(r, h.Size))
+			if err != nil {
+				break
+			}
+			cs, err := changelog.Parse(z)
+			if err != nil {
+				p.warnings = append(p.warnings, fmt.Sprintf("%s: %s", cleanName(h.Name), err))
+			}
+			p.history = packit.History(cs)
 			break
 		}
 	}
-	return packit.History(cs)
+	return p.history
 }
 
 func (p *pkg) Valid() error {
-	if _, err := p.md5sums.Seek(0, io.SeekStart); err != nil {
+	cs, err := p.Verify()
+	if err != nil {
 		return err
 	}
-	ds := make(map[string]string)
-	s := bufio.NewScanner(p.md5sums)
-	for s.Scan() {
-		fs := strings.Fields(s.Text())
-		ds[fs[1]] = fs[0]
+	for _, c := range cs {
+		if c.Match {
+			continue
+		}
+		if c.Expected == "" {
+			return fmt.Errorf("file not found in md5sums %s", c.Name)
+		}
+		return fmt.Errorf("invalid checksum for %s", c.Name)
 	}
-	if err := s.Err(); err != nil {
-		return err
+	return nil
+}
+
+func (p *pkg) Verify() ([]packit.FileCheck, error) {
+	ds, err := p.sums()
+	if err != nil {
+		return nil, err
 	}
-	if _, err := p.data.Seek(0, io.SeekStart); err != nil {
-		return err
+	z, err := p.payload()
+	if err != nil {
+		return nil, err
 	}
-	r := tar.NewReader(p.data)
+	defer z.Close()
+	var (
+		cs []packit.FileCheck
+		r  = tar.NewReader(z)
+	)
 	for {
 		h, err := r.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if h.Typeflag != tar.TypeReg {
 			continue
 		}
 		digest := md5.New()
 		if _, err := io.CopyN(digest, r, h.Size); err != nil {
-			return err
+			return nil, err
+		}
+		c := packit.FileCheck{
+			Name:     h.Name,
+			Expected: ds[cleanName(h.Name)],
+			Actual:   hex.EncodeToString(digest.Sum(nil)),
 		}
-		sum := hex.EncodeToString(digest.Sum(nil))
-		if s, ok := ds[h.Name]; ok {
-			if s != sum {
-				return fmt.Errorf("invalid checksum for %s", h.Name)
+		c.Match = c.Expected == c.Actual
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+func (p *pkg) Signature(kr openpgp.KeyRing) (*packit.Signature, error) {
+	f, err := os.Open(p.file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := ar.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		signed bytes.Buffer
+		sig    []byte
+	)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case h.Filename == debSigFile:
+			if sig, err = ioutil.ReadAll(r); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(h.Filename, "_"):
+		default:
+			if _, err := io.Copy(&signed, r); err != nil {
+				return nil, err
 			}
-		} else {
-			return fmt.Errorf("file not found in md5sums %s", h.Name)
 		}
 	}
-	return nil
+	if len(sig) == 0 {
+		return nil, nil
+	}
+	return packit.CheckSignature(kr, "gpg", &signed, sig), nil
+}
+
+func (p *pkg) sums() (map[string]string, error) {
+	ds := make(map[string]string)
+	if p.md5sums == nil {
+		return ds, nil
+	}
+	if _, err := p.md5sums.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	s := bufio.NewScanner(p.md5sums)
+	for s.Scan() {
+		fs := strings.Fields(s.Text())
+		if len(fs) < 2 {
+			continue
+		}
+		ds[cleanName(fs[1])] = fs[0]
+	}
+	return ds, s.Err()
 }
 
 func (p *pkg) About() packit.Control {
@@ -110,14 +224,34 @@ func (p *pkg) About() packit.Control {
 	if x, err := control.Parse(p.control); err == nil {
 		c = *x
 	}
+	c.ConfFiles = p.confFiles()
 	return c
 }
 
+func (p *pkg) confFiles() []string {
+	if p.conffiles == nil {
+		return nil
+	}
+	if _, err := p.conffiles.Seek(0, io.SeekStart); err != nil {
+		return nil
+	}
+	var fs []string
+	s := bufio.NewScanner(p.conffiles)
+	for s.Scan() {
+		if f := strings.TrimSpace(s.Text()); f != "" {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
 func (p *pkg) Resources() ([]packit.Resource, error) {
-	if _, err := p.data.Seek(0, io.SeekStart); err != nil {
+	z, err := p.payload()
+	if err != nil {
 		return nil, err
 	}
-	r := tar.NewReader(p.data)
+	defer z.Close()
+	r := tar.NewReader(z)
 	var rs []packit.Resource
 	for {
 		h, err := r.Next()
@@ -127,14 +261,22 @@ func (p *pkg) Resources() ([]packit.Resource, error) {
 		if err != nil {
 			return nil, err
 		}
-		if h.Typeflag != tar.TypeReg {
+		mode, ok := typeModes[h.Typeflag]
+		if !ok {
 			continue
 		}
 		e := packit.Resource{
 			Name:    h.Name,
 			ModTime: h.ModTime,
 			Size:    h.Size,
-			Perm:    h.Mode,
+			Perm:    mode | h.Mode&07777,
+			Uid:     h.Uid,
+			Gid:     h.Gid,
+			User:    h.Uname,
+			Group:   h.Gname,
+			Link:    h.Linkname,
+			Major:   int(h.Devmajor),
+			Minor:   int(h.Devminor),
 		}
 		rs = append(rs, e)
 		if _, err := io.CopyN(ioutil.Discard, r, h.Size); err != nil {
@@ -144,65 +286,156 @@ func (p *pkg) Resources() ([]packit.Resource, error) {
 	return rs, nil
 }
 
+// Filenames gives the names of the entries of the data archive that are not
+// directories.
 func (p *pkg) Filenames() ([]string, error) {
 	rs, err := p.Resources()
 	if err != nil {
 		return nil, err
 	}
-	vs := make([]string, len(rs))
-	for i := 0; i < len(rs); i++ {
-		vs[i] = rs[i].Name
+	var vs []string
+	for _, r := range rs {
+		if r.Perm&0170000 != 0040000 {
+			vs = append(vs, r.Name)
+		}
 	}
 	return vs, nil
 }
 
-func (p *pkg) Extract(datadir string, preserve bool) error {
+func (p *pkg) Extract(datadir string, opts packit.ExtractOptions) error {
 	if err := os.MkdirAll(datadir, 0755); err != nil && !os.IsExist(err) {
 		return err
 	}
-	if _, err := p.data.Seek(0, io.SeekStart); err != nil {
-		return err
-	}
-	r := tar.NewReader(p.data)
-	for {
-		h, err := r.Next()
-		if err == io.EOF {
-			break
-		}
+	var ds map[string]string
+	if opts.Skip {
+		xs, err := p.sums()
 		if err != nil {
 			return err
 		}
-		if h.Typeflag != tar.TypeReg {
-			continue
-		}
-		dir, _ := filepath.Split(h.Name)
-		if err := os.MkdirAll(filepath.Join(datadir, dir), 0755); err != nil {
-			return err
-		}
-		name := filepath.Join(datadir, h.Name)
-		w, err := os.Create(name)
-		if err != nil {
-			return err
-		}
-		if _, err := io.CopyN(w, r, h.Size); err != nil {
-			return err
-		}
-		if err := w.Close(); err != nil {
-			return err
-		}
-		if preserve {
-			if err := os.Chmod(name, os.FileMode(h.Mode)); err != nil {
+		ds = xs
+	}
+	z, err := p.payload()
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+	wk := packit.NewWorkers(opts.Jobs)
+	err = func() error {
+		var (
+			r   = tar.NewReader(z)
+			buf = make([]byte, 32<<10)
+		)
+		for {
+			h, err := r.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
 				return err
 			}
-			if err := os.Chown(name, h.Uid, h.Gid); err != nil {
+			name, err := packit.ExtractPath(datadir, h.Name)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return err
+			}
+			switch h.Typeflag {
+			case tar.TypeReg:
+			case tar.TypeDir:
+				if err := os.MkdirAll(name, 0755); err != nil {
+					return err
+				}
+				if opts.Preserve {
+					if err := setAttrs(name, h); err != nil {
+						return err
+					}
+				}
+				continue
+			case tar.TypeSymlink:
+				if err := packit.ExtractLink(name, h.Linkname); err != nil {
+					return err
+				}
+				if opts.Preserve && packit.CanChown() {
+					if err := os.Lchown(name, h.Uid, h.Gid); err != nil {
+						return err
+					}
+				}
+				continue
+			default:
+				continue
+			}
+			if s, ok := ds[cleanName(h.Name)]; ok && packit.SameDigest(name, s, md5.New()) {
+				continue
+			}
+			if h.Size > extractBuffer {
+				if err := streamFile(name, r, h, buf, opts.Preserve); err != nil {
+					return err
+				}
+				continue
+			}
+			bs := make([]byte, h.Size)
+			if _, err := io.ReadFull(r, bs); err != nil {
 				return err
 			}
-			if err := os.Chtimes(name, h.ModTime, h.ModTime); err != nil {
+			err = wk.Go(func() error {
+				return extractFile(name, bs, h, opts.Preserve)
+			})
+			if err != nil {
 				return err
 			}
 		}
+	}()
+	if e := wk.Wait(); err == nil {
+		err = e
 	}
-	return nil
+	return err
+}
+
+func streamFile(name string, r io.Reader, h *tar.Header, buf []byte, preserve bool) error {
+	if err := packit.Unlink(name); err != nil {
+		return err
+	}
+	w, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	n, err := io.CopyBuffer(w, r, buf)
+	if err == nil && n != h.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	if err != nil || !preserve {
+		return err
+	}
+	return setAttrs(name, h)
+}
+
+func extractFile(name string, body []byte, h *tar.Header, preserve bool) error {
+	if err := packit.Unlink(name); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(name, body, 0666); err != nil {
+		return err
+	}
+	if !preserve {
+		return nil
+	}
+	return setAttrs(name, h)
+}
+
+func setAttrs(name string, h *tar.Header) error {
+	if err := os.Chmod(name, os.FileMode(h.Mode&07777)); err != nil {
+		return err
+	}
+	if packit.CanChown() {
+		if err := os.Chown(name, h.Uid, h.Gid); err != nil {
+			return err
+		}
+	}
+	return os.Chtimes(name, h.ModTime, h.ModTime)
 }
 
 func readDebian(r tape.Reader) error {
@@ -231,23 +464,11 @@ func readControl(r tape.Reader, p *pkg) error {
 	if !strings.HasPrefix(filepath.Base(h.Filename), "control") {
 		return packit.ErrMalformedPackage
 	}
-	var rs io.Reader
-	switch e := filepath.Ext(h.Filename); e {
-	case packit.ExtGZ:
-		rs, err = gzip.NewReader(r)
-		// if err != nil {
-		// 	return err
-		// }
-		// rs = z
-	case packit.ExtXZ:
-		rs, err = xz.NewReader(r)
-		// return packit.ErrUnsupportedPayloadFormat
-	default:
-		return packit.ErrMalformedPackage
-	}
+	rs, err := uncompress(r, h.Filename)
 	if err != nil {
 		return err
 	}
+	defer rs.Close()
 	t := tar.NewReader(rs)
 	for {
 		h, err := t.Next()
@@ -269,39 +490,88 @@ func readControl(r tape.Reader, p *pkg) error {
 		case debConfFile, "./" + debConfFile:
 			p.conffiles = bytes.NewReader(bs)
 		default:
+			switch n := strings.TrimPrefix(h.Name, "./"); n {
+			case debPreinst, debPostinst, debPrerem, debPostrem:
+				if p.scripts == nil {
+					p.scripts = make(map[string]*packit.Script)
+				}
+				p.scripts[n] = packit.NewScript("", string(bs))
+			}
 		}
 	}
 	return nil
 }
 
-func readData(r tape.Reader, p *pkg) error {
+// payload gives the uncompressed data member of the package. The member is
+// read again from the package file on each call instead of being kept in
+// memory.
+func (p *pkg) payload() (io.ReadCloser, error) {
+	f, err := os.Open(p.file)
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := openFile(f)
+	if err == nil {
+		var z io.ReadCloser
+		if z, err = readData(r); err == nil {
+			return readCloser{ReadCloser: z, file: f}, nil
+		}
+	}
+	f.Close()
+	return nil, err
+}
+
+type readCloser struct {
+	io.ReadCloser
+	file *os.File
+}
+
+// Close releases the decompressor of the member before closing the package
+// file.
+func (r readCloser) Close() error {
+	err := r.ReadCloser.Close()
+	if e := r.file.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func readData(r tape.Reader) (io.ReadCloser, error) {
 	h, err := r.Next()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if !strings.HasPrefix(filepath.Base(h.Filename), "data") {
-		return packit.ErrMalformedPackage
+		return nil, packit.ErrMalformedPackage
 	}
-	var rs io.Reader
-	switch e := filepath.Ext(h.Filename); e {
-	case packit.ExtGZ:
-		rs, err = gzip.NewReader(r)
-		// if err != nil {
-		// 	return err
-		// }
-		// rs = z
+	return uncompress(r, h.Filename)
+}
+
+// memberMethod gives the compression of an archive member from its name.
+func memberMethod(file string) string {
+	switch filepath.Ext(file) {
 	case packit.ExtXZ:
-		rs, err = xz.NewReader(r)
-		// return packit.ErrUnsupportedPayloadFormat
+		return packit.CompressXZ
+	case packit.ExtZstd:
+		return packit.CompressZstd
+	case ".tar":
+		return packit.CompressNone
 	default:
-		return packit.ErrMalformedPackage
-	}
-	if err != nil {
-		return err
+		return packit.CompressGZ
 	}
-	bs, err := ioutil.ReadAll(rs)
-	if err == nil {
-		p.data = bytes.NewReader(bs)
+}
+
+func uncompress(r io.Reader, file string) (io.ReadCloser, error) {
+	switch e := filepath.Ext(file); e {
+	case packit.ExtGZ:
+		return packit.Decompress(packit.CompressGZ, r)
+	case packit.ExtXZ:
+		return packit.Decompress(packit.CompressXZ, r)
+	case packit.ExtZstd:
+		return packit.Decompress(packit.CompressZstd, r)
+	case ".tar":
+		return packit.Decompress(packit.CompressNone, r)
+	default:
+		return nil, packit.ErrMalformedPackage
 	}
-	return err
 }
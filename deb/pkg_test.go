@@ -0,0 +1,418 @@
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/midbel/packit"
+	"github.com/midbel/tape/ar"
+)
+
+// writeDeb assembles a deb archive by hand so that packages with entries the
+// builder never writes (symlinks, hostile names) can be tested.
+func writeDeb(t *testing.T, control string, es []entry) string {
+	t.Helper()
+	ctrl, err := ioutil.ReadFile(control)
+	if err != nil {
+		t.Fatal(err)
+	}
+	when := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	tarball := func(es []entry) *bytes.Buffer {
+		var (
+			b bytes.Buffer
+			w = tar.NewWriter(&b)
+		)
+		for _, e := range es {
+			e.Size, e.ModTime = int64(len(e.Body)), when
+			if e.Mode == 0 {
+				e.Mode = 0644
+			}
+			if err := w.WriteHeader(e.Header); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(e.Body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return &b
+	}
+	cs := []entry{{Header: &tar.Header{Name: "./control", Typeflag: tar.TypeReg}, Body: ctrl}}
+
+	file := filepath.Join(t.TempDir(), filepath.Base(control)+".deb")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	aw, err := ar.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := packit.Compressor{Method: packit.CompressGZ, ModTime: when}
+	if err := writeDebian(aw, when); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeMember(aw, debControlTar, c, tarball(cs)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeMember(aw, debDataTar, c, tarball(es)); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func dir(n string) entry {
+	return entry{Header: &tar.Header{Name: n, Typeflag: tar.TypeDir, Mode: 0755}}
+}
+
+func reg(n, body string) entry {
+	return entry{Header: &tar.Header{Name: n, Typeflag: tar.TypeReg}, Body: []byte(body)}
+}
+
+func symlink(n, target string) entry {
+	return entry{Header: &tar.Header{Name: n, Typeflag: tar.TypeSymlink, Linkname: target, Mode: 0777}}
+}
+
+func TestExtractLinks(t *testing.T) {
+	file := writeDeb(t, "testdata/libfoo.control", []entry{
+		dir("./usr/"),
+		dir("./usr/lib/"),
+		reg("./usr/lib/libfoo.so.1.2.0", "\x7fELF fake shared object"),
+		symlink("./usr/lib/libfoo.so.1", "libfoo.so.1.2.0"),
+		dir("./usr/share/doc/libfoo1/"),
+	})
+	p, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	datadir := t.TempDir()
+	if err := p.Extract(datadir, packit.ExtractOptions{Preserve: true}); err != nil {
+		t.Fatal(err)
+	}
+	link, err := os.Readlink(filepath.Join(datadir, "usr/lib/libfoo.so.1"))
+	if err != nil {
+		t.Fatalf("symlink not extracted: %s", err)
+	}
+	if link != "libfoo.so.1.2.0" {
+		t.Errorf("symlink: want target libfoo.so.1.2.0, got %s", link)
+	}
+	if i, err := os.Stat(filepath.Join(datadir, "usr/share/doc/libfoo1")); err != nil || !i.IsDir() {
+		t.Errorf("empty directory not extracted: %v", err)
+	}
+	rs, err := p.Resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, r := range rs {
+		if r.Perm&0170000 == 0040000 {
+			continue
+		}
+		names = append(names, r.Name)
+		if r.Name == "./usr/lib/libfoo.so.1" && (r.Perm&0170000 != 0120000 || r.Link != "libfoo.so.1.2.0") {
+			t.Errorf("%s: want symlink to libfoo.so.1.2.0, got mode %o link %q", r.Name, r.Perm, r.Link)
+		}
+	}
+	if len(names) != 2 || names[1] != "./usr/lib/libfoo.so.1" {
+		t.Errorf("resources: want the file and its symlink, got %q", names)
+	}
+}
+
+func TestExtractOutside(t *testing.T) {
+	outside := t.TempDir()
+	data := []struct {
+		Name    string
+		Entries []entry
+	}{
+		{
+			Name:    "dot-dot",
+			Entries: []entry{reg("./usr/../../evil", "escaped")},
+		},
+		{
+			Name: "through-symlink",
+			Entries: []entry{
+				dir("./usr/"),
+				symlink("./usr/share", outside),
+				reg("./usr/share/evil", "escaped"),
+			},
+		},
+	}
+	for _, d := range data {
+		t.Run(d.Name, func(t *testing.T) {
+			p, err := Open(writeDeb(t, "testdata/libfoo.control", d.Entries))
+			if err != nil {
+				t.Fatal(err)
+			}
+			datadir := filepath.Join(t.TempDir(), "root")
+			if err := p.Extract(datadir, packit.ExtractOptions{}); err == nil {
+				t.Errorf("extract should fail for entries leaving %s", datadir)
+			}
+			for _, f := range []string{filepath.Join(outside, "evil"), filepath.Join(filepath.Dir(datadir), "evil")} {
+				if _, err := os.Stat(f); err == nil {
+					t.Errorf("%s written outside of root", f)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryWarnings(t *testing.T) {
+	bs, err := ioutil.ReadFile("changelog/testdata/changelog.malformed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var z bytes.Buffer
+	w := gzip.NewWriter(&z)
+	w.Write(bs)
+	w.Close()
+
+	file := writeDeb(t, "testdata/libfoo.control", []entry{
+		dir("./usr/share/doc/libfoo1/"),
+		reg("./usr/share/doc/libfoo1/changelog.Debian.gz", z.String()),
+	})
+	p, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := p.History(); len(h) != 2 {
+		t.Errorf("want 2 changelog entries, got %d", len(h))
+	}
+	ws := p.(packit.Warner).Warnings()
+	if len(ws) != 1 || !strings.Contains(ws[0], "changelog.Debian.gz") {
+		t.Errorf("skipped entry not reported: %q", ws)
+	}
+	p.History()
+	if n := len(p.(packit.Warner).Warnings()); n != 1 {
+		t.Errorf("warning reported %d times", n)
+	}
+}
+
+func TestConfFiles(t *testing.T) {
+	file := buildFixture(t, "testdata/conf/agent.toml")
+	p, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/etc/metrics-agent/agent.conf", "/etc/metrics-agent/scrape.d/scrape.conf"}
+	got := p.About().ConfFiles
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("conffiles: want %q, got %q", want, got)
+	}
+	if got := string(controlFile(t, file, "conffiles")); got != strings.Join(want, "\n")+"\n" {
+		t.Errorf("conffiles member: want one absolute path per line, got %q", got)
+	}
+}
+
+func TestSourceDigest(t *testing.T) {
+	const file = "testdata/digest/beacon.toml"
+	mf, err := packit.Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := mf.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Open(buildFixture(t, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.About().SourceDigest; got != want {
+		t.Errorf("X-Source-Digest: want %s, got %s", want, got)
+	}
+	mf.Files[1].Body = []byte("grafana 3001\n")
+	if d, err := mf.Digest(); err != nil || d == want {
+		t.Errorf("digest unchanged after changing a source (%v)", err)
+	}
+	p, err = Open(buildFixture(t, "testdata/conf/agent.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := p.About().SourceDigest; d != "" {
+		t.Errorf("X-Source-Digest written without source-digest: %s", d)
+	}
+}
+
+func TestSpecialFiles(t *testing.T) {
+	p, err := Open(buildFixture(t, "testdata/special/console.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Valid(); err != nil {
+		t.Errorf("valid: %s", err)
+	}
+	want := map[string]tar.Header{
+		"dev/ttyS0":              {Typeflag: tar.TypeChar, Mode: 0660, Devmajor: 4, Devminor: 64},
+		"run/console/control":    {Typeflag: tar.TypeFifo, Mode: 0600},
+		"usr/sbin/console-setup": {Typeflag: tar.TypeReg, Mode: 0755},
+	}
+	z, err := p.(*pkg).payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	r := tar.NewReader(z)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := cleanName(h.Name)
+		w, ok := want[n]
+		if !ok {
+			continue
+		}
+		delete(want, n)
+		if h.Typeflag != w.Typeflag || h.Mode&07777 != w.Mode || h.Devmajor != w.Devmajor || h.Devminor != w.Devminor {
+			t.Errorf("%s: want type %c mode %o dev %d,%d, got type %c mode %o dev %d,%d", n, w.Typeflag, w.Mode, w.Devmajor, w.Devminor, h.Typeflag, h.Mode&07777, h.Devmajor, h.Devminor)
+		}
+	}
+	for n := range want {
+		t.Errorf("%s: missing from data.tar", n)
+	}
+}
+
+func TestMissingMD5Sums(t *testing.T) {
+	file := writeDeb(t, "testdata/libfoo.control", []entry{
+		dir("./usr/lib/"),
+		reg("./usr/lib/libfoo.so.1.2.0", "\x7fELF fake shared object"),
+		{Header: &tar.Header{Name: "./run/libfoo.fifo", Typeflag: tar.TypeFifo, Mode: 0600}},
+	})
+	p, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := p.Verify()
+	if err != nil {
+		t.Fatalf("verify: %s", err)
+	}
+	if len(cs) != 1 || cs[0].Expected != "" || cs[0].Match {
+		t.Errorf("verify: want libfoo.so.1.2.0 reported without checksum, got %+v", cs)
+	}
+	if err := p.Valid(); err == nil {
+		t.Errorf("valid: file without checksum accepted")
+	}
+	datadir := t.TempDir()
+	if err := p.Extract(datadir, packit.ExtractOptions{Skip: true}); err != nil {
+		t.Fatalf("extract: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(datadir, "usr/lib/libfoo.so.1.2.0")); err != nil {
+		t.Errorf("file not extracted: %s", err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	bs, err := ioutil.ReadFile("testdata/history/changelog.Debian")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	z := gzip.NewWriter(&buf)
+	z.Write(bs)
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file := writeDeb(t, "testdata/history/tree.control", []entry{
+		dir("./usr/"),
+		dir("./usr/bin/"),
+		reg("./usr/bin/tree", "\x7fELF fake executable"),
+		dir("./usr/share/doc/tree/"),
+		reg("./usr/share/doc/tree/changelog.Debian.gz", buf.String()),
+	})
+	p, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := p.History().All()
+	if len(cs) != 2 {
+		t.Fatalf("want 2 changelog entries, got %d", len(cs))
+	}
+	for i, d := range []struct {
+		Version string
+		Distrib string
+		Urgency string
+		When    time.Time
+		Body    string
+	}{
+		{
+			Version: "2.1.0-1",
+			Distrib: "unstable",
+			Urgency: "medium",
+			When:    time.Date(2023, 1, 2, 20, 10, 40, 0, time.UTC),
+			Body:    "New upstream release.\nUpdate the homepage of upstream.",
+		},
+		{
+			Version: "2.0.4-1",
+			Distrib: "experimental",
+			Urgency: "low",
+			When:    time.Date(2022, 9, 17, 10, 1, 52, 0, time.UTC),
+			Body:    "New upstream release.",
+		},
+	} {
+		c := cs[i]
+		if c.Version != d.Version || c.Urgency != d.Urgency || strings.Join(c.Distrib, " ") != d.Distrib {
+			t.Errorf("%d: want %s %s urgency=%s, got %s %q urgency=%s", i, d.Version, d.Distrib, d.Urgency, c.Version, c.Distrib, c.Urgency)
+		}
+		if !c.When.Equal(d.When) {
+			t.Errorf("%d: want date %s, got %s", i, d.When, c.When)
+		}
+		if c.Body != d.Body {
+			t.Errorf("%d: want changes %q, got %q", i, d.Body, c.Body)
+		}
+		if c.Maintainer == nil || c.Maintainer.Name != "Florian Ernst" || c.Maintainer.Email != "florian@debian.org" {
+			t.Errorf("%d: unexpected maintainer %v", i, c.Maintainer)
+		}
+	}
+}
+
+func TestLongNames(t *testing.T) {
+	file := buildFixture(t, "testdata/pax/notes.toml")
+	p, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Valid(); err != nil {
+		t.Errorf("valid: %s", err)
+	}
+	deep := "usr/share/deep/level-1-of-the-nested-tree/level-2-of-the-nested-tree/level-3-of-the-nested-tree/level-4-of-the-nested-tree/leaf-directories/notes.txt"
+	want := map[string]struct{}{
+		deep: {},
+		"usr/share/doc/notes/a-release-note-whose-name-is-longer-than-what-a-ustar-name-field-can-hold-without-any-prefix-split-at-all-v2.4.1.txt": {},
+	}
+	rs, err := p.Resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rs {
+		delete(want, cleanName(r.Name))
+	}
+	for n := range want {
+		t.Errorf("%s: not read back intact", n)
+	}
+
+	datadir := t.TempDir()
+	if err := p.Extract(datadir, packit.ExtractOptions{}); err != nil {
+		t.Fatalf("extract: %s", err)
+	}
+	bs, err := ioutil.ReadFile(filepath.Join(datadir, deep))
+	if err != nil || !strings.HasPrefix(string(bs), "Paths longer than 100 bytes") {
+		t.Errorf("long path not extracted: %v", err)
+	}
+}
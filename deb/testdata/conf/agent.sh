@@ -0,0 +1,2 @@
+#!/bin/sh
+exec /usr/lib/agent/agent -c /etc/agent/agent.conf
@@ -0,0 +1,6 @@
+#!/bin/sh
+# beacon announces every service listed in the configuration file.
+while read -r name port; do
+	avahi-publish -s "$name" _http._tcp "$port" &
+done < /etc/beacon/services.conf
+wait
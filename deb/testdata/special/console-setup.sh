@@ -0,0 +1,3 @@
+#!/bin/sh
+# console-setup configures the serial line used by the getty.
+stty -F /dev/ttyS0 115200 cs8 -parenb -cstopb
@@ -0,0 +1,369 @@
+package packit
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var elfMagic = []byte(elf.ELFMAG)
+
+func IsELF(bs []byte) bool {
+	return bytes.HasPrefix(bs, elfMagic)
+}
+
+func isDebugSection(n string) bool {
+	for _, p := range []string{".debug", ".zdebug", ".stab", ".gdb_index", ".line"} {
+		if strings.HasPrefix(n, p) {
+			return true
+		}
+	}
+	return false
+}
+
+type elfSection struct {
+	Name      string
+	Type      uint32
+	Flags     uint64
+	Addr      uint64
+	Offset    uint64
+	Size      uint64
+	Link      uint32
+	Info      uint32
+	Addralign uint64
+	Entsize   uint64
+	NameIdx   uint32
+}
+
+// StripDebug removes the debug sections of an ELF executable or shared
+// object. Everything covered by the program headers is kept as is, the
+// remaining non debug sections are packed after it.
+func StripDebug(bs []byte) ([]byte, error) {
+	f, err := elf.NewFile(bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+		return bs, nil
+	}
+	var (
+		is64 = f.Class == elf.ELFCLASS64
+		ord  = f.ByteOrder
+	)
+	ss, strndx, err := readSections(bs, is64, ord)
+	if err != nil {
+		return nil, err
+	}
+
+	var end uint64
+	for _, p := range f.Progs {
+		if e := p.Off + p.Filesz; e > end {
+			end = e
+		}
+	}
+	var (
+		keep  = make([]int, len(ss))
+		drop  int
+		index int
+	)
+	for i, s := range ss {
+		keep[i] = -1
+		if i > 0 && isDebugSection(s.Name) {
+			drop++
+			continue
+		}
+		if t := elf.SectionType(s.Type); (t == elf.SHT_REL || t == elf.SHT_RELA) && s.Info > 0 && int(s.Info) < len(ss) && isDebugSection(ss[s.Info].Name) {
+			drop++
+			continue
+		}
+		keep[i] = index
+		index++
+	}
+	if drop == 0 {
+		return bs, nil
+	}
+	for i, s := range ss {
+		if keep[i] >= 0 && elf.SectionType(s.Type) != elf.SHT_NOBITS && s.Offset < end && s.Offset+s.Size > end {
+			end = s.Offset + s.Size
+		}
+	}
+	ehsize := uint64(binary.Size(elf.Header32{}))
+	if is64 {
+		ehsize = uint64(binary.Size(elf.Header64{}))
+	}
+	if end < ehsize {
+		end = ehsize
+	}
+
+	var body bytes.Buffer
+	body.Write(bs[:end])
+	var xs []elfSection
+	for i, s := range ss {
+		if keep[i] < 0 {
+			continue
+		}
+		if i > 0 && elf.SectionType(s.Type) != elf.SHT_NOBITS && s.Offset >= end {
+			alignBuffer(&body, s.Addralign)
+			off := uint64(body.Len())
+			body.Write(bs[s.Offset : s.Offset+s.Size])
+			s.Offset = off
+		}
+		if s.Link > 0 && int(s.Link) < len(keep) && keep[s.Link] >= 0 {
+			s.Link = uint32(keep[s.Link])
+		}
+		if t := elf.SectionType(s.Type); (t == elf.SHT_REL || t == elf.SHT_RELA) && int(s.Info) < len(keep) && keep[s.Info] >= 0 {
+			s.Info = uint32(keep[s.Info])
+		}
+		xs = append(xs, s)
+	}
+	out := body.Bytes()
+	for i, s := range xs {
+		switch elf.SectionType(s.Type) {
+		case elf.SHT_SYMTAB, elf.SHT_DYNSYM:
+			remapSymbols(out[s.Offset:s.Offset+s.Size], s.Entsize, is64, ord, keep)
+		case elf.SHT_SYMTAB_SHNDX:
+			remapIndexes(out[s.Offset:s.Offset+s.Size], ord, keep)
+		case elf.SHT_GROUP:
+			xs[i].Size = remapGroup(out[s.Offset:s.Offset+s.Size], ord, keep)
+		}
+	}
+	if is64 {
+		alignBuffer(&body, 8)
+	} else {
+		alignBuffer(&body, 4)
+	}
+	shoff := uint64(body.Len())
+	for _, s := range xs {
+		if is64 {
+			h := elf.Section64{
+				Name:      s.NameIdx,
+				Type:      s.Type,
+				Flags:     s.Flags,
+				Addr:      s.Addr,
+				Off:       s.Offset,
+				Size:      s.Size,
+				Link:      s.Link,
+				Info:      s.Info,
+				Addralign: s.Addralign,
+				Entsize:   s.Entsize,
+			}
+			binary.Write(&body, ord, h)
+		} else {
+			h := elf.Section32{
+				Name:      s.NameIdx,
+				Type:      s.Type,
+				Flags:     uint32(s.Flags),
+				Addr:      uint32(s.Addr),
+				Off:       uint32(s.Offset),
+				Size:      uint32(s.Size),
+				Link:      s.Link,
+				Info:      s.Info,
+				Addralign: uint32(s.Addralign),
+				Entsize:   uint32(s.Entsize),
+			}
+			binary.Write(&body, ord, h)
+		}
+	}
+	out = body.Bytes()
+	if strndx >= 0 && keep[strndx] >= 0 {
+		strndx = keep[strndx]
+	} else {
+		strndx = 0
+	}
+	if is64 {
+		ord.PutUint64(out[40:], shoff)
+		ord.PutUint16(out[60:], uint16(len(xs)))
+		ord.PutUint16(out[62:], uint16(strndx))
+	} else {
+		ord.PutUint32(out[32:], uint32(shoff))
+		ord.PutUint16(out[48:], uint16(len(xs)))
+		ord.PutUint16(out[50:], uint16(strndx))
+	}
+	return out, nil
+}
+
+// remapIndex gives the new index of section x. Symbols defined in a dropped
+// section become undefined, reserved indexes are left as is.
+func remapIndex(x uint32, keep []int) uint32 {
+	if x == uint32(elf.SHN_UNDEF) || x >= uint32(elf.SHN_LORESERVE) {
+		return x
+	}
+	if int(x) < len(keep) && keep[x] >= 0 {
+		return uint32(keep[x])
+	}
+	return uint32(elf.SHN_UNDEF)
+}
+
+func remapSymbols(bs []byte, entsize uint64, is64 bool, ord binary.ByteOrder, keep []int) {
+	size, off := uint64(elf.Sym32Size), 14
+	if is64 {
+		size, off = elf.Sym64Size, 6
+	}
+	if entsize >= size {
+		size = entsize
+	}
+	for i := uint64(0); i+size <= uint64(len(bs)); i += size {
+		x := uint32(ord.Uint16(bs[i+uint64(off):]))
+		if x == uint32(elf.SHN_XINDEX) {
+			continue
+		}
+		ord.PutUint16(bs[i+uint64(off):], uint16(remapIndex(x, keep)))
+	}
+}
+
+// remapIndexes updates the extended section indexes of a SHT_SYMTAB_SHNDX
+// section.
+func remapIndexes(bs []byte, ord binary.ByteOrder, keep []int) {
+	for i := 0; i+4 <= len(bs); i += 4 {
+		if x := ord.Uint32(bs[i:]); x != 0 {
+			ord.PutUint32(bs[i:], remapIndex(x, keep))
+		}
+	}
+}
+
+// remapGroup updates the members of a SHT_GROUP section, removing the dropped
+// ones, and gives its new size.
+func remapGroup(bs []byte, ord binary.ByteOrder, keep []int) uint64 {
+	if len(bs) < 4 {
+		return uint64(len(bs))
+	}
+	n := 4
+	for i := 4; i+4 <= len(bs); i += 4 {
+		x := remapIndex(ord.Uint32(bs[i:]), keep)
+		if x == uint32(elf.SHN_UNDEF) {
+			continue
+		}
+		ord.PutUint32(bs[n:], x)
+		n += 4
+	}
+	return uint64(n)
+}
+
+func readSections(bs []byte, is64 bool, ord binary.ByteOrder) ([]elfSection, int, error) {
+	var (
+		shoff  uint64
+		shnum  int
+		strndx int
+		size   int
+	)
+	r := bytes.NewReader(bs)
+	if is64 {
+		var h elf.Header64
+		if err := binary.Read(r, ord, &h); err != nil {
+			return nil, 0, err
+		}
+		shoff, shnum, strndx, size = h.Shoff, int(h.Shnum), int(h.Shstrndx), int(h.Shentsize)
+	} else {
+		var h elf.Header32
+		if err := binary.Read(r, ord, &h); err != nil {
+			return nil, 0, err
+		}
+		shoff, shnum, strndx, size = uint64(h.Shoff), int(h.Shnum), int(h.Shstrndx), int(h.Shentsize)
+	}
+	if shnum == 0 || strndx >= shnum || shoff+uint64(shnum*size) > uint64(len(bs)) {
+		return nil, 0, fmt.Errorf("elf: unsupported section header table")
+	}
+	ss := make([]elfSection, shnum)
+	for i := range ss {
+		r := bytes.NewReader(bs[shoff+uint64(i*size):])
+		if is64 {
+			var h elf.Section64
+			if err := binary.Read(r, ord, &h); err != nil {
+				return nil, 0, err
+			}
+			ss[i] = elfSection{
+				Type:      h.Type,
+				Flags:     h.Flags,
+				Addr:      h.Addr,
+				Offset:    h.Off,
+				Size:      h.Size,
+				Link:      h.Link,
+				Info:      h.Info,
+				Addralign: h.Addralign,
+				Entsize:   h.Entsize,
+				NameIdx:   h.Name,
+			}
+		} else {
+			var h elf.Section32
+			if err := binary.Read(r, ord, &h); err != nil {
+				return nil, 0, err
+			}
+			ss[i] = elfSection{
+				Type:      h.Type,
+				Flags:     uint64(h.Flags),
+				Addr:      uint64(h.Addr),
+				Offset:    uint64(h.Off),
+				Size:      uint64(h.Size),
+				Link:      h.Link,
+				Info:      h.Info,
+				Addralign: uint64(h.Addralign),
+				Entsize:   uint64(h.Entsize),
+				NameIdx:   h.Name,
+			}
+		}
+		if t := elf.SectionType(ss[i].Type); t != elf.SHT_NOBITS && ss[i].Offset+ss[i].Size > uint64(len(bs)) {
+			return nil, 0, fmt.Errorf("elf: section %d out of range", i)
+		}
+	}
+	names := ss[strndx]
+	strtab := bs[names.Offset : names.Offset+names.Size]
+	for i := range ss {
+		if n := int(ss[i].NameIdx); n < len(strtab) {
+			s := strtab[n:]
+			if j := bytes.IndexByte(s, 0); j >= 0 {
+				s = s[:j]
+			}
+			ss[i].Name = string(s)
+		}
+	}
+	return ss, strndx, nil
+}
+
+func alignBuffer(b *bytes.Buffer, align uint64) {
+	if align <= 1 {
+		return
+	}
+	if n := uint64(b.Len()) % align; n > 0 {
+		b.Write(make([]byte, align-n))
+	}
+}
+
+func stripFile(f *File) error {
+	bs := f.Body
+	if bs == nil {
+		r, err := os.Open(f.Src)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		magic := make([]byte, len(elfMagic))
+		switch _, err := io.ReadFull(r, magic); {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			return nil
+		case err != nil:
+			return err
+		case !IsELF(magic):
+			return nil
+		}
+		if bs, err = ioutil.ReadAll(io.MultiReader(bytes.NewReader(magic), r)); err != nil {
+			return err
+		}
+	}
+	if !IsELF(bs) {
+		return nil
+	}
+	xs, err := StripDebug(bs)
+	if err != nil {
+		return fmt.Errorf("%s: %s", f.String(), err)
+	}
+	if len(xs) < len(bs) {
+		f.Body = xs
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package packit
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestStripDebug(t *testing.T) {
+	bs, err := ioutil.ReadFile("testdata/hello.debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mf := Makefile{
+		Files:      []*File{{Src: "testdata/hello.debug", Dst: "/usr/bin/hello"}, {Src: "testdata/hello.c", Dst: "/usr/share/doc/hello/"}},
+		StripDebug: true,
+	}
+	if err := mf.Expand(); err != nil {
+		t.Fatal(err)
+	}
+	if mf.Files[1].Body != nil {
+		t.Errorf("hello.c: source that is not ELF rewritten")
+	}
+	xs := mf.Files[0].Body
+	if len(xs) == 0 || len(xs) >= len(bs) {
+		t.Fatalf("stripped binary not smaller: %d bytes, was %d", len(xs), len(bs))
+	}
+	orig, err := elf.NewFile(bytes.NewReader(bs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := elf.NewFile(bytes.NewReader(xs))
+	if err != nil {
+		t.Fatalf("stripped binary is not a valid ELF: %s", err)
+	}
+	for _, s := range f.Sections {
+		if isDebugSection(s.Name) {
+			t.Errorf("%s: debug section kept", s.Name)
+		}
+	}
+	for _, n := range []string{".text", ".rodata", ".dynsym"} {
+		a, b := orig.Section(n), f.Section(n)
+		if a == nil || b == nil {
+			t.Errorf("%s: section missing", n)
+			continue
+		}
+		x, _ := a.Data()
+		y, _ := b.Data()
+		if !bytes.Equal(x, y) {
+			t.Errorf("%s: content changed by strip", n)
+		}
+	}
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		return
+	}
+	file := filepath.Join(t.TempDir(), "hello")
+	if err := ioutil.WriteFile(file, xs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(file, "packit").Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			t.Fatalf("stripped binary does not run: %s", err)
+		}
+		t.Skipf("stripped binary can not be run: %s", err)
+	}
+	if string(out) != "hello packit\n" {
+		t.Errorf("stripped binary: unexpected output %q", out)
+	}
+}
+
+// patchSection sets the type, offset, size, link and entry size of the
+// section i of the 64 bits little endian ELF found in bs.
+func patchSection(bs []byte, i int, typ elf.SectionType, off, size uint64, link uint32, entsize uint64) {
+	h := bs[binary.LittleEndian.Uint64(bs[40:])+uint64(i*64):]
+	binary.LittleEndian.PutUint32(h[4:], uint32(typ))
+	binary.LittleEndian.PutUint64(h[8:], 0)
+	binary.LittleEndian.PutUint64(h[24:], off)
+	binary.LittleEndian.PutUint64(h[32:], size)
+	binary.LittleEndian.PutUint32(h[40:], link)
+	binary.LittleEndian.PutUint64(h[56:], entsize)
+}
+
+func TestStripDebugIndexes(t *testing.T) {
+	bs, err := ioutil.ReadFile("testdata/hello.debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, err := elf.NewFile(bytes.NewReader(bs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := func(f *elf.File, n string) uint32 {
+		for i, s := range f.Sections {
+			if s.Name == n {
+				return uint32(i)
+			}
+		}
+		t.Fatalf("%s: section not found", n)
+		return 0
+	}
+	var (
+		symtab  = orig.Section(".symtab")
+		comment = orig.Section(".comment")
+		strtab  = index(orig, ".strtab")
+		info    = index(orig, ".debug_info")
+		names   = index(orig, ".shstrtab")
+	)
+	// the first symbols now refer to a section kept and to a section dropped,
+	// and .comment becomes a group of both of them and of .shstrtab.
+	for i, x := range []uint32{strtab, info} {
+		binary.LittleEndian.PutUint16(bs[symtab.Offset+uint64(i+1)*elf.Sym64Size+6:], uint16(x))
+	}
+	patchSection(bs, int(index(orig, ".comment")), elf.SHT_GROUP, comment.Offset, 16, index(orig, ".symtab"), 4)
+	for i, x := range []uint32{1, strtab, info, names} {
+		binary.LittleEndian.PutUint32(bs[comment.Offset+uint64(i*4):], x)
+	}
+
+	xs, err := StripDebug(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := elf.NewFile(bytes.NewReader(xs))
+	if err != nil {
+		t.Fatalf("stripped binary is not a valid ELF: %s", err)
+	}
+	ss, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := ss[0].Section; int(x) >= len(f.Sections) || f.Sections[x].Name != ".strtab" {
+		t.Errorf("symbol of .strtab: want section %d, got %d", index(f, ".strtab"), x)
+	}
+	if x := ss[1].Section; x != elf.SHN_UNDEF {
+		t.Errorf("symbol of .debug_info: want undefined, got section %d", x)
+	}
+	was, err := orig.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 2; i < len(ss); i++ {
+		x, y := was[i].Section, ss[i].Section
+		if x >= elf.SHN_LORESERVE || x == elf.SHN_UNDEF {
+			if x != y {
+				t.Errorf("%s: reserved index %d changed to %d", ss[i].Name, x, y)
+			}
+			continue
+		}
+		if int(y) >= len(f.Sections) || f.Sections[y].Name != orig.Sections[x].Name {
+			t.Errorf("%s: want section %s, got index %d", ss[i].Name, orig.Sections[x].Name, y)
+		}
+	}
+	var group *elf.Section
+	for _, s := range f.Sections {
+		if s.Type == elf.SHT_GROUP {
+			group = s
+		}
+	}
+	if group == nil {
+		t.Fatal("group section dropped")
+	}
+	members, _ := group.Data()
+	want := []uint32{1, index(f, ".strtab"), index(f, ".shstrtab")}
+	if len(members) != len(want)*4 {
+		t.Fatalf("group: want %d words, got %d bytes", len(want), len(members))
+	}
+	for i, x := range want {
+		if y := binary.LittleEndian.Uint32(members[i*4:]); y != x {
+			t.Errorf("group word %d: want %d, got %d", i, x, y)
+		}
+	}
+}
+
+func TestStripDebugStraddle(t *testing.T) {
+	bs, err := ioutil.ReadFile("testdata/hello.debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, err := elf.NewFile(bytes.NewReader(bs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var end uint64
+	for _, p := range orig.Progs {
+		if e := p.Off + p.Filesz; e > end {
+			end = e
+		}
+	}
+	// move .comment back so that it starts in the last segment and ends
+	// after it.
+	var (
+		comment = orig.Section(".comment")
+		off     = end - 8
+	)
+	for i, s := range orig.Sections {
+		if s == comment {
+			patchSection(bs, i, elf.SHT_PROGBITS, off, comment.Size+8, 0, 1)
+		}
+	}
+	want := append([]byte{}, bs[off:off+comment.Size+8]...)
+	xs, err := StripDebug(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := elf.NewFile(bytes.NewReader(xs))
+	if err != nil {
+		t.Fatalf("stripped binary is not a valid ELF: %s", err)
+	}
+	got, err := f.Section(".comment").Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf(".comment: want %q, got %q", want, got)
+	}
+}
+
+func TestStripFileNotELF(t *testing.T) {
+	for _, body := range []string{"", "#!", "#!/bin/sh\necho hello\n"} {
+		file := filepath.Join(t.TempDir(), "script")
+		if err := ioutil.WriteFile(file, []byte(body), 0755); err != nil {
+			t.Fatal(err)
+		}
+		f := File{Src: file}
+		if err := stripFile(&f); err != nil || f.Body != nil {
+			t.Errorf("%q: want file left as is, got %v (%d bytes)", body, err, len(f.Body))
+		}
+	}
+	if err := stripFile(&File{Src: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Errorf("missing source: expected error")
+	}
+}
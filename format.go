@@ -0,0 +1,142 @@
+package packit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type Format struct {
+	Name        string
+	Ext         string
+	Magic       []byte
+	Read        bool
+	Write       bool
+	Signing     bool
+	Compression []string
+	Lossy       []string
+	Weak        []string
+	Fields      map[string]string
+}
+
+type BuildFunc func(*Makefile) (Builder, error)
+
+type OpenFunc func(string) (Package, error)
+
+var (
+	formats  = make(map[string]Format)
+	builders = make(map[string]BuildFunc)
+	readers  = make(map[string]OpenFunc)
+)
+
+func RegisterFormat(f Format) {
+	if _, ok := formats[f.Name]; ok {
+		panic(fmt.Sprintf("format %s already registered", f.Name))
+	}
+	formats[f.Name] = f
+}
+
+func RegisterBuilder(name string, fn BuildFunc) {
+	if _, ok := builders[name]; ok {
+		panic(fmt.Sprintf("builder %s already registered", name))
+	}
+	builders[name] = fn
+}
+
+func HasBuilder(name string) bool {
+	_, ok := builders[name]
+	return ok
+}
+
+func NewBuilder(name string, mf *Makefile) (Builder, error) {
+	fn, ok := builders[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported package type %s", name)
+	}
+	return fn(mf)
+}
+
+func RegisterReader(name string, fn OpenFunc) {
+	if _, ok := readers[name]; ok {
+		panic(fmt.Sprintf("reader %s already registered", name))
+	}
+	readers[name] = fn
+}
+
+func Open(file string) (Package, error) {
+	name, err := sniff(file)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := readers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported packet type %s", name)
+	}
+	return fn(file)
+}
+
+func sniff(file string) (string, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	magic := make([]byte, 8)
+	size, err := io.ReadFull(r, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	magic = magic[:size]
+	for n, f := range formats {
+		if len(f.Magic) > 0 && bytes.HasPrefix(magic, f.Magic) {
+			return n, nil
+		}
+	}
+	ext := filepath.Ext(file)
+	for n, f := range formats {
+		if f.Ext == ext {
+			return n, nil
+		}
+	}
+	if n := strings.TrimPrefix(ext, "."); readers[n] != nil {
+		return n, nil
+	}
+	return "", fmt.Errorf("unsupported packet type %s", ext)
+}
+
+func FormatByExt(ext string) (Format, bool) {
+	for _, f := range formats {
+		if f.Ext == ext {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+func Formats() []Format {
+	fs := make([]Format, 0, len(formats))
+	for n, f := range formats {
+		f.Read = readers[n] != nil
+		f.Write = HasBuilder(n)
+		fs = append(fs, f)
+	}
+	names := make(map[string]struct{})
+	for n := range builders {
+		names[n] = struct{}{}
+	}
+	for n := range readers {
+		names[n] = struct{}{}
+	}
+	for n := range names {
+		if _, ok := formats[n]; !ok {
+			fs = append(fs, Format{Name: n, Read: readers[n] != nil, Write: HasBuilder(n)})
+		}
+	}
+	sort.Slice(fs, func(i, j int) bool { return fs[i].Name < fs[j].Name })
+	return fs
+}
@@ -0,0 +1,66 @@
+package packit
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+type fakePackage struct {
+	Package
+	file string
+}
+
+func (f fakePackage) PackageType() string { return "fake" }
+
+func init() {
+	RegisterFormat(Format{Name: "fake", Ext: ".fake", Magic: []byte("!fake\n")})
+	RegisterReader("fake", func(file string) (Package, error) {
+		return fakePackage{file: file}, nil
+	})
+}
+
+func TestOpenRegisteredReader(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []struct {
+		File    string
+		Content string
+		Fake    bool
+	}{
+		{File: "magic.bin", Content: "!fake\npayload", Fake: true},
+		{File: "empty.fake", Fake: true},
+		{File: "other.bin", Content: "payload"},
+	} {
+		file := filepath.Join(dir, d.File)
+		if err := ioutil.WriteFile(file, []byte(d.Content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		p, err := Open(file)
+		if !d.Fake {
+			if err == nil {
+				t.Errorf("%s: expected unsupported packet type", d.File)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", d.File, err)
+			continue
+		}
+		if f, ok := p.(fakePackage); !ok || f.file != file {
+			t.Errorf("%s: not opened by the fake reader (%T)", d.File, p)
+		}
+	}
+	var found bool
+	for _, f := range Formats() {
+		if f.Name != "fake" {
+			continue
+		}
+		found = true
+		if !f.Read || f.Write {
+			t.Errorf("fake: want read only format, got read=%t write=%t", f.Read, f.Write)
+		}
+	}
+	if !found {
+		t.Errorf("fake: format not listed")
+	}
+}
@@ -1,16 +1,26 @@
 package packit
 
 import (
+	"archive/tar"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
+
+	"github.com/midbel/toml"
+	"golang.org/x/crypto/openpgp"
 )
 
 var (
@@ -21,8 +31,17 @@ var (
 var ErrSkip = errors.New("skip")
 
 const (
-	ExtGZ = ".gz"
-	ExtXZ = ".xz"
+	ExtGZ   = ".gz"
+	ExtXZ   = ".xz"
+	ExtZstd = ".zst"
+)
+
+const (
+	FileFifo  = "fifo"
+	FileChar  = "char"
+	FileBlock = "block"
+	FileLink  = "link"
+	FileDir   = "dir"
 )
 
 const (
@@ -37,6 +56,7 @@ const (
 	DefaultHost    = "localhost.localdomain"
 	DefaultUser    = "root"
 	DefaultGroup   = "root"
+	DefaultShell   = "/bin/sh"
 )
 
 const (
@@ -62,12 +82,27 @@ type Package interface {
 	Filenames() ([]string, error)
 	Resources() ([]Resource, error)
 	Valid() error
-	Extract(string, bool) error
+	Verify() ([]FileCheck, error)
+	Extract(string, ExtractOptions) error
+}
+
+type FileCheck struct {
+	Name     string
+	Expected string
+	Actual   string
+	Match    bool
+}
+
+type ExtractOptions struct {
+	Preserve bool
+	Skip     bool
+	Jobs     int
 }
 
 type Builder interface {
 	PackageName() string
 	Build(w io.Writer) error
+	BuildContext(ctx context.Context, w io.Writer) error
 }
 
 type Makefile struct {
@@ -79,6 +114,269 @@ type Makefile struct {
 	Postinst *Script `toml:"post-install"`
 	Prerm    *Script `toml:"pre-remove"`
 	Postrm   *Script `toml:"post-remove"`
+
+	Compression string `toml:"compression"`
+	XZCheck     string `toml:"xz-check"`
+	Threads     int    `toml:"compress-threads"`
+	FromTar     string `toml:"from-tar"`
+	NoMD5       bool   `toml:"no-md5"`
+	WeakDeps    bool   `toml:"weak-deps"`
+
+	Strict         bool   `toml:"strict"`
+	FollowSymlinks bool   `toml:"follow-symlinks"`
+	StripDebug     bool   `toml:"strip-debug"`
+	CompressMan    bool   `toml:"compress-man"`
+	DigestSources  bool   `toml:"source-digest"`
+	Path           string `toml:"-"`
+
+	// Owner and Group are given to the files without owner or group once the
+	// makefile is expanded.
+	Owner string `toml:"-"`
+	Group string `toml:"-"`
+
+	SignKey string          `toml:"sign-key"`
+	Signer  *openpgp.Entity `toml:"-"`
+}
+
+// Load decodes the makefile at the given path. Files without a mode get the
+// permissions of their source and the package name and version are required.
+func Load(file string) (*Makefile, error) {
+	var mf Makefile
+	if err := toml.DecodeFile(file, &mf); err != nil {
+		return nil, err
+	}
+	mf.Path = file
+	switch {
+	case mf.Control == nil:
+		return nil, fmt.Errorf("%s: metadata table missing", file)
+	case mf.Package == "":
+		return nil, fmt.Errorf("%s: package name missing", file)
+	case mf.Version == "":
+		return nil, fmt.Errorf("%s: package version missing", file)
+	}
+	for _, f := range mf.Files {
+		if f.Special() {
+			continue
+		}
+		if f.Src == "" {
+			return nil, fmt.Errorf("%s: source missing for %s", file, f.String())
+		}
+		if f.Perm != 0 {
+			continue
+		}
+		if i, err := os.Stat(f.Src); err == nil && i.Mode().IsRegular() {
+			f.Perm = int(i.Mode().Perm())
+		}
+	}
+	return &mf, nil
+}
+
+func (mf *Makefile) Expand() error {
+	var fs []*File
+	for _, f := range mf.Files {
+		if f.Type != "" && !f.Special() {
+			return fmt.Errorf("%s: unsupported file type %s", f.String(), f.Type)
+		}
+		i, err := os.Stat(f.Src)
+		if err != nil || !i.IsDir() {
+			fs = append(fs, f)
+			continue
+		}
+		xs, err := expandDir(f, mf.FollowSymlinks)
+		if err != nil {
+			return err
+		}
+		fs = append(fs, xs...)
+	}
+	if mf.FromTar != "" {
+		xs, err := expandTar(mf.FromTar)
+		if err != nil {
+			return err
+		}
+		// the archive records the owner of whoever created it: the default
+		// owner takes precedence over it.
+		for _, f := range xs {
+			if mf.Owner != "" {
+				f.User, f.Uid = mf.Owner, 0
+			}
+			if mf.Group != "" {
+				f.Group, f.Gid = mf.Group, 0
+			}
+		}
+		fs = append(fs, xs...)
+		mf.FromTar = ""
+	}
+	for _, f := range fs {
+		if f.User == "" {
+			f.User = mf.Owner
+		}
+		if f.Group == "" {
+			f.Group = mf.Group
+		}
+	}
+	if mf.CompressMan {
+		for _, f := range fs {
+			compressMan(f)
+		}
+	}
+	if mf.StripDebug {
+		for _, f := range fs {
+			if f.Special() {
+				continue
+			}
+			if err := stripFile(f); err != nil {
+				return err
+			}
+		}
+	}
+	mf.Files = fs
+	return nil
+}
+
+const manDir = "/usr/share/man/"
+
+func compressMan(f *File) {
+	n := "/" + strings.TrimPrefix(f.String(), "/")
+	if f.Special() || f.Compress || !strings.HasPrefix(n, manDir) || Incompressible(n) {
+		return
+	}
+	f.Compress, f.Name = true, f.Filename()+".gz"
+}
+
+func expandTar(file string) ([]*File, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var (
+		fs []*File
+		t  = tar.NewReader(r)
+	)
+	for {
+		h, err := t.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA {
+			continue
+		}
+		bs, err := ioutil.ReadAll(t)
+		if err != nil {
+			return nil, err
+		}
+		n := "/" + strings.TrimPrefix(filepath.Clean("/"+h.Name), "/")
+		f := File{
+			Dst:     n,
+			Name:    filepath.Base(n),
+			Perm:    int(h.Mode & 07777),
+			Conf:    IsConfFile(n),
+			User:    h.Uname,
+			Group:   h.Gname,
+			Uid:     h.Uid,
+			Gid:     h.Gid,
+			Body:    bs,
+			ModTime: h.ModTime,
+		}
+		fs = append(fs, &f)
+	}
+	return fs, nil
+}
+
+func expandDir(f *File, follow bool) ([]*File, error) {
+	var (
+		fs   []*File
+		seen = make(map[string]struct{})
+		walk func(string, string) error
+	)
+	walk = func(src, dst string) error {
+		real, err := filepath.EvalSymlinks(src)
+		if err != nil {
+			return err
+		}
+		if _, ok := seen[real]; ok {
+			return fmt.Errorf("%s: symlink cycle detected", src)
+		}
+		seen[real] = struct{}{}
+		defer delete(seen, real)
+
+		es, err := ioutil.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, e := range es {
+			s, d := filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())
+			mode := e.Mode()
+			if mode&os.ModeSymlink != 0 {
+				i, err := os.Stat(s)
+				if err != nil {
+					return err
+				}
+				if mode = i.Mode(); mode.IsDir() && !follow {
+					continue
+				}
+			}
+			switch {
+			case mode.IsDir():
+				if err := walk(s, d); err != nil {
+					return err
+				}
+			case mode.IsRegular():
+				x := *f
+				x.Src, x.Dst, x.Name = s, d, e.Name()
+				if x.Perm == 0 {
+					x.Perm = int(mode.Perm())
+				}
+				fs = append(fs, &x)
+			}
+		}
+		return nil
+	}
+	dst := f.Dst
+	if dst == "" {
+		dst = filepath.Base(f.Src)
+	}
+	return fs, walk(f.Src, dst)
+}
+
+func (mf *Makefile) Digest() (string, error) {
+	h := sha256.New()
+	if mf.Path != "" {
+		if err := copyFile(h, mf.Path); err != nil {
+			return "", err
+		}
+	}
+	fs := make([]*File, len(mf.Files))
+	copy(fs, mf.Files)
+	sort.Slice(fs, func(i, j int) bool { return fs[i].String() < fs[j].String() })
+	for _, f := range fs {
+		io.WriteString(h, f.String()+"\x00"+f.Link)
+		if f.Special() {
+			continue
+		}
+		if f.Body != nil {
+			h.Write(f.Body)
+			continue
+		}
+		if err := copyFile(h, f.Src); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyFile(w io.Writer, file string) error {
+	r, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
 }
 
 func ArchString(a uint8) string {
@@ -203,8 +501,37 @@ func (c *code) Set(s string) error {
 	return nil
 }
 
+const (
+	ScriptPreinst  = "preinst"
+	ScriptPostinst = "postinst"
+	ScriptPrerm    = "prerm"
+	ScriptPostrm   = "postrm"
+)
+
+type Scripted interface {
+	Scripts() map[string]*Script
+}
+
 type Script struct {
-	Text code `toml:"script"`
+	Text code   `toml:"script"`
+	Prog string `toml:"program"`
+}
+
+func NewScript(prog, text string) *Script {
+	return &Script{Text: code(text), Prog: prog}
+}
+
+func (s *Script) Program() string {
+	if s.Prog != "" {
+		return s.Prog
+	}
+	if t := s.Text.String(); strings.HasPrefix(t, "#!") {
+		line := strings.SplitN(t[2:], "\n", 2)[0]
+		if fs := strings.Fields(line); len(fs) > 0 {
+			return fs[0]
+		}
+	}
+	return DefaultShell
 }
 
 func (s *Script) String() string {
@@ -220,44 +547,174 @@ func (s *Script) Valid() bool {
 
 type Control struct {
 	Package     string `toml:"package"`
+	Epoch       int    `toml:"epoch"`
 	Version     string `toml:"version"`
 	Release     string `toml:"release"`
+	Dist        string `toml:"dist"`
 	Summary     string `toml:"summary"`
 	Desc        string `toml:"description"`
+	DescMD5     bool   `toml:"description-md5"`
 	License     string `toml:"license"`
 	Section     string `toml:"section"`
 	Priority    string `toml:"priority"`
+	Essential   bool   `toml:"essential"`
+	Important   bool   `toml:"important"`
 	Os          string `toml:"os"`
 	Arch        uint8  `toml:"arch"`
 	Vendor      string `toml:"vendor"`
 	Home        string `toml:"homepage"`
+	Origin      string `toml:"origin"`
+	Bugs        string `toml:"bugs"`
 	*Maintainer `toml:"maintainer"`
 
-	Depends   []string `toml:"depends"`
-	Suggests  []string `toml:"suggests"`
-	Provides  []string `toml:"provides"`
-	Breaks    []string `toml:"breaks"`
-	Conflicts []string `toml:"conflicts"`
-	Replaces  []string `toml:"replaces"`
+	Depends     []string `toml:"depends"`
+	Suggests    []string `toml:"suggests"`
+	Enhances    []string `toml:"enhances"`
+	Supplements []string `toml:"supplements"`
+	Provides    []string `toml:"provides"`
+	Breaks      []string `toml:"breaks"`
+	Conflicts   []string `toml:"conflicts"`
+	Replaces    []string `toml:"replaces"`
+	Obsoletes   []string `toml:"obsoletes"`
 
 	Compiler string `toml:"compiler"`
 
-	Format string    `toml:"-"`
-	Status string    `toml:"-"`
-	Source string    `toml:"-"`
-	Date   time.Time `toml:"-"`
-	Size   int64     `toml:"-"`
+	Format    string    `toml:"-"`
+	Status    string    `toml:"-"`
+	Source    string    `toml:"-"`
+	Date      time.Time `toml:"-"`
+	Size      int64     `toml:"-"`
+	ConfFiles []string  `toml:"-"`
+
+	SourceDigest string `toml:"-"`
 }
 
 func (c Control) PackageName() string {
 	return fmt.Sprintf("%s-%s", c.Package, c.Version)
 }
 
+func (c Control) FileName(format, arch string) string {
+	v := c.Version
+	if r := c.ReleaseFor(format); r != "" {
+		v += "-" + r
+	}
+	switch format {
+	case "rpm":
+		return fmt.Sprintf("%s-%s.%s.rpm", c.Package, v, arch)
+	case "deb":
+		return fmt.Sprintf("%s_%s_%s.deb", c.Package, v, arch)
+	default:
+		return fmt.Sprintf("%s-%s.%s", c.Package, v, format)
+	}
+}
+
+func (c Control) Validate() error {
+	if strings.ContainsAny(c.Summary, "\r\n") {
+		return fmt.Errorf("summary: multi-line value not allowed")
+	}
+	fs := []struct {
+		Name  string
+		Value string
+		Multi bool
+	}{
+		{Name: "package", Value: c.Package},
+		{Name: "version", Value: c.Version},
+		{Name: "release", Value: c.Release},
+		{Name: "summary", Value: c.Summary},
+		{Name: "description", Value: c.Desc, Multi: true},
+		{Name: "license", Value: c.License},
+		{Name: "section", Value: c.Section},
+		{Name: "priority", Value: c.Priority},
+		{Name: "os", Value: c.Os},
+		{Name: "vendor", Value: c.Vendor},
+		{Name: "homepage", Value: c.Home},
+		{Name: "origin", Value: c.Origin},
+		{Name: "bugs", Value: c.Bugs},
+	}
+	for _, f := range fs {
+		if i := strings.IndexFunc(f.Value, func(r rune) bool {
+			if f.Multi && (r == '\n' || r == '\t') {
+				return false
+			}
+			return unicode.IsControl(r)
+		}); i >= 0 {
+			return fmt.Errorf("%s: invalid control character %q", f.Name, f.Value[i])
+		}
+	}
+	return nil
+}
+
+func (c Control) EVR(format string) string {
+	var str strings.Builder
+	if c.Epoch > 0 {
+		str.WriteString(strconv.Itoa(c.Epoch) + ":")
+	}
+	str.WriteString(c.Version)
+	if r := c.ReleaseFor(format); r != "" {
+		str.WriteString("-" + r)
+	}
+	return str.String()
+}
+
+// ReleaseFor gives the release as written in packages of the given format:
+// rpm releases get the dist tag appended.
+func (c Control) ReleaseFor(format string) string {
+	if format != "rpm" || c.Release == "" || c.Dist == "" {
+		return c.Release
+	}
+	return c.Release + "." + strings.TrimPrefix(c.Dist, ".")
+}
+
+func SplitEVR(v string) (int, string, string) {
+	var epoch int
+	if ix := strings.Index(v, ":"); ix > 0 {
+		if e, err := strconv.Atoi(v[:ix]); err == nil {
+			epoch, v = e, v[ix+1:]
+		}
+	}
+	var release string
+	if ix := strings.LastIndex(v, "-"); ix > 0 {
+		v, release = v[:ix], v[ix+1:]
+	}
+	return epoch, v, release
+}
+
+// Resource describes an entry of the payload of a package. Perm carries the
+// type bits of the entry: Link is only set for symlinks and Major and Minor
+// for devices.
 type Resource struct {
 	Name    string
 	Size    int64
 	Perm    int64
 	ModTime time.Time
+	Uid     int
+	Gid     int
+	User    string
+	Group   string
+	Link    string
+	Major   int
+	Minor   int
+}
+
+// Payload gives the number of files installed by p and their total size.
+// Links are counted as files but directories are not.
+func Payload(p Package) (int, int64, error) {
+	rs, err := p.Resources()
+	if err != nil {
+		return 0, 0, err
+	}
+	var (
+		n    int
+		size int64
+	)
+	for _, r := range rs {
+		if r.Perm&0170000 == 0040000 {
+			continue
+		}
+		n++
+		size += r.Size
+	}
+	return n, size, nil
 }
 
 type File struct {
@@ -273,10 +730,195 @@ type File struct {
 	Readme  bool   `toml:"readme"`
 	Lang    string `toml:"lang"`
 
+	Type  string `toml:"type"`
+	Link  string `toml:"link"`
+	Major int    `toml:"major"`
+	Minor int    `toml:"minor"`
+
+	User  string `toml:"user"`
+	Group string `toml:"group"`
+	Uid   int    `toml:"uid"`
+	Gid   int    `toml:"gid"`
+
+	Body    []byte    `toml:"-"`
+	ModTime time.Time `toml:"-"`
+
 	Sum  string `toml:"-"`
 	Size int64  `toml:"-"`
 }
 
+func (f *File) Open() (io.ReadCloser, int64, error) {
+	if f.Body != nil {
+		return ioutil.NopCloser(bytes.NewReader(f.Body)), int64(len(f.Body)), nil
+	}
+	r, err := os.Open(f.Src)
+	if err != nil {
+		return nil, 0, err
+	}
+	s, err := r.Stat()
+	if err != nil {
+		r.Close()
+		return nil, 0, err
+	}
+	return r, s.Size(), nil
+}
+
+func (f *File) Copy(w io.Writer, r io.Reader, h hash.Hash) error {
+	h.Reset()
+	n, err := io.Copy(io.MultiWriter(w, h), r)
+	if err != nil {
+		return err
+	}
+	f.Size, f.Sum = n, hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+func (f File) Time(when time.Time) time.Time {
+	if f.ModTime.IsZero() {
+		return when
+	}
+	return f.ModTime
+}
+
+func (f File) Owner() (string, string) {
+	u, g := f.User, f.Group
+	if u == "" {
+		u = DefaultUser
+	}
+	if g == "" {
+		g = DefaultGroup
+	}
+	return u, g
+}
+
+// Special reports whether f is an entry without content read from a source:
+// a device, a fifo, a symlink or a directory.
+func (f File) Special() bool {
+	switch f.Type {
+	case FileFifo, FileChar, FileBlock, FileLink, FileDir:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f File) TypeMode() int64 {
+	switch f.Type {
+	case FileFifo:
+		return 0010000
+	case FileChar:
+		return 0020000
+	case FileBlock:
+		return 0060000
+	case FileLink:
+		return 0120000
+	case FileDir:
+		return 0040000
+	default:
+		return 0100000
+	}
+}
+
+func (f File) Rdev() int64 {
+	if f.Type != FileChar && f.Type != FileBlock {
+		return 0
+	}
+	return int64(f.Major<<8 | f.Minor&0xFF)
+}
+
+// ExtractPath gives the location of name under dir. Absolute names are kept
+// under dir and names escaping it, lexically or through a symlink already
+// extracted, are rejected.
+func ExtractPath(dir, name string) (string, error) {
+	p := filepath.Join(dir, name)
+	rel, err := filepath.Rel(dir, p)
+	if err != nil || !within(rel) {
+		return "", fmt.Errorf("%s: path outside of %s", name, dir)
+	}
+	if err := checkLinks(dir, filepath.Dir(rel)); err != nil {
+		return "", fmt.Errorf("%s: %s", name, err)
+	}
+	return p, nil
+}
+
+func within(rel string) bool {
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func checkLinks(dir, rel string) error {
+	if rel == "." {
+		return nil
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+	p := dir
+	for _, e := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, e)
+		i, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if i.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		real, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(root, real); err != nil || !within(rel) {
+			return fmt.Errorf("symlink %s leads outside of %s", p, dir)
+		}
+	}
+	return nil
+}
+
+// Unlink removes name when it is a symlink so that a file extracted in its
+// place does not overwrite the target of the link.
+func Unlink(name string) error {
+	i, err := os.Lstat(name)
+	if err != nil || i.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+	return os.Remove(name)
+}
+
+// ExtractLink creates name as a symlink to target. Any previous entry that is
+// not a directory is replaced.
+func ExtractLink(name, target string) error {
+	if i, err := os.Lstat(name); err == nil && !i.IsDir() {
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	}
+	return os.Symlink(target, name)
+}
+
+// CanChown reports whether the ownership recorded in a package can be applied
+// to the files extracted from it.
+func CanChown() bool {
+	return os.Geteuid() == 0
+}
+
+func SameDigest(file, sum string, h hash.Hash) bool {
+	r, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+	if _, err := io.Copy(h, r); err != nil {
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) == sum
+}
+
 func LocalFile(p string) (*File, error) {
 	i, err := os.Stat(p)
 	if err != nil {
@@ -301,13 +943,23 @@ func (f File) String() string {
 }
 
 func (f File) Mode() int64 {
-	if f.Perm == 0 {
+	if f.Perm != 0 {
+		return int64(f.Perm)
+	}
+	switch f.Type {
+	case FileLink:
+		return 0777
+	case FileDir:
+		return 0755
+	default:
 		return 0644
 	}
-	return int64(f.Perm)
 }
 
 func (f File) Filename() string {
+	if f.Name == "" && f.Src == "" {
+		return filepath.Base(f.Dst)
+	}
 	if f.Name == "" {
 		return filepath.Base(f.Src)
 	}
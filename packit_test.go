@@ -0,0 +1,269 @@
+package packit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReleaseFor(t *testing.T) {
+	c := Control{Package: "tool", Version: "2.4.1", Release: "3", Dist: "el9", Epoch: 1}
+	data := []struct {
+		Format  string
+		Release string
+		EVR     string
+		File    string
+	}{
+		{Format: "rpm", Release: "3.el9", EVR: "1:2.4.1-3.el9", File: "tool-2.4.1-3.el9.x86_64.rpm"},
+		{Format: "deb", Release: "3", EVR: "1:2.4.1-3", File: "tool_2.4.1-3_x86_64.deb"},
+	}
+	for _, d := range data {
+		if got := c.ReleaseFor(d.Format); got != d.Release {
+			t.Errorf("%s: want release %s, got %s", d.Format, d.Release, got)
+		}
+		if got := c.EVR(d.Format); got != d.EVR {
+			t.Errorf("%s: want evr %s, got %s", d.Format, d.EVR, got)
+		}
+		if got := c.FileName(d.Format, "x86_64"); got != d.File {
+			t.Errorf("%s: want file %s, got %s", d.Format, d.File, got)
+		}
+	}
+	c.Dist = ""
+	if got := c.ReleaseFor("rpm"); got != "3" {
+		t.Errorf("release without dist: want 3, got %s", got)
+	}
+	c.Dist = ".el9"
+	if got := c.ReleaseFor("rpm"); got != "3.el9" {
+		t.Errorf("release with dotted dist: want 3.el9, got %s", got)
+	}
+}
+
+func TestEVR(t *testing.T) {
+	data := []struct {
+		Control Control
+		Deb     string
+		Rpm     string
+	}{
+		{Control: Control{Version: "2.4.1", Release: "3"}, Deb: "2.4.1-3", Rpm: "2.4.1-3"},
+		{Control: Control{Version: "2.4.1", Release: "3", Epoch: 2}, Deb: "2:2.4.1-3", Rpm: "2:2.4.1-3"},
+		{Control: Control{Version: "2.4.1"}, Deb: "2.4.1", Rpm: "2.4.1"},
+		{Control: Control{Version: "2.4.1", Epoch: 1}, Deb: "1:2.4.1", Rpm: "1:2.4.1"},
+		{Control: Control{Version: "2.4.1~rc1", Release: "0ubuntu1", Dist: "fc38"}, Deb: "2.4.1~rc1-0ubuntu1", Rpm: "2.4.1~rc1-0ubuntu1.fc38"},
+	}
+	for _, d := range data {
+		if got := d.Control.EVR("deb"); got != d.Deb {
+			t.Errorf("deb: want %s, got %s", d.Deb, got)
+		}
+		if got := d.Control.EVR("rpm"); got != d.Rpm {
+			t.Errorf("rpm: want %s, got %s", d.Rpm, got)
+		}
+		e, v, r := SplitEVR(d.Deb)
+		if e != d.Control.Epoch || v != d.Control.Version || r != d.Control.Release {
+			t.Errorf("split %s: got %d %s %s", d.Deb, e, v, r)
+		}
+	}
+	if e, v, r := SplitEVR("1:1.2-beta-4"); e != 1 || v != "1.2-beta" || r != "4" {
+		t.Errorf("split 1:1.2-beta-4: got %d %s %s", e, v, r)
+	}
+}
+
+func TestControlValidate(t *testing.T) {
+	base := Control{
+		Package: "tool",
+		Version: "2.4.1",
+		Summary: "do things",
+		Desc:    "tool does things.\n\nIt does them well:\n\t- fast\n\t- quietly",
+	}
+	if err := base.Validate(); err != nil {
+		t.Fatalf("valid control: %s", err)
+	}
+	data := []struct {
+		Field string
+		Set   func(*Control)
+	}{
+		{Field: "summary", Set: func(c *Control) { c.Summary = "do things\nand more" }},
+		{Field: "summary", Set: func(c *Control) { c.Summary = "do things\r" }},
+		{Field: "summary", Set: func(c *Control) { c.Summary = "do\x00things" }},
+		{Field: "version", Set: func(c *Control) { c.Version = "2.4.1\x00" }},
+		{Field: "description", Set: func(c *Control) { c.Desc = "tool does\x1b[1m things" }},
+		{Field: "homepage", Set: func(c *Control) { c.Home = "https://example.org/\ttool" }},
+	}
+	for _, d := range data {
+		c := base
+		d.Set(&c)
+		err := c.Validate()
+		if err == nil {
+			t.Errorf("%s: expected error", d.Field)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), d.Field+":") {
+			t.Errorf("%s: error reported for wrong field: %s", d.Field, err)
+		}
+	}
+}
+
+func TestExpandSymlinks(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		share = filepath.Join(dir, "share")
+		extra = filepath.Join(dir, "extra")
+	)
+	for _, d := range []string{filepath.Join(share, "doc"), extra} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for f, body := range map[string]string{
+		filepath.Join(share, "doc", "README"): "readme\n",
+		filepath.Join(extra, "NEWS"):          "news\n",
+	} {
+		if err := ioutil.WriteFile(f, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		filepath.Join(share, "doc", "README.txt"): "README",
+		filepath.Join(share, "extra"):             extra,
+	}
+	for n, o := range links {
+		if err := os.Symlink(o, n); err != nil {
+			t.Skipf("symlinks not supported: %s", err)
+		}
+	}
+	expand := func(follow bool) ([]string, error) {
+		mf := Makefile{
+			Files:          []*File{{Src: share, Dst: "/usr/share/tool"}},
+			FollowSymlinks: follow,
+		}
+		if err := mf.Expand(); err != nil {
+			return nil, err
+		}
+		var vs []string
+		for _, f := range mf.Files {
+			vs = append(vs, f.String())
+		}
+		sort.Strings(vs)
+		return vs, nil
+	}
+	for _, d := range []struct {
+		Follow bool
+		Want   []string
+	}{
+		{Want: []string{"/usr/share/tool/doc/README", "/usr/share/tool/doc/README.txt"}},
+		{Follow: true, Want: []string{"/usr/share/tool/doc/README", "/usr/share/tool/doc/README.txt", "/usr/share/tool/extra/NEWS"}},
+	} {
+		got, err := expand(d.Follow)
+		if err != nil {
+			t.Fatalf("follow=%t: %s", d.Follow, err)
+		}
+		if strings.Join(got, " ") != strings.Join(d.Want, " ") {
+			t.Errorf("follow=%t: want %q, got %q", d.Follow, d.Want, got)
+		}
+	}
+
+	if err := os.Symlink("..", filepath.Join(share, "doc", "loop")); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := expand(false)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("loop not followed: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("loop not followed: expansion did not return")
+	}
+	if _, err := expand(true); err == nil || !strings.Contains(err.Error(), "symlink cycle") {
+		t.Errorf("loop followed: want cycle error, got %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	mf, err := Load("testdata/load/tool.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := mf.Control
+	if c.Package != "tool" || c.Version != "2.4.1" || c.Release != "3" || c.Summary != "do things" {
+		t.Errorf("unexpected metadata %s-%s-%s (%q)", c.Package, c.Version, c.Release, c.Summary)
+	}
+	if c.License != "BSD-3-Clause" || c.Home != "https://example.org/tool" || c.Maintainer.String() != "Jane Packager <jane@example.org>" {
+		t.Errorf("unexpected license/homepage/maintainer %s/%s/%s", c.License, c.Home, c.Maintainer)
+	}
+	if len(c.Depends) != 1 || c.Depends[0] != "libc6 (>= 2.34)" {
+		t.Errorf("unexpected depends %q", c.Depends)
+	}
+	if len(mf.Files) != 2 {
+		t.Fatalf("want 2 files, got %d", len(mf.Files))
+	}
+	i, err := os.Stat("testdata/load/tool.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j, d := range []struct {
+		Name  string
+		Perm  int
+		Owner string
+		Conf  bool
+	}{
+		{Name: "/usr/bin/tool.sh", Perm: int(i.Mode().Perm()), Owner: "root:root"},
+		{Name: "/etc/tool/tool.conf", Perm: 0600, Owner: "tool:tool", Conf: true},
+	} {
+		f := mf.Files[j]
+		u, g := f.Owner()
+		if f.String() != d.Name || f.Perm != d.Perm || u+":"+g != d.Owner || f.Conf != d.Conf {
+			t.Errorf("%s: want mode %o owner %s conf %t, got %s mode %o owner %s:%s conf %t", d.Name, d.Perm, d.Owner, d.Conf, f, f.Perm, u, g, f.Conf)
+		}
+	}
+
+	for file, want := range map[string]string{
+		"testdata/load/noversion.toml": "package version missing",
+		"testdata/load/nosource.toml":  "source missing for /usr/bin/tool",
+		"testdata/load/missing.toml":   "",
+	} {
+		_, err := Load(file)
+		if err == nil {
+			t.Errorf("%s: expected error", file)
+			continue
+		}
+		if want != "" && err.Error() != file+": "+want {
+			t.Errorf("%s: want %q, got %q", file, want, err)
+		}
+	}
+}
+
+func TestExtractPath(t *testing.T) {
+	dir := filepath.Join("srv", "root")
+	for _, d := range []struct {
+		Name string
+		Want string
+	}{
+		{Name: "usr/bin/tool", Want: "srv/root/usr/bin/tool"},
+		{Name: "./etc/tool.conf", Want: "srv/root/etc/tool.conf"},
+		{Name: "/usr/share/doc/tool/README", Want: "srv/root/usr/share/doc/tool/README"},
+		{Name: "usr/lib/../share/tool", Want: "srv/root/usr/share/tool"},
+		{Name: "../etc/passwd"},
+		{Name: "./usr/../../etc/passwd"},
+		{Name: "usr/../../../etc/shadow"},
+		{Name: ".."},
+	} {
+		got, err := ExtractPath(dir, d.Name)
+		if d.Want == "" {
+			if err == nil {
+				t.Errorf("%s: want error, got %s", d.Name, got)
+			}
+			continue
+		}
+		if err != nil || got != filepath.FromSlash(d.Want) {
+			t.Errorf("%s: want %s, got %s (%v)", d.Name, d.Want, got, err)
+		}
+	}
+}
@@ -3,11 +3,13 @@ package rpm
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"fmt"
 	"hash"
 	"io"
 	"os"
@@ -20,45 +22,84 @@ import (
 	"github.com/midbel/packit/rw"
 	"github.com/midbel/tape"
 	"github.com/midbel/tape/cpio"
+	"golang.org/x/crypto/openpgp"
 )
 
+const incompressibleRatio = 75
+
 type builder struct {
 	when time.Time
 
 	control *packit.Control
 	files   []*packit.File
 	changes []*packit.Change
+	scripts []*packit.Script
+
+	compress packit.Compressor
+	nomd5    bool
+	weak     bool
+	signer   *openpgp.Entity
+	warnings []string
+}
+
+func (b *builder) Warnings() []string {
+	return b.warnings
 }
 
 func (b *builder) PackageName() string {
 	if b.control == nil {
 		return "packit.rpm"
 	}
-	return b.control.PackageName() + "." + Arch(b.control.Arch) + ".rpm"
+	return b.control.FileName("rpm", Arch(b.control.Arch))
 }
 
 func (b *builder) Build(w io.Writer) error {
+	return b.BuildContext(context.Background(), w)
+}
+
+func (b *builder) BuildContext(ctx context.Context, w io.Writer) error {
 	if err := b.writeLead(w); err != nil {
 		return err
 	}
 	var data, meta bytes.Buffer
-	size, err := b.writeData(&data)
+	size, err := b.writeData(ctx, &data)
 	if err != nil {
 		return err
 	}
 
 	sh1 := sha1.New()
-	if err := b.writeHeader(io.MultiWriter(&meta, sh1)); err != nil {
+	sum := sha256.Sum256(data.Bytes())
+	if err := b.writeHeader(io.MultiWriter(&meta, sh1), hex.EncodeToString(sum[:])); err != nil {
 		return err
 	}
+	var hsig []byte
+	if b.signer != nil {
+		if hsig, err = packit.Sign(b.signer, bytes.NewReader(meta.Bytes())); err != nil {
+			return err
+		}
+	}
 
-	md, sh256 := md5.New(), sha256.New()
-	var body bytes.Buffer
-	if _, err := io.Copy(io.MultiWriter(&body, md, sh256), io.MultiReader(&meta, &data)); err != nil {
+	var (
+		body  bytes.Buffer
+		md    hash.Hash
+		sh256 = sha256.New()
+	)
+	ws := []io.Writer{&body, sh256}
+	if !b.nomd5 {
+		md = md5.New()
+		ws = append(ws, md)
+	}
+	if _, err := io.Copy(io.MultiWriter(ws...), rw.Context(ctx, io.MultiReader(&meta, &data))); err != nil {
 		return err
 	}
+	var psig []byte
+	if b.signer != nil {
+		if psig, err = packit.Sign(b.signer, bytes.NewReader(body.Bytes())); err != nil {
+			return err
+		}
+	}
 	var sig bytes.Buffer
-	if err := b.writeSums(io.MultiWriter(w, &sig), size, body.Len(), md, sh1, sh256); err != nil {
+	if err := b.writeSums(io.MultiWriter(w, &sig), size, body.Len(), md, sh1, sh256, hsig, psig); err != nil {
 		return err
 	}
 
@@ -66,10 +107,14 @@ func (b *builder) Build(w io.Writer) error {
 	return err
 }
 
-func (b *builder) writeSums(w io.Writer, data, all int, md, h1, h256 hash.Hash) error {
+func (b *builder) writeSums(w io.Writer, data, all int, md, h1, h256 hash.Hash, hsig, psig []byte) error {
 	h1x := h1.Sum(nil)
 	h2x := h256.Sum(nil)
-	mdx := md.Sum(nil)
+
+	var mdx []byte
+	if md != nil {
+		mdx = md.Sum(nil)
+	}
 
 	fields := []rpmField{
 		number{tag: rpmSigLength, kind: fieldInt32, Value: int64(all)},
@@ -77,35 +122,61 @@ func (b *builder) writeSums(w io.Writer, data, all int, md, h1, h256 hash.Hash)
 		varchar{tag: rpmSigSha1, Value: hex.EncodeToString(h1x[:])},
 		binarray{tag: rpmSigMD5, Value: mdx[:]},
 		binarray{tag: rpmSigSha256, Value: h2x[:]},
+		binarray{tag: rpmSigRSA, Value: hsig},
+		binarray{tag: rpmSigPGP, Value: psig},
 	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Tag() < fields[j].Tag() })
 	return writeFields(w, fields, rpmTagSignatureIndex, true)
 }
 
-func (b *builder) writeHeader(w io.Writer) error {
+func (b *builder) writeHeader(w io.Writer, payload string) error {
 	fields := b.controlToFields()
 	fields = append(fields, b.filesToFields()...)
+	fields = append(fields, strarray{tag: rpmTagPayloadDigest, Values: []string{payload}})
+	fields = append(fields, numarray{tag: rpmTagPayloadDigestAlgo, kind: fieldInt32, Value: []int64{rpmHashSha256}})
 
 	return writeFields(w, fields, rpmTagImmutableIndex, false)
 }
 
-func (b *builder) writeData(w io.Writer) (int, error) {
+func (b *builder) writeData(ctx context.Context, w io.Writer) (int, error) {
 	var data bytes.Buffer
 	wc := cpio.NewWriter(&data)
 
-	digest := md5.New()
+	var (
+		digest = b.fileDigest()
+		stored int64
+		total  int64
+	)
 	for _, i := range b.files {
-		f, err := os.Open(i.Src)
+		if i.Special() {
+			h := tape.Header{
+				Filename: i.String(),
+				Mode:     i.TypeMode() | i.Mode(),
+				Length:   int64(len(i.Link)),
+				Uid:      int64(i.Uid),
+				Gid:      int64(i.Gid),
+				ModTime:  b.when,
+			}
+			if err := wc.WriteHeader(&h); err != nil {
+				return 0, err
+			}
+			// the content of a symlink is its target.
+			if _, err := io.WriteString(wc, i.Link); err != nil {
+				return 0, err
+			}
+			i.Size, i.Sum = h.Length, ""
+			continue
+		}
+		f, size, err := i.Open()
 		if err != nil {
 			return 0, err
 		}
-		var (
-			size int64
-			r    io.Reader
-		)
+		var r io.Reader
 		if i.Compress {
 			var body bytes.Buffer
 			z := gzip.NewWriter(&body)
-			if _, err := io.Copy(z, f); err != nil {
+			z.ModTime = b.when
+			if _, err := io.Copy(z, rw.Context(ctx, f)); err != nil {
 				return 0, err
 			}
 			if err := z.Close(); err != nil {
@@ -113,27 +184,25 @@ func (b *builder) writeData(w io.Writer) (int, error) {
 			}
 			r, size = &body, int64(body.Len())
 		} else {
-			s, err := f.Stat()
-			if err != nil {
-				return 0, err
-			}
-			size, r = s.Size(), f
+			r = rw.Context(ctx, f)
 		}
 		h := tape.Header{
 			Filename: i.String(),
-			Mode:     int64(i.Mode()),
+			Mode:     i.TypeMode() | i.Mode(),
 			Length:   size,
-			Uid:      0,
-			Gid:      0,
-			ModTime:  b.when,
+			Uid:      int64(i.Uid),
+			Gid:      int64(i.Gid),
+			ModTime:  i.Time(b.when),
 		}
 		if err := wc.WriteHeader(&h); err != nil {
 			return 0, err
 		}
-		if i.Size, err = io.Copy(io.MultiWriter(wc, digest), r); err != nil {
+		if err := i.Copy(wc, r, digest); err != nil {
 			return 0, err
 		}
-		i.Sum = hex.EncodeToString(digest.Sum(nil))
+		if total += i.Size; i.Compress || packit.Incompressible(i.String()) {
+			stored += i.Size
+		}
 
 		f.Close()
 		digest.Reset()
@@ -141,9 +210,25 @@ func (b *builder) writeData(w io.Writer) (int, error) {
 	if err := wc.Close(); err != nil {
 		return 0, err
 	}
+	if b.compress.Name() != packit.CompressNone && total > 0 && stored*100/total >= incompressibleRatio {
+		b.warnings = append(b.warnings, fmt.Sprintf("%s: %d%% of payload is already compressed, set compression = \"none\" to store it as is", b.PackageName(), stored*100/total))
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 	size := data.Len()
-	z, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
-	if _, err := io.Copy(z, &data); err != nil {
+	if b.compress.Name() == packit.CompressAuto {
+		c, err := b.compress.Auto(w, bytes.NewReader(data.Bytes()))
+		if err == nil {
+			b.compress = c
+		}
+		return size, err
+	}
+	z, err := b.compress.Writer(w)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(z, rw.Context(ctx, &data)); err != nil {
 		return 0, err
 	}
 	if err := z.Close(); err != nil {
@@ -152,6 +237,13 @@ func (b *builder) writeData(w io.Writer) (int, error) {
 	return size, nil
 }
 
+func (b *builder) fileDigest() hash.Hash {
+	if b.nomd5 {
+		return sha256.New()
+	}
+	return md5.New()
+}
+
 func (b *builder) writeLead(w io.Writer) error {
 	body := make([]byte, rpmLeadLen)
 	copy(body, rpmMagic)
@@ -174,10 +266,14 @@ func (b *builder) controlToFields() []rpmField {
 	var fs []rpmField
 	fs = append(fs, varchar{tag: rpmTagPackage, Value: b.control.Package})
 	fs = append(fs, varchar{tag: rpmTagVersion, Value: b.control.Version})
-	fs = append(fs, varchar{tag: rpmTagRelease, Value: b.control.Release})
-	fs = append(fs, varchar{tag: rpmTagSummary, kind: fieldI18NString, Value: b.control.Summary})
-	fs = append(fs, varchar{tag: rpmTagDesc, kind: fieldI18NString, Value: b.control.Desc})
-	fs = append(fs, varchar{tag: rpmTagGroup, kind: fieldI18NString, Value: b.control.Section})
+	fs = append(fs, varchar{tag: rpmTagRelease, Value: b.control.ReleaseFor("rpm")})
+	if b.control.Epoch > 0 {
+		fs = append(fs, number{tag: rpmTagEpoch, kind: fieldInt32, Value: int64(b.control.Epoch)})
+	}
+	fs = append(fs, strarray{tag: rpmTagI18NTable, Values: rpmLocales})
+	fs = append(fs, i18n{tag: rpmTagSummary, Value: b.control.Summary})
+	fs = append(fs, i18n{tag: rpmTagDesc, Value: b.control.Desc})
+	fs = append(fs, i18n{tag: rpmTagGroup, Value: b.control.Section})
 	fs = append(fs, varchar{tag: rpmTagOS, Value: packit.DefaultOS})
 	fs = append(fs, number{tag: rpmTagBuildTime, kind: fieldInt32, Value: b.when.Unix()})
 	fs = append(fs, varchar{tag: rpmTagBuildHost, Value: packit.Hostname()})
@@ -189,8 +285,23 @@ func (b *builder) controlToFields() []rpmField {
 	fs = append(fs, varchar{tag: rpmTagOS, Value: b.control.Os})
 	fs = append(fs, varchar{tag: rpmTagArch, Value: Arch(b.control.Arch)})
 	fs = append(fs, varchar{tag: rpmTagPayload, Value: rpmPayloadFormat})
-	fs = append(fs, varchar{tag: rpmTagCompressor, Value: rpmPayloadCompressor})
-	fs = append(fs, varchar{tag: rpmTagPayloadFlags, Value: rpmPayloadFlags})
+	fs = append(fs, varchar{tag: rpmTagCompressor, Value: payloadCompressor(b.compress)})
+	fs = append(fs, varchar{tag: rpmTagPayloadFlags, Value: b.compress.Level()})
+	if b.control.SourceDigest != "" {
+		fs = append(fs, varchar{tag: rpmTagSourceDigest, Value: b.control.SourceDigest})
+	}
+
+	fs = append(fs, dependsToFields(b.provides(), rpmTagProvideName, rpmTagProvideFlags, rpmTagProvideVersion)...)
+	fs = append(fs, dependsToFields(parseDepends(b.control.Depends), rpmTagRequireName, rpmTagRequireFlags, rpmTagRequireVersion)...)
+	fs = append(fs, dependsToFields(parseDepends(b.control.Conflicts), rpmTagConflictName, rpmTagConflictFlags, rpmTagConflictVersion)...)
+	fs = append(fs, dependsToFields(parseDepends(b.control.Obsoletes), rpmTagObsoleteName, rpmTagObsoleteFlags, rpmTagObsoleteVersion)...)
+	if b.weak {
+		fs = append(fs, dependsToFields(parseDepends(b.control.Suggests), rpmTagSuggestName, rpmTagSuggestFlags, rpmTagSuggestVersion)...)
+		fs = append(fs, dependsToFields(parseDepends(b.control.Supplements), rpmTagSupplementName, rpmTagSupplementFlags, rpmTagSupplementVersion)...)
+		fs = append(fs, dependsToFields(parseDepends(b.control.Enhances), rpmTagEnhanceName, rpmTagEnhanceFlags, rpmTagEnhanceVersion)...)
+	}
+
+	fs = append(fs, b.scriptsToFields()...)
 
 	if n := len(b.changes); n > 0 {
 		ts, cs, ls := make([]int64, n), make([]string, n), make([]string, n)
@@ -203,8 +314,10 @@ func (b *builder) controlToFields() []rpmField {
 			} else {
 				cs[i] = b.changes[i].Maintainer.String()
 			}
-			if b.changes[i].Version != "" {
-				cs[i] = cs[i] + " - " + b.changes[i].Version
+			if v := b.changes[i].Version; v != "" {
+				cs[i] = cs[i] + " - " + v
+			} else {
+				cs[i] = cs[i] + " - " + b.control.EVR("rpm")
 			}
 			ls[i] = rw.CleanAndWrapDefault(strings.TrimSpace(b.changes[i].Body))
 		}
@@ -216,6 +329,45 @@ func (b *builder) controlToFields() []rpmField {
 	return fs
 }
 
+func (b *builder) scriptsToFields() []rpmField {
+	tags := []struct {
+		Script int32
+		Prog   int32
+	}{
+		{Script: rpmTagPreIn, Prog: rpmTagPreInProg},
+		{Script: rpmTagPostIn, Prog: rpmTagPostInProg},
+		{Script: rpmTagPreUn, Prog: rpmTagPreUnProg},
+		{Script: rpmTagPostUn, Prog: rpmTagPostUnProg},
+	}
+	var fs []rpmField
+	for i, s := range b.scripts {
+		if s == nil || i >= len(tags) {
+			continue
+		}
+		f := varchar{tag: tags[i].Script, Value: s.String()}
+		if f.Skip() {
+			continue
+		}
+		fs = append(fs, f, varchar{tag: tags[i].Prog, Value: s.Program()})
+	}
+	return fs
+}
+
+func (b *builder) provides() []depend {
+	self := depend{
+		Name:    b.control.Package,
+		Flags:   rpmSenseEqual,
+		Version: b.control.EVR("rpm"),
+	}
+	ds := []depend{self}
+	for _, p := range b.control.Provides {
+		if d := parseDepend(p); d.Name != "" && d != self {
+			ds = append(ds, d)
+		}
+	}
+	return ds
+}
+
 func (b *builder) filesToFields() []rpmField {
 	var fs []rpmField
 
@@ -223,26 +375,31 @@ func (b *builder) filesToFields() []rpmField {
 	done := make(map[string]int)
 	files := make([]string, z)
 	indexes := make([]int64, z)
-	flags, devs, inodes := make([]int64, z), make([]int64, z), make([]int64, z)
+	flags, rdevs, devs := make([]int64, z), make([]int64, z), make([]int64, z)
 	modes := make([]int64, z)
 	links, langs := make([]string, z), make([]string, z)
 	dirs, bases := make([]string, 0, z), make([]string, z)
 	users, groups := make([]string, z), make([]string, z)
 	sizes, digests, times := make([]int64, z), make([]string, z), make([]int64, z)
+	inodes := b.fileInodes()
 	for i := range b.files {
 		files[i] = b.files[i].String()
 		if !strings.HasPrefix(files[i], "/") {
 			files[i] = "/" + files[i]
 		}
 		d, n := filepath.Split(files[i])
-		dirs = append(dirs, createListDirs(d, done)...)
-		bases[i], indexes[i], modes[i] = n, int64(done[d]), int64(b.files[i].Perm)
-		inodes[i] = int64(i) + b.when.Unix()
+		if _, ok := done[d]; !ok {
+			done[d] = len(dirs)
+			dirs = append(dirs, d)
+		}
+		bases[i], indexes[i], modes[i] = n, int64(done[d]), b.files[i].TypeMode()|b.files[i].Mode()
+		devs[i] = 1
+		rdevs[i], links[i] = b.files[i].Rdev(), b.files[i].Link
 		flags[i] = int64(fileFlags(b.files[i]))
-		users[i], groups[i] = packit.DefaultUser, packit.DefaultGroup
+		users[i], groups[i] = b.files[i].Owner()
 		sizes[i], digests[i] = int64(b.files[i].Size), b.files[i].Sum
 		langs[i] = b.files[i].Lang
-		times[i] = b.when.Unix()
+		times[i] = b.files[i].Time(b.when).Unix()
 
 		b.control.Size += b.files[i].Size
 	}
@@ -250,8 +407,9 @@ func (b *builder) filesToFields() []rpmField {
 	fs = append(fs, number{tag: rpmTagSize, kind: fieldInt32, Value: b.control.Size})
 	fs = append(fs, numarray{tag: rpmTagDirIndexes, kind: fieldInt32, Value: indexes})
 	fs = append(fs, numarray{tag: rpmTagFileFlags, kind: fieldInt32, Value: flags})
-	fs = append(fs, numarray{tag: rpmTagFileModes, kind: fieldInt16, Value: flags})
-	fs = append(fs, numarray{tag: rpmTagFileDevs, kind: fieldInt16, Value: devs})
+	fs = append(fs, numarray{tag: rpmTagFileModes, kind: fieldInt16, Value: modes})
+	fs = append(fs, numarray{tag: rpmTagFileRdevs, kind: fieldInt16, Value: rdevs})
+	fs = append(fs, numarray{tag: rpmTagFileDevices, kind: fieldInt32, Value: devs})
 	fs = append(fs, numarray{tag: rpmTagFileInodes, kind: fieldInt32, Value: inodes})
 	fs = append(fs, strarray{tag: rpmTagFileLangs, Values: langs})
 	fs = append(fs, strarray{tag: rpmTagBasenames, Values: bases})
@@ -263,25 +421,38 @@ func (b *builder) filesToFields() []rpmField {
 	fs = append(fs, strarray{tag: rpmTagFileDigests, Values: digests})
 	fs = append(fs, numarray{tag: rpmTagFileSizes, kind: fieldInt32, Value: sizes})
 	fs = append(fs, numarray{tag: rpmTagFileTimes, kind: fieldInt32, Value: times})
+	if b.nomd5 {
+		fs = append(fs, number{tag: rpmTagFileDigestAlgo, kind: fieldInt32, Value: rpmHashSha256})
+	}
 
 	return fs
 }
 
-func createListDirs(d string, done map[string]int) []string {
-	ds := strings.Split(strings.TrimPrefix(d, "/"), "/")
-	var dirs []string
-	for i := 0; i < len(ds); i++ {
-		n := ds[i]
-		if i > 0 {
-			n = "/" + filepath.Join(strings.Join(ds[:i], "/"), n) + "/"
-		}
-		if _, ok := done[n]; ok {
+func (b *builder) fileInodes() []int64 {
+	var (
+		inodes = make([]int64, len(b.files))
+		seen   []os.FileInfo
+	)
+	for i, f := range b.files {
+		s, err := os.Stat(f.Src)
+		if err != nil {
+			inodes[i] = int64(len(seen) + 1)
+			seen = append(seen, nil)
 			continue
 		}
-		done[n] = len(dirs)
-		dirs = append(dirs, n)
+		ix := len(seen)
+		for j, o := range seen {
+			if o != nil && os.SameFile(o, s) {
+				ix = j
+				break
+			}
+		}
+		if ix == len(seen) {
+			seen = append(seen, s)
+		}
+		inodes[i] = int64(ix + 1)
 	}
-	return dirs
+	return inodes
 }
 
 func writeFields(w io.Writer, fields []rpmField, tag int32, pad bool) error {
@@ -0,0 +1,300 @@
+package rpm
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/midbel/packit"
+)
+
+func TestWriteFieldsAlignment(t *testing.T) {
+	fields := []rpmField{
+		varchar{tag: rpmTagPackage, Value: "ab"},
+		number{tag: rpmTagBuildTime, kind: fieldInt32, Value: 1588334400},
+		varchar{tag: rpmTagVersion, Value: "1.0"},
+		numarray{tag: rpmTagFileModes, kind: fieldInt16, Value: []int64{0755, 0644, 0600}},
+		varchar{tag: rpmTagRelease, Value: "1"},
+		number{tag: rpmTagSize, kind: fieldInt64, Value: 1 << 33},
+		numarray{tag: rpmTagFileSizes, kind: fieldInt32, Value: []int64{7, 11}},
+	}
+	var w bytes.Buffer
+	if err := writeFields(&w, fields, rpmTagImmutableIndex, false); err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(w.Bytes())
+	var preamble [4]int32
+	binary.Read(r, binary.BigEndian, &preamble)
+	sizes := map[fieldType]int32{fieldInt16: 2, fieldInt32: 4, fieldInt64: 8}
+	for i := int32(0); i < preamble[2]; i++ {
+		var e rpmEntry
+		if err := binary.Read(r, binary.BigEndian, &e); err != nil {
+			t.Fatal(err)
+		}
+		if n, ok := sizes[e.Type]; ok && e.Offset%n != 0 {
+			t.Errorf("tag %d: offset %d not aligned on %d bytes", e.Tag, e.Offset, n)
+		}
+	}
+
+	tags := make(map[int32]interface{})
+	err := readHeader(bytes.NewReader(w.Bytes()), false, func(tag int32, v interface{}) error {
+		tags[tag] = v
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := tags[rpmTagBuildTime].([]int64); len(v) != 1 || v[0] != 1588334400 {
+		t.Errorf("buildtime: want [1588334400], got %v", tags[rpmTagBuildTime])
+	}
+	for tag, want := range map[int32]string{rpmTagPackage: "ab", rpmTagVersion: "1.0", rpmTagRelease: "1"} {
+		if v, _ := tags[tag].(string); v != want {
+			t.Errorf("tag %d: want %q, got %v", tag, want, tags[tag])
+		}
+	}
+	if v, _ := tags[rpmTagFileModes].([]int64); len(v) != 3 || v[0] != 0755 || v[2] != 0600 {
+		t.Errorf("filemodes: want [755 644 600], got %o", tags[rpmTagFileModes])
+	}
+	if v, _ := tags[rpmTagFileSizes].([]int64); len(v) != 2 || v[1] != 11 {
+		t.Errorf("filesizes: want [7 11], got %v", tags[rpmTagFileSizes])
+	}
+}
+
+func TestWriteLeadName(t *testing.T) {
+	for _, d := range []struct {
+		Package string
+		Want    string
+	}{
+		{Package: "mirror", Want: "mirror-0.9.2"},
+		{Package: strings.Repeat("m", 60), Want: strings.Repeat("m", 60) + "-0.9."},
+		{Package: strings.Repeat("m", 66), Want: strings.Repeat("m", 65)},
+		{Package: strings.Repeat("m", 80), Want: strings.Repeat("m", 65)},
+	} {
+		b := builder{control: &packit.Control{Package: d.Package, Version: "0.9.2"}}
+		var w bytes.Buffer
+		if err := b.writeLead(&w); err != nil {
+			t.Fatal(err)
+		}
+		bs := w.Bytes()
+		if len(bs) != rpmLeadLen {
+			t.Fatalf("%d: want lead of %d bytes, got %d", len(d.Package), rpmLeadLen, len(bs))
+		}
+		if bs[75] != 0 {
+			t.Errorf("%d: name not NUL-terminated", len(d.Package))
+		}
+		name, kind, err := readLead(bytes.NewReader(bs))
+		if err != nil {
+			t.Fatalf("%d: %s", len(d.Package), err)
+		}
+		if name != d.Want || kind != rpmSigType {
+			t.Errorf("%d: want name %q, got %q (signature type %d)", len(d.Package), d.Want, name, kind)
+		}
+	}
+}
+
+// readEntries gives the index entries of the main header of the package found
+// in file.
+func readEntries(t *testing.T, file string) map[int32]rpmEntry {
+	t.Helper()
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(bs)
+	_, kind, err := readLead(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSignature(r, kind); err != nil {
+		t.Fatal(err)
+	}
+	var intro [4]int32
+	if err := binary.Read(r, binary.BigEndian, &intro); err != nil {
+		t.Fatal(err)
+	}
+	es := make(map[int32]rpmEntry)
+	for i := int32(0); i < intro[2]; i++ {
+		var e rpmEntry
+		if err := binary.Read(r, binary.BigEndian, &e); err != nil {
+			t.Fatal(err)
+		}
+		es[e.Tag] = e
+	}
+	return es
+}
+
+func TestI18NStrings(t *testing.T) {
+	var (
+		file = buildFixture(t, "testdata/remote.toml", nil)
+		es   = readEntries(t, file)
+		tags = readTags(t, file)
+	)
+	if e := es[rpmTagI18NTable]; e.Type != fieldStrArray || e.Len != 1 {
+		t.Errorf("I18NTABLE: want string array of 1 locale, got type %d count %d", e.Type, e.Len)
+	}
+	if ls, _ := tags[rpmTagI18NTable].([]string); len(ls) != 1 || ls[0] != "C" {
+		t.Errorf("I18NTABLE: want [C], got %q", ls)
+	}
+	for tag, want := range map[int32]string{
+		rpmTagSummary: "helpers to sync package mirrors",
+		rpmTagDesc:    "mirror-tools fetches repositories from object storage.",
+		rpmTagGroup:   "System Environment/Base",
+	} {
+		if e := es[tag]; e.Type != fieldI18NString || e.Len != 1 {
+			t.Errorf("%s: want i18n string of 1 locale, got type %d count %d", TagName(tag), e.Type, e.Len)
+		}
+		if v, _ := tags[tag].(string); v != want {
+			t.Errorf("%s: want %q, got %q", TagName(tag), want, v)
+		}
+	}
+}
+
+func TestBuildQuiet(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	buildFixture(t, "testdata/remote.toml", nil)
+	os.Stdout = stdout
+	w.Close()
+
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bs) > 0 {
+		t.Errorf("builder wrote %d bytes to stdout: %q", len(bs), bs)
+	}
+}
+
+func TestPackageName(t *testing.T) {
+	for _, d := range []struct {
+		Arch uint8
+		Dist string
+		Want string
+	}{
+		{Arch: packit.Arch64, Want: "mirror-tools-0.9.2-5.x86_64.rpm"},
+		{Arch: packit.Arch32, Want: "mirror-tools-0.9.2-5.i386.rpm"},
+		{Arch: packit.ArchAll, Want: "mirror-tools-0.9.2-5.noarch.rpm"},
+		{Arch: packit.Arch64, Dist: "el9", Want: "mirror-tools-0.9.2-5.el9.x86_64.rpm"},
+	} {
+		mf, err := packit.Load("testdata/remote.toml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mf.Arch, mf.Dist = d.Arch, d.Dist
+		b, err := Build(mf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := b.PackageName(); got != d.Want {
+			t.Errorf("want %s, got %s", d.Want, got)
+		}
+	}
+}
+
+func TestFileList(t *testing.T) {
+	var (
+		tags    = readTags(t, buildFixture(t, "testdata/toolbox.toml", nil))
+		bases   = tags[rpmTagBasenames].([]string)
+		dirs    = tags[rpmTagDirnames].([]string)
+		indexes = tags[rpmTagDirIndexes].([]int64)
+		sizes   = tags[rpmTagFileSizes].([]int64)
+		digests = tags[rpmTagFileDigests].([]string)
+	)
+	want := []string{"/usr/bin/", "/etc/mirror-tools/", "/usr/share/doc/mirror-toolbox/"}
+	if strings.Join(dirs, " ") != strings.Join(want, " ") {
+		t.Errorf("dirnames: want %q, got %q", want, dirs)
+	}
+	files := map[string]string{
+		"/usr/bin/mirror-sync":                    "testdata/mirror-sync.sh",
+		"/etc/mirror-tools/mirror.conf":           "testdata/mirror.conf",
+		"/usr/bin/netcheck":                       "testdata/netcheck.sh",
+		"/usr/share/doc/mirror-toolbox/icons.txt": "testdata/icons.txt",
+	}
+	if len(bases) != len(files) || len(indexes) != len(bases) || len(sizes) != len(bases) || len(digests) != len(bases) {
+		t.Fatalf("arrays not consistent: %d basenames, %d dirindexes, %d sizes, %d digests", len(bases), len(indexes), len(sizes), len(digests))
+	}
+	for i := range bases {
+		if indexes[i] < 0 || int(indexes[i]) >= len(dirs) {
+			t.Errorf("%s: dir index %d out of range", bases[i], indexes[i])
+			continue
+		}
+		name := dirs[indexes[i]] + bases[i]
+		src, ok := files[name]
+		if !ok {
+			t.Errorf("unexpected file %s", name)
+			continue
+		}
+		delete(files, name)
+		bs, err := ioutil.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sizes[i] != int64(len(bs)) {
+			t.Errorf("%s: want size %d, got %d", name, len(bs), sizes[i])
+		}
+		if sum := fmt.Sprintf("%x", md5.Sum(bs)); digests[i] != sum {
+			t.Errorf("%s: want digest %s, got %s", name, sum, digests[i])
+		}
+	}
+	for f := range files {
+		t.Errorf("%s: missing from header", f)
+	}
+}
+
+func TestRegionEntries(t *testing.T) {
+	bs, err := ioutil.ReadFile(buildFixture(t, "testdata/requires.toml", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := OpenReaderAt(bytes.NewReader(bs), int64(len(bs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []struct {
+		Tag    int32
+		Offset int64
+	}{
+		{Tag: rpmTagSignatureIndex, Offset: rpmLeadLen},
+		{Tag: rpmTagImmutableIndex, Offset: p.(*pkg).header[0]},
+	} {
+		r := bytes.NewReader(bs[d.Offset:])
+		var intro [4]int32
+		if err := binary.Read(r, binary.BigEndian, &intro); err != nil {
+			t.Fatal(err)
+		}
+		count, size := intro[2], intro[3]
+		var first rpmEntry
+		if err := binary.Read(r, binary.BigEndian, &first); err != nil {
+			t.Fatal(err)
+		}
+		if first.Tag != d.Tag || first.Type != fieldBinary || first.Len != rpmEntryLen {
+			t.Errorf("region %d: unexpected first entry %+v", d.Tag, first)
+			continue
+		}
+		if first.Offset != size-rpmEntryLen {
+			t.Errorf("region %d: want trailer at %d, got %d", d.Tag, size-rpmEntryLen, first.Offset)
+		}
+		var trailer rpmEntry
+		store := bs[d.Offset+int64(16+count*rpmEntryLen):]
+		if err := binary.Read(bytes.NewReader(store[first.Offset:]), binary.BigEndian, &trailer); err != nil {
+			t.Fatal(err)
+		}
+		if trailer.Tag != d.Tag || trailer.Type != fieldBinary || trailer.Len != rpmEntryLen {
+			t.Errorf("region %d: unexpected trailer %+v", d.Tag, trailer)
+		}
+		if want := -count * rpmEntryLen; trailer.Offset != want {
+			t.Errorf("region %d: want trailer offset %d, got %d", d.Tag, want, trailer.Offset)
+		}
+	}
+}
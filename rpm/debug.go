@@ -24,14 +24,17 @@ func Debug(file string, w io.Writer) error {
 	ws := tabwriter.NewWriter(w, 12, 2, 2, ' ', 0)
 	f := dumpEntry(ws)
 
-	if _, err := readLead(r); err != nil {
+	_, kind, err := readLead(r)
+	if err != nil {
 		return err
 	}
-	if err := debugEntries(r, true, f); err != nil {
-		return err
+	if kind != rpmSigNone {
+		if err := debugEntries(r, true, f); err != nil {
+			return err
+		}
+		ws.Flush()
+		fmt.Fprintln(w)
 	}
-	ws.Flush()
-	fmt.Fprintln(w)
 	if err := debugEntries(r, false, f); err != nil {
 		return err
 	}
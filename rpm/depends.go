@@ -0,0 +1,156 @@
+package rpm
+
+import (
+	"strings"
+)
+
+const (
+	rpmSenseAny     = 0
+	rpmSenseLess    = 1 << 1
+	rpmSenseGreater = 1 << 2
+	rpmSenseEqual   = 1 << 3
+)
+
+type depend struct {
+	Name    string
+	Flags   int64
+	Version string
+}
+
+// parseDepend accepts dependencies written as "name op version" or in the
+// deb syntax "name (op version)". Alternatives (a | b) are turned into a rich
+// dependency (a or b) since rpm has no other way to express them.
+func parseDepend(str string) depend {
+	str = strings.TrimSpace(str)
+	if strings.HasPrefix(str, "(") {
+		return depend{Name: str}
+	}
+	if strings.Contains(str, "|") {
+		var alts []string
+		for _, a := range strings.Split(str, "|") {
+			if d := parseDepend(a); d.Name != "" {
+				alts = append(alts, d.String())
+			}
+		}
+		if len(alts) <= 1 {
+			return parseDepend(strings.Join(alts, ""))
+		}
+		return depend{Name: "(" + strings.Join(alts, " or ") + ")"}
+	}
+
+	var (
+		d    depend
+		rest string
+	)
+	if i := versionStart(str); i >= 0 {
+		d.Name, rest = strings.TrimSpace(str[:i]), str[i:]
+	} else {
+		d.Name = str
+	}
+	rest = strings.TrimSpace(strings.NewReplacer("(", " ", ")", " ").Replace(rest))
+	if i := strings.IndexFunc(rest, func(r rune) bool { return !strings.ContainsRune("<>=", r) }); i > 0 {
+		if fs := strings.Fields(rest[i:]); len(fs) > 0 {
+			d.Flags, d.Version = senseFlags(rest[:i]), fs[0]
+		}
+	}
+	return d
+}
+
+// versionStart gives the position of the version constraint in str. Parens
+// are part of the name (perl(Foo)) unless they hold the constraint.
+func versionStart(str string) int {
+	for i, r := range str {
+		switch r {
+		case ' ', '\t', '<', '>', '=':
+			return i
+		case '(':
+			if x := strings.TrimLeft(str[i+1:], " "); x != "" && strings.IndexByte("<>=", x[0]) >= 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func parseDepends(vs []string) []depend {
+	var ds []depend
+	for _, v := range vs {
+		if d := parseDepend(v); d.Name != "" {
+			ds = append(ds, d)
+		}
+	}
+	return ds
+}
+
+func (d depend) String() string {
+	if d.Version == "" {
+		return d.Name
+	}
+	return d.Name + " " + senseOperator(d.Flags) + " " + d.Version
+}
+
+func senseOperator(f int64) string {
+	switch f & (rpmSenseLess | rpmSenseGreater | rpmSenseEqual) {
+	case rpmSenseLess:
+		return "<"
+	case rpmSenseLess | rpmSenseEqual:
+		return "<="
+	case rpmSenseGreater:
+		return ">"
+	case rpmSenseGreater | rpmSenseEqual:
+		return ">="
+	default:
+		return "="
+	}
+}
+
+func senseFlags(op string) int64 {
+	switch op {
+	case "<", "<<":
+		return rpmSenseLess
+	case "<=":
+		return rpmSenseLess | rpmSenseEqual
+	case "=", "==":
+		return rpmSenseEqual
+	case ">=":
+		return rpmSenseGreater | rpmSenseEqual
+	case ">", ">>":
+		return rpmSenseGreater
+	default:
+		return rpmSenseAny
+	}
+}
+
+func dependsToFields(ds []depend, name, flag, version int32) []rpmField {
+	if len(ds) == 0 {
+		return nil
+	}
+	var (
+		names    = make([]string, len(ds))
+		flags    = make([]int64, len(ds))
+		versions = make([]string, len(ds))
+	)
+	for i, d := range ds {
+		names[i], flags[i], versions[i] = d.Name, d.Flags, d.Version
+	}
+	return []rpmField{
+		strarray{tag: name, Values: names},
+		numarray{tag: flag, kind: fieldInt32, Value: flags},
+		strarray{tag: version, Values: versions},
+	}
+}
+
+func fieldsToDepends(names []string, flags []int64, versions []string) []string {
+	var vs []string
+	for i := range names {
+		d := depend{Name: names[i]}
+		if i < len(flags) {
+			d.Flags = flags[i]
+		}
+		if i < len(versions) {
+			d.Version = versions[i]
+		}
+		vs = append(vs, d.String())
+	}
+	return vs
+}
@@ -0,0 +1,32 @@
+package rpm
+
+import (
+	"testing"
+)
+
+func TestParseDepend(t *testing.T) {
+	data := []struct {
+		Input string
+		Want  depend
+	}{
+		{Input: "libc", Want: depend{Name: "libc"}},
+		{Input: "libc >= 2.17", Want: depend{Name: "libc", Flags: rpmSenseGreater | rpmSenseEqual, Version: "2.17"}},
+		{Input: "libc>=2.17", Want: depend{Name: "libc", Flags: rpmSenseGreater | rpmSenseEqual, Version: "2.17"}},
+		{Input: "libc6 (>= 2.17)", Want: depend{Name: "libc6", Flags: rpmSenseGreater | rpmSenseEqual, Version: "2.17"}},
+		{Input: "libc6(<< 3)", Want: depend{Name: "libc6", Flags: rpmSenseLess, Version: "3"}},
+		{Input: "python3 (= 3.9.2-3)", Want: depend{Name: "python3", Flags: rpmSenseEqual, Version: "3.9.2-3"}},
+		{Input: "perl(Getopt::Long)", Want: depend{Name: "perl(Getopt::Long)"}},
+		{Input: "perl(Getopt::Long) >= 2.5", Want: depend{Name: "perl(Getopt::Long)", Flags: rpmSenseGreater | rpmSenseEqual, Version: "2.5"}},
+		{Input: "mail-transport-agent | postfix", Want: depend{Name: "(mail-transport-agent or postfix)"}},
+		{Input: "awk | mawk (>= 1.3)", Want: depend{Name: "(awk or mawk >= 1.3)"}},
+		{Input: "libssl1.1 |", Want: depend{Name: "libssl1.1"}},
+		{Input: "(foo if bar)", Want: depend{Name: "(foo if bar)"}},
+		{Input: "  ", Want: depend{}},
+	}
+	for _, d := range data {
+		got := parseDepend(d.Input)
+		if got != d.Want {
+			t.Errorf("%q: want %+v, got %+v", d.Input, d.Want, got)
+		}
+	}
+}
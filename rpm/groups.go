@@ -0,0 +1,48 @@
+package rpm
+
+import (
+	"fmt"
+)
+
+var groups = map[string]struct{}{
+	"Amusements/Games":                  {},
+	"Amusements/Graphics":               {},
+	"Applications/Archiving":            {},
+	"Applications/Communications":       {},
+	"Applications/Databases":            {},
+	"Applications/Editors":              {},
+	"Applications/Emulators":            {},
+	"Applications/Engineering":          {},
+	"Applications/File":                 {},
+	"Applications/Internet":             {},
+	"Applications/Multimedia":           {},
+	"Applications/Productivity":         {},
+	"Applications/Publishing":           {},
+	"Applications/System":               {},
+	"Applications/Text":                 {},
+	"Development/Debuggers":             {},
+	"Development/Languages":             {},
+	"Development/Libraries":             {},
+	"Development/System":                {},
+	"Development/Tools":                 {},
+	"Documentation":                     {},
+	"System Environment/Base":           {},
+	"System Environment/Daemons":        {},
+	"System Environment/Kernel":         {},
+	"System Environment/Libraries":      {},
+	"System Environment/Shells":         {},
+	"User Interface/Desktops":           {},
+	"User Interface/X":                  {},
+	"User Interface/X Hardware Support": {},
+	"Unspecified":                       {},
+}
+
+func checkGroup(g string) error {
+	if g == "" {
+		return nil
+	}
+	if _, ok := groups[g]; !ok {
+		return fmt.Errorf("%s: unknown rpm group", g)
+	}
+	return nil
+}
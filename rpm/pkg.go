@@ -3,37 +3,64 @@ package rpm
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/midbel/packit"
+	"github.com/midbel/tape"
 	"github.com/midbel/tape/cpio"
-	"github.com/ulikunitz/xz"
+	"golang.org/x/crypto/openpgp"
 )
 
 type pkg struct {
 	name string
+	file string
 
 	control *packit.Control
 	history packit.History
+	scripts map[string]*packit.Script
 
-	data *bytes.Reader
+	archive int64
+	header  [2]int64
+
+	ra   io.ReaderAt
+	size int64
+
+	digests map[string]string
+	sizes   map[string]int64
+	owners  map[string][2]string
+	rdevs   map[string]int64
+	digest  func() hash.Hash
 }
 
+const extractBuffer = 4 << 20
+
+// maxLinkLength is PATH_MAX: no symlink target read from a payload can be
+// longer than that.
+const maxLinkLength = 4096
+
 type signature struct {
 	Payload int64
 	Size    int64
 	Sha1    string
 	Sha256  string
 	MD5     string
+
+	Header     []byte
+	HeaderKind string
+	Full       []byte
+	FullKind   string
 }
 
 func (p *pkg) PackageType() string {
@@ -45,9 +72,107 @@ func (p *pkg) PackageName() string {
 }
 
 func (p *pkg) Valid() error {
+	n := p.control.Package
+	if len(n) > 65 {
+		n = n[:65]
+	}
+	if p.name != n && !strings.HasPrefix(p.name, n+"-") {
+		return fmt.Errorf("%s: lead name does not match header name %s", p.name, p.control.Package)
+	}
+	cs, err := p.Verify()
+	if err != nil {
+		return err
+	}
+	for _, c := range cs {
+		if !c.Match {
+			return fmt.Errorf("invalid checksum for %s", c.Name)
+		}
+	}
 	return nil
 }
 
+func (p *pkg) Verify() ([]packit.FileCheck, error) {
+	z, err := p.payload()
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	var (
+		cs []packit.FileCheck
+		r  = cpio.NewReader(z)
+	)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		sum, ok := p.digests[fileKey(h.Filename)]
+		if !ok || h.Mode&0170000 != 0100000 {
+			if _, err := io.CopyN(ioutil.Discard, r, h.Length); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		digest := p.digest()
+		if _, err := io.CopyN(digest, r, h.Length); err != nil {
+			return nil, err
+		}
+		c := packit.FileCheck{
+			Name:     h.Filename,
+			Expected: sum,
+			Actual:   hex.EncodeToString(digest.Sum(nil)),
+		}
+		c.Match = c.Expected == c.Actual
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+func (p *pkg) Signature(kr openpgp.KeyRing) (*packit.Signature, error) {
+	var r io.Reader
+	if p.ra != nil {
+		r = io.NewSectionReader(p.ra, 0, p.size)
+	} else {
+		f, err := os.Open(p.file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	_, kind, err := readLead(r)
+	if err != nil {
+		return nil, err
+	}
+	s, err := readSignature(r, kind)
+	if err != nil {
+		return nil, err
+	}
+	var header bytes.Buffer
+	err = readHeader(io.TeeReader(r, &header), false, func(_ int32, _ interface{}) error {
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(s.Header) > 0:
+		return packit.CheckSignature(kr, s.HeaderKind, &header, s.Header), nil
+	case len(s.Full) > 0:
+		return packit.CheckSignature(kr, s.FullKind, io.MultiReader(&header, r), s.Full), nil
+	default:
+		return nil, nil
+	}
+}
+
+func (p *pkg) Scripts() map[string]*packit.Script {
+	return p.scripts
+}
+
 func (p *pkg) About() packit.Control {
 	return *p.control
 }
@@ -57,13 +182,12 @@ func (p *pkg) History() packit.History {
 }
 
 func (p *pkg) Resources() ([]packit.Resource, error) {
-	if p.data == nil {
-		return nil, packit.ErrUnsupportedPayloadFormat
-	}
-	if _, err := p.data.Seek(0, io.SeekStart); err != nil {
+	z, err := p.payload()
+	if err != nil {
 		return nil, err
 	}
-	r := cpio.NewReader(p.data)
+	defer z.Close()
+	r := cpio.NewReader(z)
 	var rs []packit.Resource
 	for {
 		h, err := r.Next()
@@ -78,11 +202,25 @@ func (p *pkg) Resources() ([]packit.Resource, error) {
 			Size:    h.Length,
 			ModTime: h.ModTime,
 			Perm:    h.Mode,
+			Uid:     int(h.Uid),
+			Gid:     int(h.Gid),
 		}
-		rs = append(rs, e)
-		if _, err := io.CopyN(ioutil.Discard, r, h.Length); err != nil {
+		if o, ok := p.owners[fileKey(h.Filename)]; ok {
+			e.User, e.Group = o[0], o[1]
+		}
+		if d, ok := p.rdevs[fileKey(h.Filename)]; ok {
+			e.Major, e.Minor = int(d>>8), int(d&0xFF)
+		}
+		if h.Mode&0170000 == 0120000 && h.Length <= maxLinkLength {
+			link := make([]byte, h.Length)
+			if _, err := io.ReadFull(r, link); err != nil {
+				return nil, err
+			}
+			e.Link = string(link)
+		} else if _, err := io.CopyN(ioutil.Discard, r, h.Length); err != nil {
 			return nil, err
 		}
+		rs = append(rs, e)
 	}
 	return rs, nil
 }
@@ -99,44 +237,149 @@ func (p *pkg) Filenames() ([]string, error) {
 	return vs, nil
 }
 
-func (p *pkg) Extract(datadir string, preserve bool) error {
-	if p.data == nil {
-		return packit.ErrUnsupportedPayloadFormat
-	}
+func (p *pkg) Extract(datadir string, opts packit.ExtractOptions) error {
 	if err := os.MkdirAll(datadir, 0755); err != nil && !os.IsExist(err) {
 		return err
 	}
-	if _, err := p.data.Seek(0, io.SeekStart); err != nil {
+	z, err := p.payload()
+	if err != nil {
 		return err
 	}
-	r := cpio.NewReader(p.data)
-	for {
-		h, err := r.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-		dir, _ := filepath.Split(h.Filename)
-		if err := os.MkdirAll(filepath.Join(datadir, dir), 0755); err != nil {
-			return err
-		}
-		w, err := os.Create(filepath.Join(datadir, h.Filename))
-		if err != nil {
-			return err
-		}
-		if _, err := io.CopyN(w, r, h.Length); err != nil {
-			return err
+	defer z.Close()
+	wk := packit.NewWorkers(opts.Jobs)
+	err = func() error {
+		r := cpio.NewReader(z)
+		buf := make([]byte, 32<<10)
+		for {
+			h, err := r.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			name, err := packit.ExtractPath(datadir, h.Filename)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return err
+			}
+			switch h.Mode & 0170000 {
+			case 0100000:
+			case 0040000:
+				if err := os.MkdirAll(name, 0755); err != nil {
+					return err
+				}
+				if opts.Preserve {
+					if err := setAttrs(name, h); err != nil {
+						return err
+					}
+				}
+				continue
+			case 0120000:
+				if h.Length > maxLinkLength {
+					return fmt.Errorf("%s: symlink target too long (%d bytes)", h.Filename, h.Length)
+				}
+				link := make([]byte, h.Length)
+				if _, err := io.ReadFull(r, link); err != nil {
+					return err
+				}
+				if err := packit.ExtractLink(name, string(link)); err != nil {
+					return err
+				}
+				if opts.Preserve && packit.CanChown() {
+					if err := os.Lchown(name, int(h.Uid), int(h.Gid)); err != nil {
+						return err
+					}
+				}
+				continue
+			default:
+				if _, err := io.CopyN(ioutil.Discard, r, h.Length); err != nil {
+					return err
+				}
+				continue
+			}
+			if n, ok := p.sizes[fileKey(h.Filename)]; ok && n != h.Length && h.Mode&0170000 == 0100000 {
+				return fmt.Errorf("%s: size mismatch (%d != %d)", h.Filename, h.Length, n)
+			}
+			if s, ok := p.digests[fileKey(h.Filename)]; opts.Skip && ok && packit.SameDigest(name, s, p.digest()) {
+				if _, err := io.CopyN(ioutil.Discard, r, h.Length); err != nil {
+					return err
+				}
+				continue
+			}
+			if h.Length > extractBuffer {
+				if err := streamFile(name, io.LimitReader(r, h.Length), h, buf, opts.Preserve); err != nil {
+					return err
+				}
+				continue
+			}
+			bs := make([]byte, h.Length)
+			if _, err := io.ReadFull(r, bs); err != nil {
+				return err
+			}
+			err = wk.Go(func() error {
+				return extractFile(name, bs, h, opts.Preserve)
+			})
+			if err != nil {
+				return err
+			}
 		}
-		if err := w.Close(); err != nil {
+	}()
+	if e := wk.Wait(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func streamFile(name string, r io.Reader, h *tape.Header, buf []byte, preserve bool) error {
+	if err := packit.Unlink(name); err != nil {
+		return err
+	}
+	w, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	n, err := io.CopyBuffer(w, r, buf)
+	if err == nil && n != h.Length {
+		err = io.ErrUnexpectedEOF
+	}
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	if err != nil || !preserve {
+		return err
+	}
+	return setAttrs(name, h)
+}
+
+func extractFile(name string, body []byte, h *tape.Header, preserve bool) error {
+	if err := packit.Unlink(name); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(name, body, 0666); err != nil {
+		return err
+	}
+	if !preserve {
+		return nil
+	}
+	return setAttrs(name, h)
+}
+
+func setAttrs(name string, h *tape.Header) error {
+	if err := os.Chmod(name, os.FileMode(h.Mode&07777)); err != nil {
+		return err
+	}
+	if packit.CanChown() {
+		if err := os.Chown(name, int(h.Uid), int(h.Gid)); err != nil {
 			return err
 		}
 	}
-	return nil
+	return os.Chtimes(name, h.ModTime, h.ModTime)
 }
 
-func readMeta(r io.Reader) (*packit.Control, packit.History, error) {
+func readMeta(r io.Reader, p *pkg) error {
 	var (
 		c   packit.Control
 		pay string // payload format, should be cpio
@@ -148,14 +391,78 @@ func readMeta(r io.Reader) (*packit.Control, packit.History, error) {
 		cnames []string
 		clogs  []string
 	)
+	deps := make(map[int32]interface{})
+	scripts := make(map[int32]string)
+	var (
+		flags   []int64
+		indexes []int64
+		bases   []string
+		dirs    []string
+		files   []string
+		digests []string
+		sizes   []int64
+		users   []string
+		groups  []string
+		rdevs   []int64
+		algo    int64
+	)
 	err := readHeader(r, false, func(tag int32, v interface{}) error {
 		switch tag {
+		case rpmTagProvideName, rpmTagProvideVersion, rpmTagProvideFlags:
+			deps[tag] = v
+		case rpmTagRequireName, rpmTagRequireVersion, rpmTagRequireFlags:
+			deps[tag] = v
+		case rpmTagConflictName, rpmTagConflictVersion, rpmTagConflictFlags:
+			deps[tag] = v
+		case rpmTagObsoleteName, rpmTagObsoleteVersion, rpmTagObsoleteFlags:
+			deps[tag] = v
+		case rpmTagSuggestName, rpmTagSuggestVersion, rpmTagSuggestFlags:
+			deps[tag] = v
+		case rpmTagSupplementName, rpmTagSupplementVersion, rpmTagSupplementFlags:
+			deps[tag] = v
+		case rpmTagEnhanceName, rpmTagEnhanceVersion, rpmTagEnhanceFlags:
+			deps[tag] = v
+		case rpmTagPreIn, rpmTagPostIn, rpmTagPreUn, rpmTagPostUn:
+			scripts[tag], _ = v.(string)
+		case rpmTagPreInProg, rpmTagPostInProg, rpmTagPreUnProg, rpmTagPostUnProg:
+			switch x := v.(type) {
+			case string:
+				scripts[tag] = x
+			case []string:
+				if len(x) > 0 {
+					scripts[tag] = x[0]
+				}
+			}
+		case rpmTagFileFlags:
+			flags, _ = v.([]int64)
+		case rpmTagDirIndexes:
+			indexes, _ = v.([]int64)
+		case rpmTagBasenames:
+			bases, _ = v.([]string)
+		case rpmTagDirnames:
+			dirs, _ = v.([]string)
+		case rpmTagFilenames:
+			files, _ = v.([]string)
+		case rpmTagFileDigests:
+			digests, _ = v.([]string)
+		case rpmTagFileSizes:
+			sizes, _ = v.([]int64)
+		case rpmTagFileRdevs:
+			rdevs, _ = v.([]int64)
+		case rpmTagOwners:
+			users, _ = v.([]string)
+		case rpmTagGroups:
+			groups, _ = v.([]string)
+		case rpmTagFileDigestAlgo:
+			if xs, ok := v.([]int64); ok && len(xs) == 1 {
+				algo = xs[0]
+			}
 		case rpmTagChangeTime:
-			ctimes = v.([]int64)
+			ctimes, _ = v.([]int64)
 		case rpmTagChangeName:
-			cnames = v.([]string)
+			cnames, _ = v.([]string)
 		case rpmTagChangeText:
-			clogs = v.([]string)
+			clogs, _ = v.([]string)
 		case rpmTagSize:
 			if xs, ok := v.([]int64); ok && len(xs) == 1 {
 				c.Size = xs[0]
@@ -170,6 +477,10 @@ func readMeta(r io.Reader) (*packit.Control, packit.History, error) {
 			c.Version = v.(string)
 		case rpmTagRelease:
 			c.Release = v.(string)
+		case rpmTagEpoch:
+			if xs, ok := v.([]int64); ok && len(xs) == 1 {
+				c.Epoch = int(xs[0])
+			}
 		case rpmTagSummary:
 			c.Summary = v.(string)
 		case rpmTagDesc:
@@ -193,39 +504,133 @@ func readMeta(r io.Reader) (*packit.Control, packit.History, error) {
 			case "i386":
 				c.Arch = packit.Arch32
 			}
+		case rpmTagSourceDigest:
+			c.SourceDigest, _ = v.(string)
 		case rpmTagPayload:
 			pay = v.(string)
 		case rpmTagCompressor:
-			com = v.(string)
+			if com = v.(string); com == rpmPayloadUncompressed {
+				com = packit.CompressNone
+			}
 		case rpmTagPayloadFlags:
 		}
 		return nil
 	})
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 	var cs []packit.Change
 	for i := 0; i < len(clogs); i++ {
-		c := packit.Change{
-			When: time.Unix(ctimes[i], 0),
-			Body: clogs[i],
+		c := packit.Change{Body: clogs[i]}
+		if i < len(ctimes) {
+			c.When = time.Unix(ctimes[i], 0)
 		}
-		if m, v, err := packit.ParseMaintainerVersion(cnames[i]); err == nil {
-			c.Version, c.Maintainer = v, m
+		if i < len(cnames) {
+			c.Maintainer, c.Version = parseChangeName(cnames[i])
 		}
 		cs = append(cs, c)
 	}
+	depends := func(name, flag, version int32) []string {
+		ns, _ := deps[name].([]string)
+		fs, _ := deps[flag].([]int64)
+		vs, _ := deps[version].([]string)
+		return fieldsToDepends(ns, fs, vs)
+	}
+	c.Suggests = depends(rpmTagSuggestName, rpmTagSuggestFlags, rpmTagSuggestVersion)
+	c.Supplements = depends(rpmTagSupplementName, rpmTagSupplementFlags, rpmTagSupplementVersion)
+	c.Enhances = depends(rpmTagEnhanceName, rpmTagEnhanceFlags, rpmTagEnhanceVersion)
+	c.Depends = depends(rpmTagRequireName, rpmTagRequireFlags, rpmTagRequireVersion)
+	c.Conflicts = depends(rpmTagConflictName, rpmTagConflictFlags, rpmTagConflictVersion)
+	c.Obsoletes = depends(rpmTagObsoleteName, rpmTagObsoleteFlags, rpmTagObsoleteVersion)
+	for _, p := range depends(rpmTagProvideName, rpmTagProvideFlags, rpmTagProvideVersion) {
+		if d := parseDepend(p); d.Name != c.Package {
+			c.Provides = append(c.Provides, p)
+		}
+	}
+
+	if len(bases) > 0 && len(bases) == len(indexes) {
+		files = make([]string, len(bases))
+		for i := range bases {
+			if j := indexes[i]; j >= 0 && int(j) < len(dirs) {
+				files[i] = dirs[j] + bases[i]
+			} else {
+				files[i] = bases[i]
+			}
+		}
+	}
+	for i := 0; i < len(files) && i < len(flags); i++ {
+		if flags[i]&rpmFileConf == rpmFileConf {
+			c.ConfFiles = append(c.ConfFiles, files[i])
+		}
+	}
+	p.digests = make(map[string]string)
+	for i := 0; i < len(files) && i < len(digests); i++ {
+		if digests[i] != "" {
+			p.digests[fileKey(files[i])] = digests[i]
+		}
+	}
+	p.sizes = make(map[string]int64)
+	for i := 0; i < len(files) && i < len(sizes); i++ {
+		p.sizes[fileKey(files[i])] = sizes[i]
+	}
+	p.owners = make(map[string][2]string)
+	for i := 0; i < len(files) && i < len(users) && i < len(groups); i++ {
+		p.owners[fileKey(files[i])] = [2]string{users[i], groups[i]}
+	}
+	p.rdevs = make(map[string]int64)
+	for i := 0; i < len(files) && i < len(rdevs); i++ {
+		if rdevs[i] != 0 {
+			p.rdevs[fileKey(files[i])] = rdevs[i]
+		}
+	}
+	if p.digest = md5.New; algo == rpmHashSha256 {
+		p.digest = sha256.New
+	}
 	if pay != "" && com != "" {
 		c.Format = fmt.Sprintf("%s.%s", pay, com)
 	}
-	return &c, packit.History(cs), nil
+	ss := []struct {
+		Name   string
+		Script int32
+		Prog   int32
+	}{
+		{Name: packit.ScriptPreinst, Script: rpmTagPreIn, Prog: rpmTagPreInProg},
+		{Name: packit.ScriptPostinst, Script: rpmTagPostIn, Prog: rpmTagPostInProg},
+		{Name: packit.ScriptPrerm, Script: rpmTagPreUn, Prog: rpmTagPreUnProg},
+		{Name: packit.ScriptPostrm, Script: rpmTagPostUn, Prog: rpmTagPostUnProg},
+	}
+	for _, s := range ss {
+		if t, ok := scripts[s.Script]; ok && t != "" {
+			if p.scripts == nil {
+				p.scripts = make(map[string]*packit.Script)
+			}
+			p.scripts[s.Name] = packit.NewScript(scripts[s.Prog], t)
+		}
+	}
+	p.control, p.history = &c, packit.History(cs)
+	return nil
+}
+
+func parseChangeName(s string) (*packit.Maintainer, string) {
+	if m, v, err := packit.ParseMaintainerVersion(s); err == nil {
+		return m, v
+	}
+	var v string
+	if ix := strings.LastIndex(s, " - "); ix >= 0 {
+		s, v = s[:ix], strings.TrimSpace(s[ix+3:])
+	}
+	m, _ := packit.ParseMaintainer(s)
+	return m, v
 }
 
-func readSignature(r io.Reader) (*signature, error) {
+func readSignature(r io.Reader, kind uint16) (*signature, error) {
 	s := signature{
 		Payload: -1,
 		Size:    -1,
 	}
+	if kind == rpmSigNone {
+		return &s, nil
+	}
 	return &s, readHeader(r, true, func(tag int32, v interface{}) error {
 		switch tag {
 		case rpmSigSha1:
@@ -238,6 +643,16 @@ func readSignature(r io.Reader) (*signature, error) {
 			if xs, ok := v.([]byte); ok {
 				s.MD5 = hex.EncodeToString(xs)
 			}
+		case rpmSigRSA, rpmSigDSA:
+			s.Header, _ = v.([]byte)
+			if s.HeaderKind = "rsa"; tag == rpmSigDSA {
+				s.HeaderKind = "dsa"
+			}
+		case rpmSigPGP, rpmSigGPG:
+			s.Full, _ = v.([]byte)
+			if s.FullKind = "pgp"; tag == rpmSigGPG {
+				s.FullKind = "gpg"
+			}
 		case rpmSigLength:
 			if xs, ok := v.([]int64); ok && len(xs) == 1 {
 				s.Size = xs[0]
@@ -251,31 +666,71 @@ func readSignature(r io.Reader) (*signature, error) {
 	})
 }
 
-func readData(r io.Reader, format string) (*bytes.Reader, error) {
-	var (
-		z   io.Reader
-		err error
-	)
-	switch format {
-	case "cpio.gz", "cpio.gzip", "":
-		z, err = gzip.NewReader(r)
-	case "cpio.xz":
-		z, err = xz.NewReader(r)
-		// return nil, packit.ErrUnsupportedPayloadFormat
-	default:
-		return nil, packit.ErrMalformedPackage
+// payload returns the uncompressed cpio archive. It is decompressed on the fly
+// from the package file or the ReaderAt the package has been opened from.
+func (p *pkg) payload() (io.ReadCloser, error) {
+	var rc readCloser
+	if p.ra != nil {
+		rc.Reader = io.NewSectionReader(p.ra, p.header[1], p.size-p.header[1])
+	} else {
+		f, err := os.Open(p.file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Seek(p.header[1], io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		rc.Reader, rc.file = f, f
 	}
+	z, err := decompress(rc.Reader, p.control.Format)
 	if err != nil {
+		rc.Close()
 		return nil, err
 	}
-	xs, err := ioutil.ReadAll(z)
+	rc.Reader, rc.z = z, z
+	return rc, nil
+}
+
+type readCloser struct {
+	io.Reader
+	z    io.Closer
+	file *os.File
+}
+
+// Close releases the decompressor of the payload before closing the package
+// file, if any.
+func (r readCloser) Close() error {
+	var err error
+	if r.z != nil {
+		err = r.z.Close()
+	}
+	if r.file != nil {
+		if e := r.file.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func decompress(r io.Reader, format string) (io.ReadCloser, error) {
+	if format != "" && !strings.HasPrefix(format, "cpio.") {
+		return nil, packit.ErrMalformedPackage
+	}
+	return packit.Decompress(strings.TrimPrefix(format, "cpio."), r)
+}
+
+// readData decompresses the payload read from r and gives its size.
+func readData(r io.Reader, format string) (int64, error) {
+	z, err := decompress(r, format)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	return bytes.NewReader(xs), nil
+	defer z.Close()
+	return io.Copy(ioutil.Discard, z)
 }
 
-func readLead(r io.Reader) (string, error) {
+func readLead(r io.Reader) (string, uint16, error) {
 	c := struct {
 		Magic     uint32
 		Major     uint8
@@ -288,18 +743,18 @@ func readLead(r io.Reader) (string, error) {
 		Spare     [16]byte
 	}{}
 	if err := binary.Read(r, binary.BigEndian, &c); err != nil {
-		return "", err
+		return "", 0, err
 	}
 	if c.Magic != binary.BigEndian.Uint32(rpmMagic) {
-		return "", fmt.Errorf("invalid RPM magic: %08x", c.Magic)
+		return "", 0, fmt.Errorf("invalid RPM magic: %08x", c.Magic)
 	}
 	if c.Major != rpmMajor {
-		return "", fmt.Errorf("unsupported RPM version: %d.%d", c.Major, c.Minor)
+		return "", 0, fmt.Errorf("unsupported RPM version: %d.%d", c.Major, c.Minor)
 	}
-	if c.Signature != rpmSigType {
-		return "", fmt.Errorf("invalid RPM signature type: %d", c.Signature)
+	if c.Signature != rpmSigType && c.Signature != rpmSigNone {
+		return "", 0, fmt.Errorf("invalid RPM signature type: %d", c.Signature)
 	}
-	return string(bytes.Trim(c.Name[:], "\x00")), nil
+	return string(bytes.Trim(c.Name[:], "\x00")), c.Signature, nil
 }
 
 func readHeader(r io.Reader, padding bool, fn func(tag int32, v interface{}) error) error {
@@ -319,6 +774,9 @@ func readHeader(r io.Reader, padding bool, fn func(tag int32, v interface{}) err
 	if v := e.Magic & 0xFF; byte(v) != rpmHeader[3] {
 		return fmt.Errorf("unsupported RPM header version: %d", v)
 	}
+	if e.Count < 0 || e.Count > rpmMaxEntries || e.Len < 0 || e.Len > rpmMaxStore {
+		return fmt.Errorf("invalid RPM header: %d entries, %d bytes", e.Count, e.Len)
+	}
 	size := e.Len
 	if m := (e.Len + rpmEntryLen + (e.Count * rpmEntryLen)) % 8; padding && m > 0 {
 		size += 8 - m
@@ -328,13 +786,21 @@ func readHeader(r io.Reader, padding bool, fn func(tag int32, v interface{}) err
 		if err := binary.Read(r, binary.BigEndian, &es[i]); err != nil {
 			return err
 		}
+		if es[i].Offset < 0 || es[i].Offset > size || es[i].Len < 0 {
+			return fmt.Errorf("invalid RPM header entry %d: offset %d, count %d", es[i].Tag, es[i].Offset, es[i].Len)
+		}
 	}
 
-	xs := make([]byte, int(size))
-	if _, err := io.ReadFull(r, xs); err != nil {
+	// the store grows with the bytes actually read so a bogus length can not
+	// make us allocate more than the input holds.
+	var xs bytes.Buffer
+	if _, err := io.CopyN(&xs, r, int64(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return err
 	}
-	stor := bytes.NewReader(xs)
+	stor := bytes.NewReader(xs.Bytes())
 	sort.Slice(es, func(i, j int) bool { return es[i].Offset < es[j].Offset })
 	for i := 0; i < len(es); i++ {
 		e := es[i]
@@ -345,6 +811,9 @@ func readHeader(r io.Reader, padding bool, fn func(tag int32, v interface{}) err
 		if j := i + 1; j < len(es) {
 			n = int(es[j].Offset - es[i].Offset)
 		}
+		if !e.fits(n) {
+			return fmt.Errorf("invalid RPM header entry %d: count %d exceeds %d bytes", e.Tag, e.Len, n)
+		}
 		v, err := e.Decode(io.LimitReader(stor, int64(n)))
 		if err != nil {
 			return err
@@ -366,6 +835,19 @@ type rpmEntry struct {
 	Len    int32
 }
 
+func (e rpmEntry) fits(n int) bool {
+	switch e.Type {
+	case fieldInt16:
+		return int64(e.Len)*2 <= int64(n)
+	case fieldInt32:
+		return int64(e.Len)*4 <= int64(n)
+	case fieldStrArray, fieldBinary:
+		return int(e.Len) <= n
+	default:
+		return true
+	}
+}
+
 func (e rpmEntry) Decode(r io.Reader) (interface{}, error) {
 	var (
 		v   interface{}
@@ -379,8 +861,15 @@ func (e rpmEntry) Decode(r io.Reader) (interface{}, error) {
 		var i int8
 		err, v = binary.Read(r, binary.BigEndian, &i), int64(i)
 	case fieldInt16:
-		var i int16
-		err, v = binary.Read(r, binary.BigEndian, &i), int64(i)
+		vs := make([]int64, e.Len)
+		for i := 0; i < len(vs); i++ {
+			var j uint16
+			if err = binary.Read(r, binary.BigEndian, &j); err != nil {
+				break
+			}
+			vs[i] = int64(j)
+		}
+		v = vs
 	case fieldInt32:
 		vs := make([]int64, e.Len)
 		for i := 0; i < len(vs); i++ {
@@ -431,3 +920,7 @@ func nullSplit(bs []byte, ateof bool) (int, []byte, error) {
 		return copy(xs, bs) + 1, xs, nil
 	}
 }
+
+func fileKey(n string) string {
+	return strings.TrimPrefix(filepath.Clean("/"+n), "/")
+}
@@ -0,0 +1,261 @@
+package rpm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/midbel/packit"
+)
+
+func TestHistory(t *testing.T) {
+	p, err := Open(buildFixture(t, "testdata/changelog.toml", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := p.History().All()
+	if len(cs) != 2 {
+		t.Fatalf("want 2 changelog entries, got %d", len(cs))
+	}
+	for i, want := range []struct {
+		When    time.Time
+		Version string
+		Name    string
+		Email   string
+		Body    string
+	}{
+		{
+			When:    time.Date(2023, 6, 12, 0, 0, 0, 0, time.UTC),
+			Version: "1.5.0-1",
+			Name:    "Jane Packager",
+			Email:   "jane@example.org",
+			Body:    "- probe hosts concurrently",
+		},
+		{
+			When:    time.Date(2023, 3, 3, 0, 0, 0, 0, time.UTC),
+			Version: "1.4.0-1",
+			Name:    "John Builder",
+			Email:   "john@example.org",
+			Body:    "- add ipv6 support\n- drop python2 helpers",
+		},
+	} {
+		c := cs[i]
+		if !c.When.Equal(want.When) || c.Version != want.Version || c.Body != want.Body {
+			t.Errorf("%d: want %s %s %q, got %s %s %q", i, want.When, want.Version, want.Body, c.When, c.Version, c.Body)
+		}
+		if c.Maintainer == nil || c.Maintainer.Name != want.Name || c.Maintainer.Email != want.Email {
+			t.Errorf("%d: want maintainer %s <%s>, got %v", i, want.Name, want.Email, c.Maintainer)
+		}
+	}
+}
+
+func TestParseChangeName(t *testing.T) {
+	for _, d := range []struct {
+		Input   string
+		Name    string
+		Version string
+	}{
+		{Input: "Jane Packager <jane@example.org> - 1.5.0-1", Name: "Jane Packager", Version: "1.5.0-1"},
+		{Input: "Jane Packager <jane@example.org> 1.5.0-1", Name: "Jane Packager", Version: "1.5.0-1"},
+		{Input: "Jane Packager <jane@example.org>", Name: "Jane Packager"},
+		{Input: "build system - 1.5.0-1", Version: "1.5.0-1"},
+	} {
+		m, v := parseChangeName(d.Input)
+		var name string
+		if m != nil {
+			name = m.Name
+		}
+		if name != d.Name || v != d.Version {
+			t.Errorf("%q: want %q %q, got %q %q", d.Input, d.Name, d.Version, name, v)
+		}
+	}
+}
+
+// FuzzReadHeader feeds malformed headers to the index parser. Its seed corpus
+// under testdata/fuzz holds a valid header and mutations of its entry counts,
+// offsets and store length.
+func FuzzReadHeader(f *testing.F) {
+	f.Fuzz(func(t *testing.T, bs []byte) {
+		for _, padding := range []bool{false, true} {
+			var n int
+			readHeader(bytes.NewReader(bs), padding, func(tag int32, v interface{}) error {
+				if n++; n > rpmMaxEntries {
+					t.Fatalf("more than %d entries decoded", rpmMaxEntries)
+				}
+				var z int
+				switch v := v.(type) {
+				case string:
+					z = len(v)
+				case []byte:
+					z = len(v)
+				case []string:
+					for _, s := range v {
+						z += len(s)
+					}
+				}
+				if z > len(bs) {
+					t.Fatalf("tag %d: %d bytes decoded from %d bytes of input", tag, z, len(bs))
+				}
+				return nil
+			})
+		}
+	})
+}
+
+func TestHistoryFilter(t *testing.T) {
+	p, err := Open(buildFixture(t, "testdata/changelog.toml", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		april = time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC)
+		june  = time.Date(2023, 6, 12, 0, 0, 0, 0, time.UTC)
+	)
+	for _, d := range []struct {
+		Who      string
+		From, To time.Time
+		Want     []string
+	}{
+		{Want: []string{"1.5.0-1", "1.4.0-1"}},
+		{Who: "John", Want: []string{"1.4.0-1"}},
+		{Who: "Packager", Want: []string{"1.5.0-1"}},
+		{Who: "nobody"},
+		{From: april, Want: []string{"1.5.0-1"}},
+		{To: april, Want: []string{"1.4.0-1"}},
+		{From: june, To: june, Want: []string{"1.5.0-1"}},
+		{Who: "John", From: april},
+	} {
+		var got []string
+		for _, c := range p.History().Filter(d.Who, d.From, d.To) {
+			got = append(got, c.Version)
+		}
+		if strings.Join(got, " ") != strings.Join(d.Want, " ") {
+			t.Errorf("filter %q %s-%s: want %q, got %q", d.Who, d.From.Format("2006-01-02"), d.To.Format("2006-01-02"), d.Want, got)
+		}
+	}
+}
+
+func TestExtractOutside(t *testing.T) {
+	var (
+		file = buildFixture(t, "testdata/remote.toml", func(mf *packit.Makefile) {
+			mf.Compression = packit.CompressNone
+		})
+		name = []byte("/usr/bin/mirror-sync")
+		evil = []byte("/../../../../../evil")
+	)
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := OpenReaderAt(bytes.NewReader(bs), int64(len(bs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := bs[p.(*pkg).header[1]:]
+	x := bytes.Index(payload, name)
+	if x < 0 {
+		t.Fatalf("%s not found in payload", name)
+	}
+	copy(payload[x:], evil)
+	if p, err = OpenReaderAt(bytes.NewReader(bs), int64(len(bs))); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		tmp     = t.TempDir()
+		datadir = filepath.Join(tmp, "a", "b", "c", "root")
+	)
+	if err := p.Extract(datadir, packit.ExtractOptions{}); err == nil || !strings.Contains(err.Error(), "path outside of") {
+		t.Errorf("extract should fail for entries leaving %s, got %v", datadir, err)
+	}
+	for d := datadir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, "evil")); err == nil {
+			t.Errorf("%s written outside of root", filepath.Join(d, "evil"))
+		}
+		if d == tmp {
+			break
+		}
+	}
+}
+
+func TestExtractLongLink(t *testing.T) {
+	bs, err := ioutil.ReadFile(buildFixture(t, "testdata/remote.toml", func(mf *packit.Makefile) {
+		mf.Compression = packit.CompressNone
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := []byte("/usr/bin/mirror-sync\x00")
+	p, err := OpenReaderAt(bytes.NewReader(bs), int64(len(bs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := bs[p.(*pkg).header[1]:]
+	x := bytes.Index(payload, name) - 110
+	if x < 0 || string(payload[x:x+6]) != "070701" {
+		t.Fatalf("cpio header of %s not found in payload", name)
+	}
+	// turn the entry into a symlink whose target claims to be 2GB long.
+	copy(payload[x+14:], "0000a1ff")
+	copy(payload[x+54:], "7fffffff")
+	if p, err = OpenReaderAt(bytes.NewReader(bs), int64(len(bs))); err != nil {
+		t.Fatal(err)
+	}
+	err = p.Extract(t.TempDir(), packit.ExtractOptions{})
+	if err == nil || !strings.Contains(err.Error(), "symlink target too long") {
+		t.Errorf("extract should reject symlink targets longer than PATH_MAX, got %v", err)
+	}
+}
+
+func TestMultistreamPayload(t *testing.T) {
+	bs, err := ioutil.ReadFile(buildFixture(t, "testdata/remote.toml", func(mf *packit.Makefile) {
+		mf.Compression = packit.CompressGZ
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := OpenReaderAt(bytes.NewReader(bs), int64(len(bs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := p.(*pkg).header[1]
+	z, err := gzip.NewReader(bytes.NewReader(bs[offset:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// recompress the cpio archive as two gzip members cut in its middle
+	payload := bytes.NewBuffer(append([]byte{}, bs[:offset]...))
+	for _, part := range [][]byte{archive[:len(archive)/2], archive[len(archive)/2:]} {
+		w := gzip.NewWriter(payload)
+		w.Write(part)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bs = payload.Bytes()
+	if p, err = OpenReaderAt(bytes.NewReader(bs), int64(len(bs))); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := p.Resources()
+	if err != nil {
+		t.Fatalf("two member payload: %s", err)
+	}
+	want := map[string]struct{}{
+		"/usr/bin/mirror-sync":          {},
+		"/etc/mirror-tools/mirror.conf": {},
+	}
+	for _, r := range rs {
+		delete(want, r.Name)
+	}
+	for n := range want {
+		t.Errorf("%s: not read from the second gzip member", n)
+	}
+}
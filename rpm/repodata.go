@@ -0,0 +1,333 @@
+package rpm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	repoCommonNS    = "http://linux.duke.edu/metadata/common"
+	repoRpmNS       = "http://linux.duke.edu/metadata/rpm"
+	repoFilelistsNS = "http://linux.duke.edu/metadata/filelists"
+	repoMetaNS      = "http://linux.duke.edu/metadata/repo"
+	repoDir         = "repodata"
+)
+
+type repoVersion struct {
+	Epoch   int    `xml:"epoch,attr"`
+	Version string `xml:"ver,attr"`
+	Release string `xml:"rel,attr"`
+}
+
+type repoChecksum struct {
+	Type  string `xml:"type,attr"`
+	PkgId string `xml:"pkgid,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type repoEntry struct {
+	Name    string `xml:"name,attr"`
+	Flags   string `xml:"flags,attr,omitempty"`
+	Epoch   string `xml:"epoch,attr,omitempty"`
+	Version string `xml:"ver,attr,omitempty"`
+	Release string `xml:"rel,attr,omitempty"`
+}
+
+type repoFormat struct {
+	License string `xml:"rpm:license"`
+	Vendor  string `xml:"rpm:vendor"`
+	Group   string `xml:"rpm:group"`
+	Header  struct {
+		Start int64 `xml:"start,attr"`
+		End   int64 `xml:"end,attr"`
+	} `xml:"rpm:header-range"`
+	Provides  []repoEntry `xml:"rpm:provides>rpm:entry"`
+	Requires  []repoEntry `xml:"rpm:requires>rpm:entry"`
+	Conflicts []repoEntry `xml:"rpm:conflicts>rpm:entry"`
+	Obsoletes []repoEntry `xml:"rpm:obsoletes>rpm:entry"`
+	Files     []string    `xml:"file"`
+}
+
+type repoPackage struct {
+	Type     string       `xml:"type,attr"`
+	Name     string       `xml:"name"`
+	Arch     string       `xml:"arch"`
+	Version  repoVersion  `xml:"version"`
+	Checksum repoChecksum `xml:"checksum"`
+	Summary  string       `xml:"summary"`
+	Desc     string       `xml:"description"`
+	Packager string       `xml:"packager"`
+	URL      string       `xml:"url"`
+	Time     struct {
+		File  int64 `xml:"file,attr"`
+		Build int64 `xml:"build,attr"`
+	} `xml:"time"`
+	Size struct {
+		Package   int64 `xml:"package,attr"`
+		Installed int64 `xml:"installed,attr"`
+		Archive   int64 `xml:"archive,attr"`
+	} `xml:"size"`
+	Location struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+	Format repoFormat `xml:"format"`
+
+	files []string
+}
+
+type repoFilelist struct {
+	PkgId   string      `xml:"pkgid,attr"`
+	Name    string      `xml:"name,attr"`
+	Arch    string      `xml:"arch,attr"`
+	Version repoVersion `xml:"version"`
+	Files   []string    `xml:"file"`
+}
+
+type repoData struct {
+	Type     string       `xml:"type,attr"`
+	Checksum repoChecksum `xml:"checksum"`
+	Open     repoChecksum `xml:"open-checksum"`
+	Location struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+	Timestamp int64 `xml:"timestamp"`
+	Size      int   `xml:"size"`
+	OpenSize  int   `xml:"open-size"`
+}
+
+// Index writes the primary and filelists metadata of the given packages
+// with the repomd.xml referencing them under the repodata directory of base.
+func Index(base string, files []string) error {
+	var ps []repoPackage
+	for _, f := range files {
+		p, err := indexPackage(base, f)
+		if err != nil {
+			return fmt.Errorf("%s: %s", f, err)
+		}
+		ps = append(ps, p)
+	}
+	primary := struct {
+		XMLName  xml.Name      `xml:"metadata"`
+		NS       string        `xml:"xmlns,attr"`
+		RpmNS    string        `xml:"xmlns:rpm,attr"`
+		Count    int           `xml:"packages,attr"`
+		Packages []repoPackage `xml:"package"`
+	}{
+		NS:       repoCommonNS,
+		RpmNS:    repoRpmNS,
+		Count:    len(ps),
+		Packages: ps,
+	}
+	lists := struct {
+		XMLName  xml.Name       `xml:"filelists"`
+		NS       string         `xml:"xmlns,attr"`
+		Count    int            `xml:"packages,attr"`
+		Packages []repoFilelist `xml:"package"`
+	}{
+		NS:    repoFilelistsNS,
+		Count: len(ps),
+	}
+	for _, p := range ps {
+		f := repoFilelist{
+			PkgId:   p.Checksum.Value,
+			Name:    p.Name,
+			Arch:    p.Arch,
+			Version: p.Version,
+			Files:   p.files,
+		}
+		lists.Packages = append(lists.Packages, f)
+	}
+	dir := filepath.Join(base, repoDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	repomd := struct {
+		XMLName  xml.Name   `xml:"repomd"`
+		NS       string     `xml:"xmlns,attr"`
+		RpmNS    string     `xml:"xmlns:rpm,attr"`
+		Revision int64      `xml:"revision"`
+		Data     []repoData `xml:"data"`
+	}{
+		NS:       repoMetaNS,
+		RpmNS:    repoRpmNS,
+		Revision: now,
+	}
+	ms := []struct {
+		Type string
+		Data interface{}
+	}{
+		{Type: "primary", Data: primary},
+		{Type: "filelists", Data: lists},
+	}
+	for _, m := range ms {
+		d, err := writeRepoFile(dir, m.Type, m.Data)
+		if err != nil {
+			return err
+		}
+		d.Timestamp = now
+		repomd.Data = append(repomd.Data, d)
+	}
+	var body bytes.Buffer
+	if err := writeXML(&body, repomd); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "repomd.xml"), body.Bytes(), 0644)
+}
+
+func writeRepoFile(dir, kind string, v interface{}) (repoData, error) {
+	var (
+		d       = repoData{Type: kind}
+		raw, gz bytes.Buffer
+		name    = kind + ".xml.gz"
+	)
+	if err := writeXML(&raw, v); err != nil {
+		return d, err
+	}
+	z, _ := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	if _, err := z.Write(raw.Bytes()); err != nil {
+		return d, err
+	}
+	if err := z.Close(); err != nil {
+		return d, err
+	}
+	d.Open = repoChecksum{Type: "sha256", Value: sha256Hex(raw.Bytes())}
+	d.Checksum = repoChecksum{Type: "sha256", Value: sha256Hex(gz.Bytes())}
+	d.Location.Href = repoDir + "/" + name
+	d.Size, d.OpenSize = gz.Len(), raw.Len()
+
+	return d, ioutil.WriteFile(filepath.Join(dir, name), gz.Bytes(), 0644)
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	io.WriteString(w, xml.Header)
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func sha256Hex(bs []byte) string {
+	s := sha256.Sum256(bs)
+	return hex.EncodeToString(s[:])
+}
+
+func indexPackage(base, file string) (repoPackage, error) {
+	var rp repoPackage
+	x, err := Open(file)
+	if err != nil {
+		return rp, err
+	}
+	p := x.(*pkg)
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return rp, err
+	}
+	s, err := os.Stat(file)
+	if err != nil {
+		return rp, err
+	}
+	fs, err := p.Filenames()
+	if err != nil {
+		return rp, err
+	}
+	rel, err := filepath.Rel(base, file)
+	if err != nil {
+		rel = filepath.Base(file)
+	}
+	c := p.control
+
+	rp.Type = "rpm"
+	rp.Name, rp.Arch = c.Package, Arch(c.Arch)
+	rp.Version = repoVersion{Epoch: c.Epoch, Version: c.Version, Release: c.Release}
+	rp.Checksum = repoChecksum{Type: "sha256", PkgId: "YES", Value: sha256Hex(bs)}
+	rp.Summary, rp.Desc, rp.URL = c.Summary, c.Desc, c.Home
+	if c.Maintainer != nil {
+		rp.Packager = c.Maintainer.String()
+	}
+	rp.Time.File, rp.Time.Build = s.ModTime().Unix(), c.Date.Unix()
+	rp.Size.Package, rp.Size.Installed = s.Size(), c.Size
+	if p.archive >= 0 {
+		rp.Size.Archive = p.archive
+	}
+	rp.Location.Href = filepath.ToSlash(rel)
+
+	rp.Format.License, rp.Format.Vendor, rp.Format.Group = c.License, c.Vendor, c.Section
+	rp.Format.Header.Start, rp.Format.Header.End = p.header[0], p.header[1]
+	self := repoEntry{
+		Name:    c.Package,
+		Flags:   "EQ",
+		Epoch:   fmt.Sprint(c.Epoch),
+		Version: c.Version,
+		Release: c.Release,
+	}
+	rp.Format.Provides = append(rp.Format.Provides, self)
+	rp.Format.Provides = append(rp.Format.Provides, repoEntries(c.Provides)...)
+	rp.Format.Requires = repoEntries(c.Depends)
+	rp.Format.Conflicts = repoEntries(c.Conflicts)
+	rp.Format.Obsoletes = repoEntries(c.Obsoletes)
+	for _, f := range fs {
+		f = "/" + strings.TrimPrefix(filepath.Clean("/"+f), "/")
+		rp.files = append(rp.files, f)
+		if isPrimaryFile(f) {
+			rp.Format.Files = append(rp.Format.Files, f)
+		}
+	}
+	return rp, nil
+}
+
+func repoEntries(vs []string) []repoEntry {
+	var es []repoEntry
+	for _, d := range parseDepends(vs) {
+		e := repoEntry{Name: d.Name}
+		if d.Version != "" {
+			e.Flags = repoFlags(d.Flags)
+			e.Epoch = "0"
+			if i := strings.Index(d.Version, ":"); i >= 0 {
+				e.Epoch, d.Version = d.Version[:i], d.Version[i+1:]
+			}
+			if i := strings.LastIndex(d.Version, "-"); i >= 0 {
+				e.Version, e.Release = d.Version[:i], d.Version[i+1:]
+			} else {
+				e.Version = d.Version
+			}
+		}
+		es = append(es, e)
+	}
+	return es
+}
+
+func repoFlags(f int64) string {
+	switch f & (rpmSenseLess | rpmSenseGreater | rpmSenseEqual) {
+	case rpmSenseLess:
+		return "LT"
+	case rpmSenseLess | rpmSenseEqual:
+		return "LE"
+	case rpmSenseGreater:
+		return "GT"
+	case rpmSenseGreater | rpmSenseEqual:
+		return "GE"
+	default:
+		return "EQ"
+	}
+}
+
+func isPrimaryFile(f string) bool {
+	if strings.HasPrefix(f, "/etc/") || f == "/usr/lib/sendmail" {
+		return true
+	}
+	return strings.Contains(f, "bin/")
+}
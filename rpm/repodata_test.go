@@ -0,0 +1,197 @@
+package rpm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type repoEntryXML struct {
+	Name    string `xml:"name,attr"`
+	Flags   string `xml:"flags,attr"`
+	Epoch   string `xml:"epoch,attr"`
+	Version string `xml:"ver,attr"`
+	Release string `xml:"rel,attr"`
+}
+
+type primaryXML struct {
+	XMLName  xml.Name `xml:"http://linux.duke.edu/metadata/common metadata"`
+	Count    int      `xml:"packages,attr"`
+	Packages []struct {
+		Type    string `xml:"type,attr"`
+		Name    string `xml:"name"`
+		Arch    string `xml:"arch"`
+		Version struct {
+			Epoch   string `xml:"epoch,attr"`
+			Version string `xml:"ver,attr"`
+			Release string `xml:"rel,attr"`
+		} `xml:"version"`
+		Checksum struct {
+			Type  string `xml:"type,attr"`
+			PkgId string `xml:"pkgid,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"checksum"`
+		Summary string `xml:"summary"`
+		Size    struct {
+			Package int64 `xml:"package,attr"`
+		} `xml:"size"`
+		Location struct {
+			Href string `xml:"href,attr"`
+		} `xml:"location"`
+		Format struct {
+			License  string         `xml:"http://linux.duke.edu/metadata/rpm license"`
+			Group    string         `xml:"http://linux.duke.edu/metadata/rpm group"`
+			Provides []repoEntryXML `xml:"http://linux.duke.edu/metadata/rpm provides>entry"`
+			Requires []repoEntryXML `xml:"http://linux.duke.edu/metadata/rpm requires>entry"`
+			Files    []string       `xml:"file"`
+		} `xml:"format"`
+	} `xml:"package"`
+}
+
+type filelistsXML struct {
+	XMLName  xml.Name `xml:"http://linux.duke.edu/metadata/filelists filelists"`
+	Count    int      `xml:"packages,attr"`
+	Packages []struct {
+		PkgId string   `xml:"pkgid,attr"`
+		Name  string   `xml:"name,attr"`
+		Files []string `xml:"file"`
+	} `xml:"package"`
+}
+
+type repomdXML struct {
+	XMLName xml.Name `xml:"http://linux.duke.edu/metadata/repo repomd"`
+	Data    []struct {
+		Type     string `xml:"type,attr"`
+		Checksum string `xml:"checksum"`
+		Open     string `xml:"open-checksum"`
+		Location struct {
+			Href string `xml:"href,attr"`
+		} `xml:"location"`
+		Size     int `xml:"size"`
+		OpenSize int `xml:"open-size"`
+	} `xml:"data"`
+}
+
+func TestIndex(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		files []string
+		sums  = make(map[string]string)
+	)
+	for _, f := range []string{"testdata/remote.toml", "testdata/requires.toml"} {
+		bs, err := ioutil.ReadFile(buildFixture(t, f, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(dir, strings.TrimSuffix(filepath.Base(f), ".toml")+".rpm")
+		if err := ioutil.WriteFile(name, bs, 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, name)
+		sums[filepath.Base(name)] = sha256Hex(bs)
+	}
+	if err := Index(dir, files); err != nil {
+		t.Fatalf("index: %s", err)
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, repoDir, "repomd.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var md repomdXML
+	if err := xml.Unmarshal(bs, &md); err != nil {
+		t.Fatalf("repomd.xml: %s", err)
+	}
+	open := make(map[string][]byte)
+	for _, d := range md.Data {
+		gz, err := ioutil.ReadFile(filepath.Join(dir, d.Location.Href))
+		if err != nil {
+			t.Errorf("%s: %s", d.Type, err)
+			continue
+		}
+		if len(gz) != d.Size || sha256Hex(gz) != d.Checksum {
+			t.Errorf("%s: size/checksum mismatch with repomd.xml", d.Type)
+		}
+		r, err := gzip.NewReader(bytes.NewReader(gz))
+		if err != nil {
+			t.Errorf("%s: %s", d.Type, err)
+			continue
+		}
+		raw, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("%s: %s", d.Type, err)
+			continue
+		}
+		if len(raw) != d.OpenSize || sha256Hex(raw) != d.Open {
+			t.Errorf("%s: open size/checksum mismatch with repomd.xml", d.Type)
+		}
+		open[d.Type] = raw
+	}
+
+	var primary primaryXML
+	if err := xml.Unmarshal(open["primary"], &primary); err != nil {
+		t.Fatalf("primary.xml: %s", err)
+	}
+	if primary.Count != 2 || len(primary.Packages) != 2 {
+		t.Fatalf("primary.xml: want 2 packages, got %d (%d)", len(primary.Packages), primary.Count)
+	}
+	for _, p := range primary.Packages {
+		if p.Type != "rpm" || p.Checksum.Type != "sha256" || p.Checksum.PkgId != "YES" {
+			t.Errorf("%s: bad package type/checksum attributes", p.Name)
+		}
+		if want := sums[p.Location.Href]; p.Checksum.Value != want {
+			t.Errorf("%s: want checksum %s, got %s", p.Name, want, p.Checksum.Value)
+		}
+		if len(p.Format.Provides) == 0 || p.Format.Provides[0].Name != p.Name || p.Format.Provides[0].Flags != "EQ" {
+			t.Errorf("%s: package does not provide itself", p.Name)
+		}
+		switch p.Name {
+		case "mirror-tools":
+			if p.Version.Version != "0.9.2" || p.Version.Release != "5" {
+				t.Errorf("%s: bad version %+v", p.Name, p.Version)
+			}
+			if p.Format.License != "Apache-2.0" || p.Format.Group != "System Environment/Base" {
+				t.Errorf("%s: bad license/group %s/%s", p.Name, p.Format.License, p.Format.Group)
+			}
+			want := "/usr/bin/mirror-sync /etc/mirror-tools/mirror.conf"
+			if got := strings.Join(p.Format.Files, " "); got != want {
+				t.Errorf("%s: want primary files %q, got %q", p.Name, want, got)
+			}
+		case "netcheck":
+			if p.Summary != "check network reachability" {
+				t.Errorf("%s: bad summary %q", p.Name, p.Summary)
+			}
+			var libcap *repoEntryXML
+			for i, e := range p.Format.Requires {
+				if e.Name == "libcap2" {
+					libcap = &p.Format.Requires[i]
+				}
+			}
+			if libcap == nil || libcap.Flags != "GE" || libcap.Epoch != "1" || libcap.Version != "2.25" {
+				t.Errorf("%s: bad libcap2 requirement %+v", p.Name, libcap)
+			}
+		default:
+			t.Errorf("unexpected package %s", p.Name)
+		}
+	}
+
+	var lists filelistsXML
+	if err := xml.Unmarshal(open["filelists"], &lists); err != nil {
+		t.Fatalf("filelists.xml: %s", err)
+	}
+	if lists.Count != 2 || len(lists.Packages) != 2 {
+		t.Fatalf("filelists.xml: want 2 packages, got %d (%d)", len(lists.Packages), lists.Count)
+	}
+	for i, p := range lists.Packages {
+		if p.Name != primary.Packages[i].Name || p.PkgId != primary.Packages[i].Checksum.Value {
+			t.Errorf("%s: filelists entry does not match primary", p.Name)
+		}
+		if len(p.Files) == 0 {
+			t.Errorf("%s: no files listed", p.Name)
+		}
+	}
+}
@@ -28,6 +28,42 @@ func Arch(a uint8) string {
 	}
 }
 
+func init() {
+	f := packit.Format{
+		Name:        "rpm",
+		Ext:         ".rpm",
+		Magic:       rpmMagic,
+		Signing:     true,
+		Compression: []string{packit.CompressGZ, packit.CompressXZ, packit.CompressZstd, packit.CompressNone, packit.CompressAuto},
+		Lossy:       []string{"priority", "compiler", "origin", "bugs", "breaks", "replaces"},
+		Weak:        []string{"suggests", "enhances", "supplements"},
+		Fields: map[string]string{
+			"package":     TagName(rpmTagPackage),
+			"epoch":       TagName(rpmTagEpoch),
+			"version":     TagName(rpmTagVersion),
+			"release":     TagName(rpmTagRelease),
+			"summary":     TagName(rpmTagSummary),
+			"description": TagName(rpmTagDesc),
+			"license":     TagName(rpmTagLicense),
+			"section":     TagName(rpmTagGroup),
+			"arch":        TagName(rpmTagArch),
+			"vendor":      TagName(rpmTagVendor),
+			"homepage":    TagName(rpmTagURL),
+			"maintainer":  TagName(rpmTagPackager),
+			"depends":     TagName(rpmTagRequireName),
+			"suggests":    TagName(rpmTagSuggestName),
+			"enhances":    TagName(rpmTagEnhanceName),
+			"supplements": TagName(rpmTagSupplementName),
+			"provides":    TagName(rpmTagProvideName),
+			"conflicts":   TagName(rpmTagConflictName),
+			"obsoletes":   TagName(rpmTagObsoleteName),
+		},
+	}
+	packit.RegisterFormat(f)
+	packit.RegisterBuilder(f.Name, Build)
+	packit.RegisterReader(f.Name, Open)
+}
+
 func Build(mf *packit.Makefile) (packit.Builder, error) {
 	if mf == nil {
 		return nil, fmt.Errorf("empty makefile")
@@ -37,6 +73,43 @@ func Build(mf *packit.Makefile) (packit.Builder, error) {
 		control: mf.Control,
 		files:   mf.Files,
 		changes: mf.Changes,
+		nomd5:   mf.NoMD5,
+		scripts: []*packit.Script{mf.Preinst, mf.Postinst, mf.Prerm, mf.Postrm},
+		weak:    mf.WeakDeps,
+		signer:  mf.Signer,
+	}
+	b.compress = packit.Compressor{
+		Method:  mf.Compression,
+		Check:   mf.XZCheck,
+		Threads: mf.Threads,
+		ModTime: b.when,
+	}
+	if err := b.compress.Valid(); err != nil {
+		return nil, err
+	}
+	if err := mf.Expand(); err != nil {
+		return nil, err
+	}
+	b.files = mf.Files
+	if b.control != nil {
+		if err := b.control.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if b.control != nil {
+		if err := checkGroup(b.control.Section); err != nil {
+			if mf.Strict {
+				return nil, err
+			}
+			b.warnings = append(b.warnings, err.Error())
+		}
+	}
+	if b.control != nil && mf.DigestSources {
+		d, err := mf.Digest()
+		if err != nil {
+			return nil, err
+		}
+		b.control.SourceDigest = d
 	}
 	return &b, nil
 }
@@ -48,7 +121,20 @@ func About(file string) (*packit.Control, error) {
 	}
 	defer r.Close()
 
-	return nil, nil
+	var (
+		p    pkg
+		kind uint16
+	)
+	if p.name, kind, err = readLead(r); err != nil {
+		return nil, err
+	}
+	if _, err = readSignature(r, kind); err != nil {
+		return nil, err
+	}
+	if err = readMeta(r, &p); err != nil {
+		return nil, err
+	}
+	return p.control, nil
 }
 
 func Open(file string) (packit.Package, error) {
@@ -59,41 +145,75 @@ func Open(file string) (packit.Package, error) {
 	defer r.Close()
 
 	var (
-		p pkg
-		s *signature
+		p    = pkg{file: file}
+		s    *signature
+		kind uint16
 	)
-	if p.name, err = readLead(r); err != nil {
+	if p.name, kind, err = readLead(r); err != nil {
+		return nil, err
+	}
+	if s, err = readSignature(r, kind); err != nil {
 		return nil, err
 	}
-	if s, err = readSignature(r); err != nil {
+	if p.header[0], err = r.Seek(0, io.SeekCurrent); err != nil {
 		return nil, err
 	}
 	md, sh1, sh2 := md5.New(), sha1.New(), sha256.New()
 	total := counter(0)
 	rw := io.TeeReader(r, io.MultiWriter(md, sh2, &total))
-	if p.control, p.history, err = readMeta(io.TeeReader(rw, sh1)); err != nil {
+	if err = readMeta(io.TeeReader(rw, sh1), &p); err != nil {
 		return nil, err
 	}
+	p.header[1] = p.header[0] + total.Size()
 	if s.Sha1 != "" && s.Sha1 != hex.EncodeToString(sh1.Sum(nil)) {
 		return nil, invalidSignature(p.name, "header", "sha1")
 	}
-	if p.data, err = readData(rw, p.control.Format); err != nil {
-		if err != packit.ErrUnsupportedPayloadFormat {
-			return nil, err
-		}
-	} else {
-		if z := p.data.Size(); s.Payload >= 0 && int64(z) != s.Payload {
-			return nil, fmt.Errorf("invalid payload size (expected %d, got %d)", s.Payload, z)
-		}
-		if z := total.Size(); s.Size >= 0 && z != s.Size {
-			return nil, fmt.Errorf("invalid size (expected %d, got %d)", s.Size, z)
-		}
-		if s.MD5 != "" && s.MD5 != hex.EncodeToString(md.Sum(nil)) {
-			return nil, invalidSignature(p.name, "package", "md5")
-		}
-		if s.Sha256 != "" && s.Sha256 != hex.EncodeToString(sh2.Sum(nil)) {
-			return nil, invalidSignature(p.name, "package", "sha256")
-		}
+	if p.archive, err = readData(rw, p.control.Format); err != nil {
+		return nil, err
+	}
+	if z := p.archive; s.Payload >= 0 && z != s.Payload {
+		return nil, fmt.Errorf("invalid payload size (expected %d, got %d)", s.Payload, z)
+	}
+	if z := total.Size(); s.Size >= 0 && z != s.Size {
+		return nil, fmt.Errorf("invalid size (expected %d, got %d)", s.Size, z)
+	}
+	if s.MD5 != "" && s.MD5 != hex.EncodeToString(md.Sum(nil)) {
+		return nil, invalidSignature(p.name, "package", "md5")
+	}
+	if s.Sha256 != "" && s.Sha256 != hex.EncodeToString(sh2.Sum(nil)) {
+		return nil, invalidSignature(p.name, "package", "sha256")
+	}
+	return &p, nil
+}
+
+// OpenReaderAt reads the lead and headers of the package found in r. The
+// payload is only read when the files of the package are requested.
+func OpenReaderAt(r io.ReaderAt, size int64) (packit.Package, error) {
+	var (
+		p    = pkg{ra: r, size: size, archive: -1}
+		rs   = io.NewSectionReader(r, 0, size)
+		s    *signature
+		kind uint16
+		err  error
+	)
+	if p.name, kind, err = readLead(rs); err != nil {
+		return nil, err
+	}
+	if s, err = readSignature(rs, kind); err != nil {
+		return nil, err
+	}
+	if p.header[0], err = rs.Seek(0, io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	sh1 := sha1.New()
+	if err = readMeta(io.TeeReader(rs, sh1), &p); err != nil {
+		return nil, err
+	}
+	if p.header[1], err = rs.Seek(0, io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	if s.Sha1 != "" && s.Sha1 != hex.EncodeToString(sh1.Sum(nil)) {
+		return nil, invalidSignature(p.name, "header", "sha1")
 	}
 	return &p, nil
 }
@@ -102,6 +222,11 @@ func invalidSignature(n, w, t string) error {
 	return fmt.Errorf("%s (%s): invalid signature (%s)", n, w, t)
 }
 
+const (
+	rpmMaxEntries = 0xFFFF
+	rpmMaxStore   = 256 << 20
+)
+
 type counter int64
 
 func (c *counter) Size() int64 {
@@ -133,10 +258,10 @@ func fileFlags(file *packit.File) int32 {
 		f |= rpmFileDoc
 	}
 	if file.License {
-		return rpmFileLicense
+		f |= rpmFileLicense
 	}
 	if file.Readme {
-		return rpmFileReadme
+		f |= rpmFileReadme
 	}
 	return f
 }
@@ -150,6 +275,7 @@ const (
 	rpmMajor    = 3
 	rpmMinor    = 0
 	rpmBinary   = 0
+	rpmSigNone  = 0
 	rpmSigType  = 5
 	rpmEntryLen = 16
 	rpmLeadLen  = 96
@@ -160,17 +286,29 @@ const (
 	rpmTagImmutableIndex = 63
 )
 
+const rpmPayloadFormat = "cpio"
+
+// rpmPayloadUncompressed is the name of the rpmio type reading a payload as
+// is. rpm does not know none as a compressor.
+const rpmPayloadUncompressed = "ufdio"
+
+func payloadCompressor(c packit.Compressor) string {
+	if c.Name() == packit.CompressNone {
+		return rpmPayloadUncompressed
+	}
+	return c.Name()
+}
+
 const (
-	rpmPayloadFormat     = "cpio"
-	rpmPayloadCompressor = "gzip"
-	rpmPayloadFlags      = "9"
+	rpmHashSha256 = 8
 )
 
 const (
-	rpmSigBase = 256
-	// rpmSigPGP     = 1002
-	// rpmSigDSA     = rpmSigBase + 11
-	// rpmSigRSA     = rpmSigBase + 12
+	rpmSigBase    = 256
+	rpmSigPGP     = 1002
+	rpmSigGPG     = 1005
+	rpmSigDSA     = rpmSigBase + 11
+	rpmSigRSA     = rpmSigBase + 12
 	rpmSigSha1    = rpmSigBase + 13
 	rpmSigSha256  = rpmSigBase + 17
 	rpmSigLength  = 1000
@@ -178,10 +316,15 @@ const (
 	rpmSigPayload = 1007
 )
 
+const rpmTagI18NTable = 100
+
+var rpmLocales = []string{"C"}
+
 const (
 	rpmTagPackage      = 1000
 	rpmTagVersion      = 1001
 	rpmTagRelease      = 1002
+	rpmTagEpoch        = 1003
 	rpmTagSummary      = 1004
 	rpmTagDesc         = 1005
 	rpmTagBuildTime    = 1006
@@ -204,13 +347,14 @@ const (
 const (
 	rpmTagFileSizes   = 1028
 	rpmTagFileModes   = 1030
-	rpmTagFileDevs    = 1033
+	rpmTagFileRdevs   = 1033
 	rpmTagFileTimes   = 1034
 	rpmTagFileDigests = 1035
 	rpmTagFileLinks   = 1036
 	rpmTagFileFlags   = 1037
 	rpmTagOwners      = 1039
 	rpmTagGroups      = 1040
+	rpmTagFileDevices = 1095
 	rpmTagFileInodes  = 1096
 	rpmTagFileLangs   = 1097
 	rpmTagDirIndexes  = 1116
@@ -218,6 +362,44 @@ const (
 	rpmTagDirnames    = 1118
 )
 
+const (
+	rpmTagPreIn      = 1023
+	rpmTagPostIn     = 1024
+	rpmTagPreUn      = 1025
+	rpmTagPostUn     = 1026
+	rpmTagPreInProg  = 1085
+	rpmTagPostInProg = 1086
+	rpmTagPreUnProg  = 1087
+	rpmTagPostUnProg = 1088
+)
+
+const (
+	rpmTagProvideName     = 1047
+	rpmTagProvideFlags    = 1112
+	rpmTagProvideVersion  = 1113
+	rpmTagRequireFlags    = 1048
+	rpmTagRequireName     = 1049
+	rpmTagRequireVersion  = 1050
+	rpmTagConflictFlags   = 1053
+	rpmTagConflictName    = 1054
+	rpmTagConflictVersion = 1055
+	rpmTagObsoleteName    = 1090
+	rpmTagObsoleteFlags   = 1114
+	rpmTagObsoleteVersion = 1115
+)
+
+const (
+	rpmTagSuggestName       = 5049
+	rpmTagSuggestVersion    = 5050
+	rpmTagSuggestFlags      = 5051
+	rpmTagSupplementName    = 5052
+	rpmTagSupplementVersion = 5053
+	rpmTagSupplementFlags   = 5054
+	rpmTagEnhanceName       = 5055
+	rpmTagEnhanceVersion    = 5056
+	rpmTagEnhanceFlags      = 5057
+)
+
 const (
 	rpmTagChangeTime = 1080
 	rpmTagChangeName = 1081
@@ -225,11 +407,18 @@ const (
 )
 
 const (
-	rpmTagFilenames = 5000
-	rpmTagBugURL    = 5012
-	rpmTagEncoding  = 5068
+	rpmTagFilenames         = 5000
+	rpmTagFileDigestAlgo    = 5011
+	rpmTagPayloadDigest     = 5092
+	rpmTagPayloadDigestAlgo = 5093
+	rpmTagBugURL            = 5012
+	rpmTagEncoding          = 5068
 )
 
+// rpmTagSourceDigest is private to packit: it is not defined by rpm and is
+// numbered far above the tags allocated in rpmtag.h to never collide with them.
+const rpmTagSourceDigest = 100000
+
 type fieldType uint32
 
 func (f fieldType) String() string {
@@ -375,6 +564,24 @@ func (a strarray) Bytes() []byte {
 	return b.Bytes()
 }
 
+type i18n struct {
+	tag   int32
+	Value string
+}
+
+func (i i18n) Skip() bool      { return len(i.Value) == 0 }
+func (i i18n) Tag() int32      { return i.tag }
+func (i i18n) Type() fieldType { return fieldI18NString }
+func (i i18n) Len() int32      { return int32(len(rpmLocales)) }
+func (i i18n) Bytes() []byte {
+	var b bytes.Buffer
+	for range rpmLocales {
+		io.WriteString(&b, i.Value)
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
 type index struct {
 	tag   int32
 	Value int32
@@ -0,0 +1,886 @@
+package rpm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/midbel/packit"
+	"github.com/midbel/tape/cpio"
+	"github.com/ulikunitz/xz"
+	"golang.org/x/crypto/openpgp"
+)
+
+func buildFixture(t *testing.T, file string, fn func(*packit.Makefile)) string {
+	t.Helper()
+	mf, err := packit.Load(file)
+	if err != nil {
+		t.Fatalf("%s: %s", file, err)
+	}
+	if fn != nil {
+		fn(mf)
+	}
+	b, err := Build(mf)
+	if err != nil {
+		t.Fatalf("%s: %s", file, err)
+	}
+	name := filepath.Join(t.TempDir(), b.PackageName())
+	w, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := b.Build(w); err != nil {
+		t.Fatalf("%s: build: %s", file, err)
+	}
+	return name
+}
+
+// readTags decodes the main header of the package found in file.
+func readTags(t *testing.T, file string) map[int32]interface{} {
+	t.Helper()
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(bs)
+	_, kind, err := readLead(r)
+	if err != nil {
+		t.Fatalf("lead: %s", err)
+	}
+	if _, err := readSignature(r, kind); err != nil {
+		t.Fatalf("signature: %s", err)
+	}
+	tags := make(map[int32]interface{})
+	err = readHeader(r, false, func(tag int32, v interface{}) error {
+		tags[tag] = v
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("header: %s", err)
+	}
+	return tags
+}
+
+func TestRequires(t *testing.T) {
+	tags := readTags(t, buildFixture(t, "testdata/requires.toml", nil))
+	for _, d := range []struct {
+		Name, Flags, Version int32
+		Want                 []string
+	}{
+		{
+			Name:    rpmTagRequireName,
+			Flags:   rpmTagRequireFlags,
+			Version: rpmTagRequireVersion,
+			Want: []string{
+				"libc >= 2.17",
+				"libcap2 >= 1:2.25",
+				"(ping or iputils-ping >= 3:20161105)",
+				"perl(Getopt::Long)",
+			},
+		},
+		{
+			Name:    rpmTagConflictName,
+			Flags:   rpmTagConflictFlags,
+			Version: rpmTagConflictVersion,
+			Want:    []string{"netcheck-legacy < 1.0"},
+		},
+		{
+			Name:    rpmTagProvideName,
+			Flags:   rpmTagProvideFlags,
+			Version: rpmTagProvideVersion,
+			Want:    []string{"netcheck = 1.4.0-1", "netprobe = 1.4.0"},
+		},
+	} {
+		names, _ := tags[d.Name].([]string)
+		flags, _ := tags[d.Flags].([]int64)
+		versions, _ := tags[d.Version].([]string)
+		if len(names) != len(d.Want) || len(flags) != len(names) || len(versions) != len(names) {
+			t.Errorf("%s: arrays not consistent: %d names, %d flags, %d versions", TagName(d.Name), len(names), len(flags), len(versions))
+			continue
+		}
+		got := fieldsToDepends(names, flags, versions)
+		for i := range got {
+			if got[i] != d.Want[i] {
+				t.Errorf("%s[%d]: want %q, got %q", TagName(d.Name), i, d.Want[i], got[i])
+			}
+		}
+	}
+	flags := tags[rpmTagRequireFlags].([]int64)
+	if flags[0] != rpmSenseGreater|rpmSenseEqual {
+		t.Errorf("libc >= 2.17: want flags %d, got %d", rpmSenseGreater|rpmSenseEqual, flags[0])
+	}
+	if flags[2] != rpmSenseAny {
+		t.Errorf("rich dependency: want flags %d, got %d", rpmSenseAny, flags[2])
+	}
+}
+
+type offsetReader struct {
+	r   io.ReaderAt
+	max int64
+}
+
+func (o *offsetReader) ReadAt(bs []byte, off int64) (int, error) {
+	n, err := o.r.ReadAt(bs, off)
+	if e := off + int64(n); e > o.max {
+		o.max = e
+	}
+	return n, err
+}
+
+func TestOpenReaderAt(t *testing.T) {
+	bs, err := ioutil.ReadFile(buildFixture(t, "testdata/remote.toml", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ra := offsetReader{r: bytes.NewReader(bs)}
+	p, err := OpenReaderAt(&ra, int64(len(bs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := p.About(); c.Package != "mirror-tools" || c.Version != "0.9.2" || c.Release != "5" {
+		t.Errorf("unexpected metadata %s-%s-%s", c.Package, c.Version, c.Release)
+	}
+	header := p.(*pkg).header[1]
+	if ra.max > header {
+		t.Errorf("payload read when opening: %d bytes read, header ends at %d", ra.max, header)
+	}
+	files, err := p.Filenames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("want 2 files, got %q", files)
+	}
+	s, err := p.(packit.Signed).Signature(nil)
+	if err != nil || s != nil {
+		t.Errorf("unsigned package: want no signature, got %v (%v)", s, err)
+	}
+}
+
+func TestAbout(t *testing.T) {
+	file := buildFixture(t, "testdata/remote.toml", nil)
+	p, err := Open(file)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	c, err := About(file)
+	if err != nil {
+		t.Fatalf("about: %s", err)
+	}
+	for _, c := range []packit.Control{p.About(), *c} {
+		if c.Package != "mirror-tools" || c.Version != "0.9.2" || c.Summary != "helpers to sync package mirrors" {
+			t.Errorf("unexpected metadata %s-%s (%q)", c.Package, c.Version, c.Summary)
+		}
+	}
+	if p.PackageName() != "mirror-tools-0.9.2" {
+		t.Errorf("unexpected package name %s", p.PackageName())
+	}
+}
+
+func TestIncompressibleWarning(t *testing.T) {
+	for _, d := range []struct {
+		Method string
+		Warn   bool
+	}{
+		{Method: packit.CompressGZ, Warn: true},
+		{Method: packit.CompressNone, Warn: false},
+	} {
+		mf, err := packit.Load("testdata/icons.toml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mf.Compression = d.Method
+		b, err := Build(mf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Build(ioutil.Discard); err != nil {
+			t.Fatalf("%s: build: %s", d.Method, err)
+		}
+		var ws []string
+		if w, ok := b.(packit.Warner); ok {
+			ws = w.Warnings()
+		}
+		switch {
+		case d.Warn && (len(ws) != 1 || !strings.Contains(ws[0], `compression = "none"`)):
+			t.Errorf("%s: want warning suggesting no compression, got %q", d.Method, ws)
+		case !d.Warn && len(ws) > 0:
+			t.Errorf("%s: unexpected warnings %q", d.Method, ws)
+		}
+	}
+}
+
+func TestConfFiles(t *testing.T) {
+	file := buildFixture(t, "testdata/remote.toml", nil)
+	p, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/etc/mirror-tools/mirror.conf"}
+	if got := p.About().ConfFiles; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("config files: want %q, got %q", want, got)
+	}
+	var (
+		tags  = readTags(t, file)
+		flags = tags[rpmTagFileFlags].([]int64)
+		bases = tags[rpmTagBasenames].([]string)
+	)
+	for i := range bases {
+		conf := flags[i]&rpmFileConf == rpmFileConf
+		if conf != (bases[i] == "mirror.conf") {
+			t.Errorf("%s: unexpected file flags %d", bases[i], flags[i])
+		}
+	}
+}
+
+func TestNoMD5(t *testing.T) {
+	for _, nomd5 := range []bool{false, true} {
+		file := buildFixture(t, "testdata/remote.toml", func(mf *packit.Makefile) {
+			mf.NoMD5 = nomd5
+		})
+		r, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		_, kind, err := readLead(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := readSignature(r, kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (s.MD5 == "") != nomd5 {
+			t.Errorf("no-md5=%t: unexpected md5 signature %q", nomd5, s.MD5)
+		}
+		if s.Sha256 == "" {
+			t.Errorf("no-md5=%t: sha256 signature missing", nomd5)
+		}
+		tags := readTags(t, file)
+		size := 32
+		if nomd5 {
+			size = 64
+			if algo, _ := tags[rpmTagFileDigestAlgo].([]int64); len(algo) != 1 || algo[0] != rpmHashSha256 {
+				t.Errorf("no-md5: want file digest algo %d, got %v", rpmHashSha256, tags[rpmTagFileDigestAlgo])
+			}
+		}
+		for _, d := range tags[rpmTagFileDigests].([]string) {
+			if len(d) != size {
+				t.Errorf("no-md5=%t: unexpected file digest %q", nomd5, d)
+			}
+		}
+		p, err := Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Valid(); err != nil {
+			t.Errorf("no-md5=%t: %s", nomd5, err)
+		}
+	}
+}
+
+func TestXZCheck(t *testing.T) {
+	file := buildFixture(t, "testdata/remote.toml", func(mf *packit.Makefile) {
+		mf.Compression, mf.XZCheck = packit.CompressXZ, "crc32"
+	})
+	p, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := p.About().Format; f != "cpio.xz" {
+		t.Errorf("want payload cpio.xz, got %s", f)
+	}
+	if err := p.Valid(); err != nil {
+		t.Errorf("xz payload with crc32 check: %s", err)
+	}
+}
+
+func TestPayloadCompressor(t *testing.T) {
+	for _, d := range []struct {
+		Method string
+		Name   string
+		Flags  string
+	}{
+		{Method: "", Name: "gzip", Flags: "9"},
+		{Method: packit.CompressGZ, Name: "gzip", Flags: "9"},
+		{Method: packit.CompressXZ, Name: "xz", Flags: "6"},
+		{Method: packit.CompressZstd, Name: "zstd", Flags: "19"},
+		{Method: packit.CompressNone, Name: "ufdio", Flags: ""},
+	} {
+		file := buildFixture(t, "testdata/remote.toml", func(mf *packit.Makefile) {
+			mf.Compression = d.Method
+		})
+		tags := readTags(t, file)
+		if c, _ := tags[rpmTagCompressor].(string); c != d.Name {
+			t.Errorf("%s: want PAYLOADCOMPRESSOR %q, got %q", d.Method, d.Name, c)
+		}
+		if f, _ := tags[rpmTagPayloadFlags].(string); f != d.Flags {
+			t.Errorf("%s: want PAYLOADFLAGS %q, got %q", d.Method, d.Flags, f)
+		}
+		p, err := Open(file)
+		if err != nil {
+			t.Fatalf("%s: %s", d.Method, err)
+		}
+		if err := p.Valid(); err != nil {
+			t.Errorf("%s: %s", d.Method, err)
+		}
+	}
+}
+
+func TestXZPayload(t *testing.T) {
+	file := buildFixture(t, "testdata/remote.toml", func(mf *packit.Makefile) {
+		mf.Compression = packit.CompressXZ
+	})
+	tags := readTags(t, file)
+	if c, _ := tags[rpmTagCompressor].(string); c != "xz" {
+		t.Errorf("want compressor xz, got %q", c)
+	}
+	if f, _ := tags[rpmTagPayloadFlags].(string); f != "6" {
+		t.Errorf("want payload flags 6, got %q", f)
+	}
+	p, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := xz.NewReader(bytes.NewReader(bs[p.(*pkg).header[1]:]))
+	if err != nil {
+		t.Fatalf("xz: %s", err)
+	}
+	want := map[string]string{
+		"/usr/bin/mirror-sync":          "testdata/mirror-sync.sh",
+		"/etc/mirror-tools/mirror.conf": "testdata/mirror.conf",
+	}
+	r := cpio.NewReader(z)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("cpio: %s", err)
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r, h.Length))
+		if err != nil {
+			t.Fatalf("%s: %s", h.Filename, err)
+		}
+		src, ok := want[h.Filename]
+		if !ok {
+			t.Errorf("unexpected file %s", h.Filename)
+			continue
+		}
+		delete(want, h.Filename)
+		if orig, _ := ioutil.ReadFile(src); !bytes.Equal(body, orig) {
+			t.Errorf("%s: content differs from %s", h.Filename, src)
+		}
+	}
+	for f := range want {
+		t.Errorf("%s: missing from payload", f)
+	}
+}
+
+func TestSelfProvide(t *testing.T) {
+	file := buildFixture(t, "testdata/remote.toml", func(mf *packit.Makefile) {
+		mf.Control.Epoch, mf.Control.Dist = 2, "el9"
+		mf.Control.Provides = []string{"mirror-tools = 2:0.9.2-5.el9", "mirror-sync"}
+	})
+	var (
+		tags     = readTags(t, file)
+		names    = tags[rpmTagProvideName].([]string)
+		flags    = tags[rpmTagProvideFlags].([]int64)
+		versions = tags[rpmTagProvideVersion].([]string)
+	)
+	got := fieldsToDepends(names, flags, versions)
+	want := []string{"mirror-tools = 2:0.9.2-5.el9", "mirror-sync"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("provides: want %q, got %q", want, got)
+	}
+	p, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps := p.About().Provides; len(ps) != 1 || ps[0] != "mirror-sync" {
+		t.Errorf("self-provide reported as provides: %q", ps)
+	}
+}
+
+func TestWeakDepends(t *testing.T) {
+	for _, weak := range []bool{true, false} {
+		file := buildFixture(t, "testdata/plugins.toml", func(mf *packit.Makefile) {
+			mf.WeakDeps = weak
+		})
+		p, err := Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := p.About()
+		for _, d := range []struct {
+			Field string
+			Got   []string
+			Want  string
+		}{
+			{Field: "suggests", Got: c.Suggests, Want: "netcheck-doc"},
+			{Field: "enhances", Got: c.Enhances, Want: "netcheck >= 1.4.0"},
+			{Field: "supplements", Got: c.Supplements, Want: "openssl >= 3.0"},
+		} {
+			got := strings.Join(d.Got, ", ")
+			if !weak {
+				if got != "" {
+					t.Errorf("%s: written without weak-deps: %q", d.Field, got)
+				}
+				continue
+			}
+			if got != d.Want {
+				t.Errorf("%s: want %q, got %q", d.Field, d.Want, got)
+			}
+		}
+		if tags := readTags(t, file); weak && tags[rpmTagEnhanceFlags] == nil {
+			t.Errorf("enhances: flags tag missing")
+		}
+	}
+}
+
+func TestHardlinkInodes(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		file = filepath.Join(dir, "mirror-sync")
+		link = filepath.Join(dir, "mirror-pull")
+	)
+	bs, err := ioutil.ReadFile("testdata/mirror-sync.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, bs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(file, link); err != nil {
+		t.Skipf("hard links not supported: %s", err)
+	}
+	pkg := buildFixture(t, "testdata/remote.toml", func(mf *packit.Makefile) {
+		mf.Files = append(mf.Files,
+			&packit.File{Src: file, Dst: "/usr/libexec/mirror-tools/", Name: "sync", Perm: 0755},
+			&packit.File{Src: link, Dst: "/usr/libexec/mirror-tools/", Name: "pull", Perm: 0755},
+		)
+	})
+	var (
+		tags   = readTags(t, pkg)
+		bases  = tags[rpmTagBasenames].([]string)
+		inodes = tags[rpmTagFileInodes].([]int64)
+		devs   = tags[rpmTagFileDevices].([]int64)
+	)
+	if len(inodes) != len(bases) || len(devs) != len(bases) {
+		t.Fatalf("want %d inodes and devices, got %d and %d", len(bases), len(inodes), len(devs))
+	}
+	ix := make(map[string]int64)
+	for i, b := range bases {
+		ix[b] = inodes[i]
+	}
+	if ix["sync"] != ix["pull"] {
+		t.Errorf("hard links: want same inode, got %d and %d", ix["sync"], ix["pull"])
+	}
+	if ix["sync"] == ix["mirror-sync"] || ix["mirror-sync"] == ix["mirror.conf"] {
+		t.Errorf("distinct files share an inode: %v", ix)
+	}
+}
+
+// stripSignature replaces the signature header of the package found in bs
+// by the given header.
+func stripSignature(t *testing.T, bs []byte, kind uint16, header []byte) []byte {
+	t.Helper()
+	r := bytes.NewReader(bs)
+	if _, _, err := readLead(r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSignature(r, rpmSigType); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		ix = len(bs) - r.Len()
+		xs = append([]byte{}, bs[:rpmLeadLen]...)
+	)
+	binary.BigEndian.PutUint16(xs[78:], kind)
+	xs = append(xs, header...)
+	return append(xs, bs[ix:]...)
+}
+
+func TestOpenNoSignature(t *testing.T) {
+	bs, err := ioutil.ReadFile(buildFixture(t, "testdata/remote.toml", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		empty = []byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+		dir   = t.TempDir()
+	)
+	for _, d := range []struct {
+		Name   string
+		Kind   uint16
+		Header []byte
+	}{
+		{Name: "none", Kind: rpmSigNone},
+		{Name: "empty", Kind: rpmSigType, Header: empty},
+	} {
+		file := filepath.Join(dir, d.Name+".rpm")
+		if err := ioutil.WriteFile(file, stripSignature(t, bs, d.Kind, d.Header), 0644); err != nil {
+			t.Fatal(err)
+		}
+		p, err := Open(file)
+		if err != nil {
+			t.Errorf("%s: %s", d.Name, err)
+			continue
+		}
+		if c := p.About(); c.Package != "mirror-tools" || c.Version != "0.9.2" {
+			t.Errorf("%s: unexpected metadata %s-%s", d.Name, c.Package, c.Version)
+		}
+		if err := p.Valid(); err != nil {
+			t.Errorf("%s: %s", d.Name, err)
+		}
+	}
+}
+
+func TestMultilineSummary(t *testing.T) {
+	mf, err := packit.Load("testdata/multiline.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Build(mf); err == nil || !strings.HasPrefix(err.Error(), "summary:") {
+		t.Errorf("multi-line summary: want summary error, got %v", err)
+	}
+}
+
+func TestSourceDigest(t *testing.T) {
+	for _, digest := range []bool{true, false} {
+		mf, err := packit.Load("testdata/remote.toml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want string
+		if digest {
+			if want, err = mf.Digest(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		file := buildFixture(t, "testdata/remote.toml", func(mf *packit.Makefile) {
+			mf.DigestSources = digest
+		})
+		p, err := Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.About().SourceDigest; got != want {
+			t.Errorf("source-digest=%t: want %q, got %q", digest, want, got)
+		}
+	}
+}
+
+func TestLeadName(t *testing.T) {
+	bs, err := ioutil.ReadFile(buildFixture(t, "testdata/remote.toml", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, d := range []struct {
+		Name  string
+		Valid bool
+	}{
+		{Name: "mirror-tools-0.9.2", Valid: true},
+		{Name: "mirror-tools", Valid: true},
+		{Name: "mirror-pool-0.9.2"},
+		{Name: "mirror-toolsd-0.9.2"},
+	} {
+		xs := append([]byte{}, bs...)
+		copy(xs[10:76], make([]byte, 66))
+		copy(xs[10:76], d.Name)
+		file := filepath.Join(dir, d.Name+".rpm")
+		if err := ioutil.WriteFile(file, xs, 0644); err != nil {
+			t.Fatal(err)
+		}
+		p, err := Open(file)
+		if err != nil {
+			t.Fatalf("%s: %s", d.Name, err)
+		}
+		if n := p.PackageName(); n != d.Name {
+			t.Errorf("lead name: want %s, got %s", d.Name, n)
+		}
+		err = p.Valid()
+		switch {
+		case d.Valid && err != nil:
+			t.Errorf("%s: %s", d.Name, err)
+		case !d.Valid && (err == nil || !strings.Contains(err.Error(), "lead name does not match")):
+			t.Errorf("%s: want lead name mismatch, got %v", d.Name, err)
+		}
+	}
+}
+
+func TestCheckGroup(t *testing.T) {
+	for _, d := range []struct {
+		File   string
+		Strict bool
+		Warn   bool
+		Fail   bool
+	}{
+		{File: "testdata/remote.toml"},
+		{File: "testdata/remote.toml", Strict: true},
+		{File: "testdata/group.toml", Warn: true},
+		{File: "testdata/group.toml", Strict: true, Fail: true},
+	} {
+		mf, err := packit.Load(d.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mf.Strict = d.Strict
+		b, err := Build(mf)
+		if d.Fail {
+			if err == nil || !strings.Contains(err.Error(), "unknown rpm group") {
+				t.Errorf("%s (strict): want unknown group error, got %v", d.File, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", d.File, err)
+			continue
+		}
+		ws := b.(packit.Warner).Warnings()
+		if warn := len(ws) == 1 && strings.Contains(ws[0], "Aplications/Internet"); warn != d.Warn || (!d.Warn && len(ws) > 0) {
+			t.Errorf("%s: unexpected warnings %q", d.File, ws)
+		}
+	}
+}
+
+func TestSpecialFiles(t *testing.T) {
+	file := buildFixture(t, "testdata/console.toml", nil)
+	p, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := p.Resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	modes := make(map[string]int64)
+	for _, r := range rs {
+		modes[r.Name] = r.Perm
+	}
+	for n, want := range map[string]int64{
+		"/dev/ttyS0":              0020660,
+		"/run/console/control":    0010600,
+		"/usr/sbin/console-setup": 0100755,
+	} {
+		if got, ok := modes[n]; !ok || got != want {
+			t.Errorf("%s: want mode %o, got %o", n, want, got)
+		}
+	}
+	tags := readTags(t, file)
+	var (
+		files = tags[rpmTagFilenames].([]string)
+		perms = tags[rpmTagFileModes].([]int64)
+		rdevs = tags[rpmTagFileRdevs].([]int64)
+	)
+	if len(perms) != len(files) || len(rdevs) != len(files) {
+		t.Fatalf("want %d modes and rdevs, got %d and %d", len(files), len(perms), len(rdevs))
+	}
+	for i, want := range map[string][2]int64{
+		"/dev/ttyS0":              {0020660, 4<<8 | 64},
+		"/run/console/control":    {0010600, 0},
+		"/usr/sbin/console-setup": {0100755, 0},
+	} {
+		x := -1
+		for j := range files {
+			if files[j] == i {
+				x = j
+			}
+		}
+		if x < 0 {
+			t.Errorf("%s: missing from FILENAMES", i)
+			continue
+		}
+		if perms[x] != want[0] || rdevs[x] != want[1] {
+			t.Errorf("%s: want mode %o rdev %d, got %o rdev %d", i, want[0], want[1], perms[x], rdevs[x])
+		}
+	}
+	for i, s := range tags[rpmTagFileSizes].([]int64) {
+		if d := tags[rpmTagFileDigests].([]string)[i]; s == 0 && d != "" {
+			t.Errorf("special file with digest %s", d)
+		}
+	}
+}
+
+func TestScriptPrograms(t *testing.T) {
+	tags := readTags(t, buildFixture(t, "testdata/scripts.toml", nil))
+	for _, d := range []struct {
+		Script int32
+		Prog   int32
+		Want   string
+		Body   string
+	}{
+		{Script: rpmTagPreIn, Prog: rpmTagPreInProg, Want: "/bin/sh", Body: "groupadd -r netcheck"},
+		{Script: rpmTagPostIn, Prog: rpmTagPostInProg, Want: "/usr/bin/python3", Body: "json.dump"},
+		{Script: rpmTagPostUn, Prog: rpmTagPostUnProg, Want: "/bin/bash", Body: "rm -f"},
+	} {
+		if body, _ := tags[d.Script].(string); !strings.Contains(body, d.Body) {
+			t.Errorf("%s: want script with %q, got %q", TagName(d.Script), d.Body, body)
+		}
+		if prog, _ := tags[d.Prog].(string); prog != d.Want {
+			t.Errorf("%s: want %s, got %q", TagName(d.Prog), d.Want, prog)
+		}
+	}
+	for _, tag := range []int32{rpmTagPreUn, rpmTagPreUnProg} {
+		if v, ok := tags[tag]; ok {
+			t.Errorf("%s: written without script: %v", TagName(tag), v)
+		}
+	}
+}
+
+func TestSigned(t *testing.T) {
+	e, err := openpgp.NewEntity("Packaging Team", "", "packaging@example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := buildFixture(t, "testdata/remote.toml", func(mf *packit.Makefile) {
+		mf.Signer = e
+	})
+	p, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := openpgp.EntityList{e}
+	s, err := p.(packit.Signed).Signature(kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s == nil || !s.Verified || s.Signer != "Packaging Team <packaging@example.org>" {
+		t.Fatalf("header signature: want verified by Packaging Team, got %+v", s)
+	}
+
+	r := bytes.NewReader(bs)
+	_, kind, err := readLead(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := readSignature(r, kind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sig.Full) == 0 {
+		t.Fatal("header and payload signature missing")
+	}
+	body := bs[p.(*pkg).header[0]:]
+	if s := packit.CheckSignature(kr, sig.FullKind, bytes.NewReader(body), sig.Full); !s.Verified {
+		t.Errorf("header and payload signature: not verified (%s)", s.Reason)
+	}
+
+	other, err := openpgp.NewEntity("Someone Else", "", "else@example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := p.(packit.Signed).Signature(openpgp.EntityList{other}); err != nil || s.Verified {
+		t.Errorf("signature verified with the wrong key (%v)", err)
+	}
+}
+
+func TestPostInstallScript(t *testing.T) {
+	want := `import json, os
+path = "/var/lib/netcheck/hooks.json"
+os.makedirs(os.path.dirname(path), exist_ok=True)
+with open(path, "w") as f:
+    json.dump({"down": ["/usr/libexec/netcheck/notify"]}, f)
+`
+	file := buildFixture(t, "testdata/scripts.toml", nil)
+	if got, _ := readTags(t, file)[rpmTagPostIn].(string); got != want {
+		t.Errorf("%s: want %q, got %q", TagName(rpmTagPostIn), want, got)
+	}
+	p, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := p.(packit.Scripted).Scripts()[packit.ScriptPostinst]
+	if s == nil || s.String() != want || s.Program() != "/usr/bin/python3" {
+		t.Errorf("%s: script not read back: %+v", packit.ScriptPostinst, s)
+	}
+}
+
+func TestRootOwner(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		src = filepath.Join(dir, "mirror-sync")
+	)
+	bs, err := ioutil.ReadFile("testdata/mirror-sync.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(src, bs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(src, 1000, 1000); err != nil {
+		t.Skipf("can not give the source to a regular user: %s", err)
+	}
+	file := buildFixture(t, "testdata/remote.toml", func(mf *packit.Makefile) {
+		mf.Files[0].Src = src
+	})
+	tags := readTags(t, file)
+	for tag, want := range map[int32]string{rpmTagOwners: packit.DefaultUser, rpmTagGroups: packit.DefaultGroup} {
+		vs, _ := tags[tag].([]string)
+		if len(vs) != 2 {
+			t.Errorf("%s: want 2 values, got %q", TagName(tag), vs)
+		}
+		for _, v := range vs {
+			if v != want {
+				t.Errorf("%s: want %s, got %q", TagName(tag), want, v)
+			}
+		}
+	}
+	p, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := p.Resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rs {
+		if r.Uid != 0 || r.Gid != 0 {
+			t.Errorf("%s: want uid/gid 0 in payload, got %d/%d", r.Name, r.Uid, r.Gid)
+		}
+	}
+}
+
+func TestPayloadDigest(t *testing.T) {
+	for _, method := range []string{packit.CompressGZ, packit.CompressXZ, packit.CompressNone} {
+		file := buildFixture(t, "testdata/remote.toml", func(mf *packit.Makefile) {
+			mf.Compression = method
+		})
+		tags := readTags(t, file)
+		if algo, _ := tags[rpmTagPayloadDigestAlgo].([]int64); len(algo) != 1 || algo[0] != rpmHashSha256 {
+			t.Errorf("%s: want payload digest algo %d, got %v", method, rpmHashSha256, tags[rpmTagPayloadDigestAlgo])
+		}
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := OpenReaderAt(bytes.NewReader(bs), int64(len(bs)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(bs[p.(*pkg).header[1]:])
+		digest, _ := tags[rpmTagPayloadDigest].([]string)
+		if want := hex.EncodeToString(sum[:]); len(digest) != 1 || digest[0] != want {
+			t.Errorf("%s: want payload digest %s, got %q", method, want, digest)
+		}
+	}
+}
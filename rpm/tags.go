@@ -0,0 +1,114 @@
+package rpm
+
+import (
+	"fmt"
+	"sort"
+)
+
+var tagNames = map[int32]string{
+	rpmTagSignatureIndex: "HEADERSIGNATURES",
+	rpmTagImmutableIndex: "HEADERIMMUTABLE",
+	rpmTagI18NTable:      "HEADERI18NTABLE",
+
+	rpmTagPackage:      "NAME",
+	rpmTagVersion:      "VERSION",
+	rpmTagRelease:      "RELEASE",
+	rpmTagEpoch:        "EPOCH",
+	rpmTagSummary:      "SUMMARY",
+	rpmTagDesc:         "DESCRIPTION",
+	rpmTagBuildTime:    "BUILDTIME",
+	rpmTagBuildHost:    "BUILDHOST",
+	rpmTagSize:         "SIZE",
+	rpmTagDistrib:      "DISTRIBUTION",
+	rpmTagVendor:       "VENDOR",
+	rpmTagLicense:      "LICENSE",
+	rpmTagPackager:     "PACKAGER",
+	rpmTagGroup:        "GROUP",
+	rpmTagURL:          "URL",
+	rpmTagOS:           "OS",
+	rpmTagArch:         "ARCH",
+	rpmTagPayload:      "PAYLOADFORMAT",
+	rpmTagCompressor:   "PAYLOADCOMPRESSOR",
+	rpmTagPayloadFlags: "PAYLOADFLAGS",
+	rpmTagFileClass:    "FILECLASS",
+
+	rpmTagFileSizes:   "FILESIZES",
+	rpmTagFileModes:   "FILEMODES",
+	rpmTagFileRdevs:   "FILERDEVS",
+	rpmTagFileTimes:   "FILEMTIMES",
+	rpmTagFileDigests: "FILEDIGESTS",
+	rpmTagFileLinks:   "FILELINKTOS",
+	rpmTagFileFlags:   "FILEFLAGS",
+	rpmTagOwners:      "FILEUSERNAME",
+	rpmTagGroups:      "FILEGROUPNAME",
+	rpmTagFileDevices: "FILEDEVICES",
+	rpmTagFileInodes:  "FILEINODES",
+	rpmTagFileLangs:   "FILELANGS",
+	rpmTagDirIndexes:  "DIRINDEXES",
+	rpmTagBasenames:   "BASENAMES",
+	rpmTagDirnames:    "DIRNAMES",
+
+	rpmTagPreIn:      "PREIN",
+	rpmTagPostIn:     "POSTIN",
+	rpmTagPreUn:      "PREUN",
+	rpmTagPostUn:     "POSTUN",
+	rpmTagPreInProg:  "PREINPROG",
+	rpmTagPostInProg: "POSTINPROG",
+	rpmTagPreUnProg:  "PREUNPROG",
+	rpmTagPostUnProg: "POSTUNPROG",
+
+	rpmTagProvideName:     "PROVIDENAME",
+	rpmTagProvideFlags:    "PROVIDEFLAGS",
+	rpmTagProvideVersion:  "PROVIDEVERSION",
+	rpmTagRequireFlags:    "REQUIREFLAGS",
+	rpmTagRequireName:     "REQUIRENAME",
+	rpmTagRequireVersion:  "REQUIREVERSION",
+	rpmTagConflictFlags:   "CONFLICTFLAGS",
+	rpmTagConflictName:    "CONFLICTNAME",
+	rpmTagConflictVersion: "CONFLICTVERSION",
+	rpmTagObsoleteName:    "OBSOLETENAME",
+	rpmTagObsoleteFlags:   "OBSOLETEFLAGS",
+	rpmTagObsoleteVersion: "OBSOLETEVERSION",
+
+	rpmTagSuggestName:       "SUGGESTNAME",
+	rpmTagSuggestVersion:    "SUGGESTVERSION",
+	rpmTagSuggestFlags:      "SUGGESTFLAGS",
+	rpmTagSupplementName:    "SUPPLEMENTNAME",
+	rpmTagSupplementVersion: "SUPPLEMENTVERSION",
+	rpmTagSupplementFlags:   "SUPPLEMENTFLAGS",
+	rpmTagEnhanceName:       "ENHANCENAME",
+	rpmTagEnhanceVersion:    "ENHANCEVERSION",
+	rpmTagEnhanceFlags:      "ENHANCEFLAGS",
+
+	rpmTagChangeTime: "CHANGELOGTIME",
+	rpmTagChangeName: "CHANGELOGNAME",
+	rpmTagChangeText: "CHANGELOGTEXT",
+
+	rpmTagFilenames:         "FILENAMES",
+	rpmTagFileDigestAlgo:    "FILEDIGESTALGO",
+	rpmTagPayloadDigest:     "PAYLOADDIGEST",
+	rpmTagPayloadDigestAlgo: "PAYLOADDIGESTALGO",
+	rpmTagSourceDigest:      "SOURCEDIGEST",
+	rpmTagBugURL:            "BUGURL",
+	rpmTagEncoding:          "ENCODING",
+}
+
+// TagName gives the name of a tag of the main header as found in rpmtag.h
+// without its RPMTAG_ prefix. SOURCEDIGEST is the exception: it is private to
+// packit and unknown to rpm.
+func TagName(tag int32) string {
+	if n, ok := tagNames[tag]; ok {
+		return n
+	}
+	return fmt.Sprintf("TAG_%d", tag)
+}
+
+// Tags returns the sorted list of the tags known by the package.
+func Tags() []int32 {
+	ts := make([]int32, 0, len(tagNames))
+	for t := range tagNames {
+		ts = append(ts, t)
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+	return ts
+}
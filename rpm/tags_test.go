@@ -0,0 +1,35 @@
+package rpm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/midbel/packit"
+)
+
+func TestSourceDigestTag(t *testing.T) {
+	tags := readTags(t, buildFixture(t, "testdata/remote.toml", func(mf *packit.Makefile) {
+		mf.DigestSources = true
+	}))
+	v, ok := tags[rpmTagSourceDigest].(string)
+	if !ok || v == "" {
+		t.Fatalf("SOURCEDIGEST missing from header")
+	}
+	var last int32
+	for _, tag := range Tags() {
+		if tag != rpmTagSourceDigest && tag > last {
+			last = tag
+		}
+	}
+	if rpmTagSourceDigest <= last {
+		t.Errorf("SOURCEDIGEST (%d) overlaps the tags of rpm (up to %d)", rpmTagSourceDigest, last)
+	}
+	for _, tag := range Tags() {
+		if n := TagName(tag); n == "" || strings.HasPrefix(n, "TAG_") {
+			t.Errorf("%d: tag without name", tag)
+		}
+	}
+	if n := TagName(rpmTagSourceDigest + 1); n != "TAG_100001" {
+		t.Errorf("unknown tag: want TAG_100001, got %s", n)
+	}
+}
@@ -0,0 +1,3 @@
+#!/bin/sh
+set -e
+rsync -a --delete "$1" "$2"
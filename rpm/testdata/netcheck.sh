@@ -0,0 +1,2 @@
+#!/bin/sh
+for h in "$@"; do ping -c1 -W1 "$h" >/dev/null || echo "$h down"; done
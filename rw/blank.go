@@ -18,6 +18,10 @@ func Clean(w io.Writer) io.Writer {
 }
 
 func (b *blank) Write(bs []byte) (int, error) {
+	// text/template writes empty fields, eg Section, as empty writes.
+	if len(bs) == 0 {
+		return 0, nil
+	}
 	var (
 		xs     []byte
 		offset int
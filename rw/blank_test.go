@@ -0,0 +1,23 @@
+package rw
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		w   = Clean(&buf)
+	)
+	for _, s := range []string{"Package: tool\n", "", "\n", "Section: ", "", "\n\n", "Priority: optional\n"} {
+		n, err := w.Write([]byte(s))
+		if err != nil || n != len(s) {
+			t.Fatalf("write %q: got %d (%v)", s, n, err)
+		}
+	}
+	want := "Package: tool\nSection: \nPriority: optional\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
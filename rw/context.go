@@ -0,0 +1,22 @@
+package rw
+
+import (
+	"context"
+	"io"
+)
+
+type reader struct {
+	io.Reader
+	ctx context.Context
+}
+
+func Context(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{Reader: r, ctx: ctx}
+}
+
+func (r *reader) Read(bs []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(bs)
+}
@@ -0,0 +1,25 @@
+package rw
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := Context(ctx, strings.NewReader("Package: tool\nVersion: 1.0\n"))
+
+	bs := make([]byte, 8)
+	if n, err := r.Read(bs); err != nil || string(bs[:n]) != "Package:" {
+		t.Fatalf("read before cancel: got %q (%v)", bs[:n], err)
+	}
+	cancel()
+	if n, err := r.Read(bs); n != 0 || err != context.Canceled {
+		t.Errorf("read after cancel: want %v, got %d bytes (%v)", context.Canceled, n, err)
+	}
+	if _, err := ioutil.ReadAll(r); err != context.Canceled {
+		t.Errorf("read all after cancel: want %v, got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,124 @@
+package packit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+type Signature struct {
+	Kind     string
+	Signer   string
+	Verified bool
+	Reason   string
+}
+
+type Signed interface {
+	Signature(openpgp.KeyRing) (*Signature, error)
+}
+
+func ReadKeyRing(file string) (openpgp.EntityList, error) {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if isArmored(bs) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(bs))
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(bs))
+}
+
+func ReadSigner(file, passphrase string) (*openpgp.Entity, error) {
+	es, err := ReadKeyRing(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range es {
+		if e.PrivateKey == nil {
+			continue
+		}
+		if e.PrivateKey.Encrypted {
+			if passphrase == "" {
+				return nil, fmt.Errorf("%s: private key is encrypted", file)
+			}
+			if err := e.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+				return nil, err
+			}
+		}
+		return e, nil
+	}
+	return nil, fmt.Errorf("%s: no private key found", file)
+}
+
+func Sign(e *openpgp.Entity, r io.Reader) ([]byte, error) {
+	var sig bytes.Buffer
+	if err := openpgp.DetachSign(&sig, e, r, nil); err != nil {
+		return nil, err
+	}
+	return sig.Bytes(), nil
+}
+
+func VerifyDetached(kr openpgp.KeyRing, file, sig string) (*Signature, error) {
+	bs, err := ioutil.ReadFile(sig)
+	if err != nil {
+		return nil, err
+	}
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return CheckSignature(kr, "detached", r, bs), nil
+}
+
+func CheckSignature(kr openpgp.KeyRing, kind string, signed io.Reader, sig []byte) *Signature {
+	s := Signature{Kind: kind}
+	if kr == nil {
+		s.Reason = "no keyring"
+		return &s
+	}
+	var (
+		e   *openpgp.Entity
+		err error
+	)
+	if isArmored(sig) {
+		e, err = openpgp.CheckArmoredDetachedSignature(kr, signed, bytes.NewReader(sig))
+	} else {
+		e, err = openpgp.CheckDetachedSignature(kr, signed, bytes.NewReader(sig))
+	}
+	if err != nil {
+		s.Reason = err.Error()
+		return &s
+	}
+	s.Verified, s.Signer = true, signerName(e)
+	return &s
+}
+
+// signerName gives the primary identity of e or, when none is flagged as
+// primary, the first of its identities in lexical order.
+func signerName(e *openpgp.Entity) string {
+	var ps, ns []string
+	for _, i := range e.Identities {
+		if s := i.SelfSignature; s != nil && s.IsPrimaryId != nil && *s.IsPrimaryId {
+			ps = append(ps, i.Name)
+		}
+		ns = append(ns, i.Name)
+	}
+	if len(ps) > 0 {
+		ns = ps
+	}
+	if len(ns) == 0 {
+		return ""
+	}
+	sort.Strings(ns)
+	return ns[0]
+}
+
+func isArmored(bs []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(bs), []byte("-----BEGIN PGP"))
+}
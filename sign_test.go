@@ -0,0 +1,74 @@
+package packit
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func TestCheckSignatureSigner(t *testing.T) {
+	e, err := openpgp.NewEntity("Packaging Team", "", "packaging@example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Identities["Alice <alice@example.org>"] = &openpgp.Identity{
+		Name:          "Alice <alice@example.org>",
+		SelfSignature: &packet.Signature{},
+	}
+	const msg = "Package: tool\nVersion: 1.0.0\n"
+	sig, err := Sign(e, strings.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := openpgp.EntityList{e}
+	for i := 0; i < 8; i++ {
+		s := CheckSignature(kr, "detached", strings.NewReader(msg), sig)
+		if !s.Verified {
+			t.Fatalf("signature not verified: %s", s.Reason)
+		}
+		if want := "Packaging Team <packaging@example.org>"; s.Signer != want {
+			t.Fatalf("want signer %q, got %q", want, s.Signer)
+		}
+	}
+	s := CheckSignature(kr, "detached", strings.NewReader(msg+"Arch: all\n"), sig)
+	if s.Verified {
+		t.Errorf("signature verified for altered message")
+	}
+}
+
+func TestSignerName(t *testing.T) {
+	var (
+		yes = true
+		no  = false
+	)
+	data := []struct {
+		Primary map[string]*bool
+		Want    string
+	}{
+		{
+			Primary: map[string]*bool{"zoe <zoe@example.org>": &yes, "bob <bob@example.org>": &no, "alice <alice@example.org>": nil},
+			Want:    "zoe <zoe@example.org>",
+		},
+		{
+			Primary: map[string]*bool{"zoe <zoe@example.org>": nil, "bob <bob@example.org>": &no, "carol <carol@example.org>": nil},
+			Want:    "bob <bob@example.org>",
+		},
+		{
+			Primary: map[string]*bool{},
+			Want:    "",
+		},
+	}
+	for _, d := range data {
+		e := openpgp.Entity{Identities: make(map[string]*openpgp.Identity)}
+		for n, p := range d.Primary {
+			e.Identities[n] = &openpgp.Identity{Name: n, SelfSignature: &packet.Signature{IsPrimaryId: p}}
+		}
+		for i := 0; i < 8; i++ {
+			if got := signerName(&e); got != d.Want {
+				t.Fatalf("want signer %q, got %q", d.Want, got)
+			}
+		}
+	}
+}
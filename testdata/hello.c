@@ -0,0 +1,8 @@
+/* hello.debug is built from this file with: gcc -g -Os -o hello.debug hello.c */
+#include <stdio.h>
+
+int main(int argc, char **argv) {
+	const char *who = argc > 1 ? argv[1] : "world";
+	printf("hello %s\n", who);
+	return 0;
+}
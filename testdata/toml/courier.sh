@@ -0,0 +1,2 @@
+#!/bin/sh
+exec /usr/sbin/sendmail -t -oi "$@"
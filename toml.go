@@ -0,0 +1,134 @@
+package packit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// WriteTOML writes the makefile as a document that Load can decode back.
+// Fields with zero values are omitted.
+func (mf *Makefile) WriteTOML(w io.Writer) error {
+	ws := bufio.NewWriter(w)
+	writeTable(ws, "", reflect.ValueOf(mf).Elem())
+	return ws.Flush()
+}
+
+func writeTable(w *bufio.Writer, prefix string, v reflect.Value) {
+	type table struct {
+		Name  string
+		Value reflect.Value
+		Array bool
+	}
+	var tables []table
+	for i, t := 0, v.Type(); i < t.NumField(); i++ {
+		f, x := t.Field(i), v.Field(i)
+		name := tomlName(f)
+		if name == "-" || f.PkgPath != "" || x.IsZero() {
+			continue
+		}
+		if x.Kind() == reflect.Ptr {
+			x = x.Elem()
+		}
+		switch {
+		case x.Kind() == reflect.Struct && x.Type() != reflect.TypeOf(time.Time{}):
+			tables = append(tables, table{Name: name, Value: x})
+		case x.Kind() == reflect.Slice && isStruct(x.Type().Elem()):
+			tables = append(tables, table{Name: name, Value: x, Array: true})
+		default:
+			fmt.Fprintf(w, "%s = %s\n", name, tomlValue(x))
+		}
+	}
+	for _, t := range tables {
+		name := t.Name
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if !t.Array {
+			fmt.Fprintf(w, "\n[%s]\n", name)
+			writeTable(w, name, t.Value)
+			continue
+		}
+		for i := 0; i < t.Value.Len(); i++ {
+			x := t.Value.Index(i)
+			if x.Kind() == reflect.Ptr {
+				if x.IsNil() {
+					continue
+				}
+				x = x.Elem()
+			}
+			fmt.Fprintf(w, "\n[[%s]]\n", name)
+			writeTable(w, name, x)
+		}
+	}
+}
+
+func tomlName(f reflect.StructField) string {
+	tag := f.Tag.Get("toml")
+	if i := strings.Index(tag, ","); i >= 0 {
+		tag = tag[:i]
+	}
+	if tag == "" {
+		tag = strings.ToLower(f.Name)
+	}
+	return tag
+}
+
+func isStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+func tomlValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return tomlQuote(v.String())
+	case reflect.Bool:
+		return fmt.Sprint(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprint(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprint(v.Uint())
+	case reflect.Slice, reflect.Array:
+		vs := make([]string, v.Len())
+		for i := range vs {
+			vs[i] = tomlValue(v.Index(i))
+		}
+		return "[" + strings.Join(vs, ", ") + "]"
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339)
+	}
+	return tomlQuote(fmt.Sprint(v.Interface()))
+}
+
+func tomlQuote(s string) string {
+	var str strings.Builder
+	str.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			str.WriteByte('\\')
+			str.WriteRune(r)
+		case '\n':
+			str.WriteString("\\n")
+		case '\t':
+			str.WriteString("\\t")
+		case '\r':
+			str.WriteString("\\r")
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&str, "\\u%04X", r)
+			} else {
+				str.WriteRune(r)
+			}
+		}
+	}
+	str.WriteByte('"')
+	return str.String()
+}
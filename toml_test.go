@@ -0,0 +1,52 @@
+package packit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteTOML(t *testing.T) {
+	mf, err := Load("testdata/toml/courier.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mf.Summary != `deliver "local" mail` || !strings.Contains(mf.Desc, "\t-v\tverbose\\debug") || mf.Changes == nil {
+		t.Fatalf("fixture not decoded as expected: %q %q", mf.Summary, mf.Desc)
+	}
+	var buf bytes.Buffer
+	if err := mf.WriteTOML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "courier.toml")
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	back, err := Load(file)
+	if err != nil {
+		t.Fatalf("load written makefile: %s\n%s", err, buf.Bytes())
+	}
+	if !reflect.DeepEqual(mf.Control, back.Control) {
+		t.Errorf("metadata differs:\nwant %+v\ngot  %+v", *mf.Control, *back.Control)
+	}
+	if len(back.Files) != len(mf.Files) {
+		t.Fatalf("want %d files, got %d", len(mf.Files), len(back.Files))
+	}
+	for i := range mf.Files {
+		if !reflect.DeepEqual(mf.Files[i], back.Files[i]) {
+			t.Errorf("file %d differs:\nwant %+v\ngot  %+v", i, *mf.Files[i], *back.Files[i])
+		}
+	}
+	if !reflect.DeepEqual(mf.Changes, back.Changes) {
+		t.Errorf("changelog differs")
+	}
+	if !reflect.DeepEqual(mf.Preinst, back.Preinst) || back.Postinst != nil {
+		t.Errorf("scripts differ: want %+v, got %+v", mf.Preinst, back.Preinst)
+	}
+	if back.Compression != mf.Compression || back.WeakDeps != mf.WeakDeps {
+		t.Errorf("options differ: want %s/%t, got %s/%t", mf.Compression, mf.WeakDeps, back.Compression, back.WeakDeps)
+	}
+}
@@ -0,0 +1,52 @@
+package packit
+
+import (
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+type Workers struct {
+	group errgroup.Group
+	sema  chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func NewWorkers(n int) *Workers {
+	if n <= 0 {
+		n = 1
+	}
+	return &Workers{sema: make(chan struct{}, n)}
+}
+
+func (w *Workers) Go(fn func() error) error {
+	if err := w.failed(); err != nil {
+		return err
+	}
+	w.sema <- struct{}{}
+	w.group.Go(func() error {
+		defer func() { <-w.sema }()
+		err := fn()
+		if err != nil {
+			w.mu.Lock()
+			if w.err == nil {
+				w.err = err
+			}
+			w.mu.Unlock()
+		}
+		return err
+	})
+	return nil
+}
+
+func (w *Workers) Wait() error {
+	return w.group.Wait()
+}
+
+func (w *Workers) failed() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
@@ -0,0 +1,60 @@
+package packit
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkers(t *testing.T) {
+	var (
+		wk      = NewWorkers(4)
+		running int32
+		max     int32
+	)
+	for i := 0; i < 32; i++ {
+		err := wk.Go(func() error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&max)
+				if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wk.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if max > 4 {
+		t.Errorf("want at most 4 workers running, got %d", max)
+	}
+}
+
+func TestWorkersAbort(t *testing.T) {
+	var (
+		wk   = NewWorkers(2)
+		fail = errors.New("disk full")
+	)
+	if err := wk.Go(func() error { return fail }); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = wk.Go(func() error { return nil })
+		time.Sleep(time.Millisecond)
+	}
+	if err != fail {
+		t.Errorf("Go: want %v once a worker failed, got %v", fail, err)
+	}
+	if err := wk.Wait(); err != fail {
+		t.Errorf("Wait: want %v, got %v", fail, err)
+	}
+}